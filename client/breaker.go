@@ -0,0 +1,188 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package client
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ErrOpenCircuit is returned without calling the server while its circuit is open.
+var ErrOpenCircuit = errors.New("circuit open")
+
+// DefaultBackoff is the default time to wait before trying again to reach a server.
+var DefaultBackoff = Backoff{Min: time.Second, Max: time.Minute}
+
+// DefaultThreshold is the default number of consecutive failures opening the circuit.
+var DefaultThreshold = 3
+
+// List of states of the circuit breaker.
+const (
+	// Closed: the calls are sent to the server.
+	Closed State = iota
+	// Open: the server is unreachable, the calls fail fast.
+	Open
+	// HalfOpen: the backoff has elapsed, one trial tries the server again.
+	HalfOpen
+)
+
+// State is the state of the circuit breaker.
+type State int
+
+// String implements the fmt.Stringer interface.
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Backoff computes the time to wait before the next attempt.
+// Starting from Min, it is doubled at each failed attempt until Max.
+// A random jitter of at most half of it is removed to spread the attempts of the clients.
+type Backoff struct {
+	Min, Max time.Duration
+}
+
+// Duration returns the time to wait after this number of failed attempts.
+func (b Backoff) Duration(attempt int) time.Duration {
+	d := b.Min
+	for i := 0; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if half := int64(d / 2); half > 0 {
+		d -= time.Duration(rand.Int63n(half + 1))
+	}
+	return d
+}
+
+// breaker is a circuit breaker.
+// After threshold consecutive failures, the circuit opens until the backoff elapsed.
+// Then, it is half-open for one trial, the other calls still fail fast:
+// its success closes it, its failure opens it again for a longer time.
+// With probed, the trials are left to the probe of the server, not to the calls.
+type breaker struct {
+	backoff   Backoff
+	threshold int
+	onChange  func(from, to State)
+	probed    bool
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	attempt  int
+	retry    time.Time
+}
+
+func newBreaker() *breaker {
+	return &breaker{backoff: DefaultBackoff, threshold: DefaultThreshold}
+}
+
+// allow returns true if a call can be sent to the server.
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	switch {
+	case b.state == Closed:
+		b.mu.Unlock()
+		return true
+	case b.probed:
+		b.mu.Unlock()
+		return false
+	}
+	return b.trial(now)
+}
+
+// probe returns true if the server can be probed: the circuit is open and its backoff elapsed.
+// The probe is then the trial of the half-open circuit.
+func (b *breaker) probe(now time.Time) bool {
+	b.mu.Lock()
+	return b.trial(now)
+}
+
+// trial half-opens the circuit for one trial if it is open and its backoff elapsed.
+// The caller must hold the lock, released by it.
+func (b *breaker) trial(now time.Time) bool {
+	if b.state != Open || now.Before(b.retry) {
+		b.mu.Unlock()
+		return false
+	}
+	b.change(HalfOpen)
+	return true
+}
+
+// current returns the state of the circuit.
+func (b *breaker) current() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// done records the result of a call.
+// Only the errors proving that the server is unreachable are failures.
+func (b *breaker) done(now time.Time, err error) {
+	if err != nil && !unreachable(err) {
+		err = nil
+	}
+	b.mu.Lock()
+	if err == nil {
+		b.failures, b.attempt = 0, 0
+		if b.state == Closed {
+			b.mu.Unlock()
+			return
+		}
+		b.change(Closed)
+		return
+	}
+	b.failures++
+	switch b.state {
+	case Closed:
+		if b.failures < b.threshold {
+			b.mu.Unlock()
+			return
+		}
+	case HalfOpen:
+		b.attempt++
+	case Open:
+		b.mu.Unlock()
+		return
+	}
+	b.retry = now.Add(b.backoff.Duration(b.attempt))
+	b.change(Open)
+}
+
+// change sets the state and notifies the change.
+// The caller must hold the lock, released by it.
+func (b *breaker) change(to State) {
+	from := b.state
+	b.state = to
+	b.mu.Unlock()
+	if b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
+
+// unreachable returns true if the error comes from the connection to the server,
+// or if the server did not answer in time.
+func unreachable(err error) bool {
+	switch err {
+	case ErrConn, ErrTimeout, rpc.ErrShutdown, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
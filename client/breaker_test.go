@@ -0,0 +1,166 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package client_test
+
+import (
+	netrpc "net/rpc"
+	"testing"
+	"time"
+
+	"github.com/rvflash/eve/client"
+	cache "github.com/rvflash/eve/rpc"
+)
+
+// downRPC is a test's RPC client whose server can be unreachable.
+type downRPC struct {
+	down  bool
+	calls int
+}
+
+// Call implements the client.Caller interface
+func (c *downRPC) Call(service string, args, reply interface{}) error {
+	c.calls++
+	if c.down {
+		return netrpc.ErrShutdown
+	}
+	if service == "Cache.Get" {
+		return cache.ErrNotFound
+	}
+	return nil
+}
+
+// Close implements the client.Caller interface
+func (c *downRPC) Close() error {
+	return nil
+}
+
+func TestBackoffDuration(t *testing.T) {
+	b := client.Backoff{Min: 100 * time.Millisecond, Max: time.Second}
+	var dt = []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 0, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 1, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{attempt: 3, min: 400 * time.Millisecond, max: 800 * time.Millisecond},
+		{attempt: 10, min: 500 * time.Millisecond, max: time.Second},
+	}
+	for i, tt := range dt {
+		if d := b.Duration(tt.attempt); d < tt.min || d > tt.max {
+			t.Errorf("%d. duration mismatch: got=%v exp=[%v, %v]", i, d, tt.min, tt.max)
+		}
+	}
+}
+
+func TestRPCBreaker(t *testing.T) {
+	var changes []string
+	conn := &downRPC{}
+	c := client.NewRPC(
+		conn,
+		client.WithThreshold(2),
+		client.WithBackoff(client.Backoff{Min: 20 * time.Millisecond, Max: 20 * time.Millisecond}),
+		client.WithStateChange(func(from, to client.State) {
+			changes = append(changes, from.String()+">"+to.String())
+		}),
+	)
+	// A server's error is not a failure.
+	for i := 0; i < 3; i++ {
+		if _, err := c.Raw(dataBool); err != cache.ErrNotFound {
+			t.Fatalf("%d. error mismatch: got=%q exp=%q", i, err, cache.ErrNotFound)
+		}
+	}
+	if s := c.State(); s != client.Closed {
+		t.Fatalf("state mismatch: got=%v exp=%v", s, client.Closed)
+	}
+	// Opens the circuit.
+	conn.down = true
+	for i := 0; i < 2; i++ {
+		if _, err := c.Raw(dataBool); err != netrpc.ErrShutdown {
+			t.Fatalf("%d. error mismatch: got=%q exp=%q", i, err, netrpc.ErrShutdown)
+		}
+	}
+	if s := c.State(); s != client.Open {
+		t.Fatalf("state mismatch: got=%v exp=%v", s, client.Open)
+	}
+	calls := conn.calls
+	if _, err := c.Raw(dataBool); err != client.ErrOpenCircuit {
+		t.Fatalf("error mismatch: got=%q exp=%q", err, client.ErrOpenCircuit)
+	}
+	if c.Available() {
+		t.Fatal("expected unavailable server")
+	}
+	if conn.calls != calls {
+		t.Fatalf("calls mismatch: got=%d exp=%d", conn.calls, calls)
+	}
+	// Closes it after the backoff.
+	conn.down = false
+	time.Sleep(25 * time.Millisecond)
+	if !c.Available() {
+		t.Fatal("expected available server")
+	}
+	exp := []string{"closed>open", "open>half-open", "half-open>closed"}
+	if len(changes) != len(exp) {
+		t.Fatalf("changes mismatch: got=%v exp=%v", changes, exp)
+	}
+	for i, v := range exp {
+		if changes[i] != v {
+			t.Errorf("%d. change mismatch: got=%q exp=%q", i, changes[i], v)
+		}
+	}
+}
+
+// slowRPC is a test's RPC client whose calls wait for their release.
+type slowRPC struct {
+	down    bool
+	release chan struct{}
+}
+
+// Call implements the client.Caller interface
+func (c *slowRPC) Call(service string, args, reply interface{}) error {
+	if c.down {
+		return netrpc.ErrShutdown
+	}
+	<-c.release
+	return nil
+}
+
+// Close implements the client.Caller interface
+func (c *slowRPC) Close() error {
+	return nil
+}
+
+func TestRPCBreakerTrial(t *testing.T) {
+	conn := &slowRPC{down: true, release: make(chan struct{})}
+	c := client.NewRPC(
+		conn,
+		client.WithThreshold(1),
+		client.WithBackoff(client.Backoff{Min: 10 * time.Millisecond, Max: 10 * time.Millisecond}),
+	)
+	if _, err := c.Stats(); err != netrpc.ErrShutdown {
+		t.Fatalf("error mismatch: got=%q exp=%q", err, netrpc.ErrShutdown)
+	}
+	conn.down = false
+	time.Sleep(15 * time.Millisecond)
+
+	// Only one trial is sent to the server while the circuit is half-open.
+	done := make(chan error)
+	go func() {
+		_, err := c.Stats()
+		done <- err
+	}()
+	for c.State() != client.HalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Stats(); err != client.ErrOpenCircuit {
+		t.Fatalf("error mismatch: got=%q exp=%q", err, client.ErrOpenCircuit)
+	}
+	close(conn.release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if s := c.State(); s != client.Closed {
+		t.Fatalf("state mismatch: got=%v exp=%v", s, client.Closed)
+	}
+}
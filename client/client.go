@@ -15,6 +15,7 @@ const (
 	FloatVal
 	IntVal
 	StringVal
+	ListVal
 )
 
 // Asserter must be implemented by any client
@@ -41,6 +42,12 @@ type Getter interface {
 	Lookup(key string) (interface{}, bool)
 }
 
+// MultiGetter must be implemented by any client able to get
+// the data of several keys in one call.
+type MultiGetter interface {
+	MultiGet(keys ...string) (map[string]interface{}, error)
+}
+
 // Setter must be implemented by any client to set data.
 type Setter interface {
 	Set(key string, value interface{}) error
@@ -57,4 +64,5 @@ var (
 	ErrConn    = errors.New("missing connection")
 	ErrFailure = errors.New("request has failed")
 	ErrKind    = errors.New("invalid data type")
+	ErrTimeout = errors.New("request has timed out")
 )
@@ -7,6 +7,7 @@ package client
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 // OS is the client to get environment variable from operating system.
@@ -32,6 +33,15 @@ func (o *OS) Assert(value interface{}, typ Kind) (interface{}, bool) {
 		return d, err == nil
 	case StringVal:
 		return s, true
+	case ListVal:
+		// The items of the list are separated by commas.
+		l := make([]string, 0)
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				l = append(l, v)
+			}
+		}
+		return l, true
 	}
 	return nil, false
 }
@@ -6,6 +6,7 @@ package client_test
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/rvflash/eve/client"
@@ -52,13 +53,14 @@ func TestOSAssert(t *testing.T) {
 		{in: "42", out: 42, kind: client.IntVal, ok: true},
 		{in: "3.14", out: 3.14, kind: client.FloatVal, ok: true},
 		{in: "true", out: true, kind: client.BoolVal, ok: true},
+		{in: "a, b,", out: []string{"a", "b"}, kind: client.ListVal, ok: true},
 	}
 	for i, tt := range dt {
 		out, ok := osClient.Assert(tt.in, tt.kind)
 		if ok != tt.ok {
 			t.Fatalf("%d. assert mismatch: got=%t exp=%t", i, ok, tt.ok)
 		}
-		if out != tt.out {
+		if !reflect.DeepEqual(out, tt.out) {
 			t.Errorf("%d. content mismatch: got=%q exp=%q", i, out, tt.out)
 		}
 	}
@@ -5,9 +5,13 @@
 package client
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"net"
 	"net/rpc"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cache "github.com/rvflash/eve/rpc"
@@ -15,16 +19,35 @@ import (
 
 // OpenRPC returns an instance of RPC with a TCP connection into it.
 // DSN is in the form of "localhost:9090".
+// The timeout limits the time to connect to the server, then the duration of each call.
+// Options can be used to secure the connection with TLS or a token,
+// to open a pool of connections or to tune the circuit breaker.
 // If the connection fails, it returns the error.
 // Unlike NewRPC, OpenRPC has an internal mechanism to reconnect on failure.
-func OpenRPC(dsn string, timeout time.Duration) (*RPC, error) {
-	conn, err := newRPC(dsn, timeout)
+// While the circuit is open, the server is probed by this mechanism, not by the calls.
+func OpenRPC(dsn string, timeout time.Duration, opts ...Option) (*RPC, error) {
 	c := &RPC{
-		c:       conn,
+		cb:      newBreaker(),
 		dsn:     dsn,
 		tick:    time.NewTicker(time.Second),
 		timeout: timeout,
 	}
+	c.cb.probed = true
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.size < 1 {
+		c.size = 1
+	}
+	var err error
+	c.pool = make([]Caller, c.size)
+	for i := range c.pool {
+		conn, cerr := c.dial()
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+		c.pool[i] = conn
+	}
 	go func() {
 		for range c.tick.C {
 			c.reconnectOnFail()
@@ -33,43 +56,232 @@ func OpenRPC(dsn string, timeout time.Duration) (*RPC, error) {
 	return c, err
 }
 
-func newRPC(dsn string, timeout time.Duration) (*rpc.Client, error) {
-	c, err := net.DialTimeout("tcp", dsn, timeout)
+// Option is a functional option used to configure the RPC client.
+type Option func(*RPC)
+
+// WithTLS secures the connection with this TLS configuration.
+func WithTLS(cfg *tls.Config) Option {
+	return func(r *RPC) {
+		r.tls = cfg
+	}
+}
+
+// WithToken authenticates the connection with this token.
+func WithToken(token string) Option {
+	return func(r *RPC) {
+		r.token = token
+	}
+}
+
+// WithPoolSize opens this number of connections to the cache server.
+// The calls are dispatched on them in turn.
+func WithPoolSize(size int) Option {
+	return func(r *RPC) {
+		r.size = size
+	}
+}
+
+// WithNamespace scopes the data of the connection to this namespace.
+func WithNamespace(ns string) Option {
+	return func(r *RPC) {
+		r.ns = ns
+	}
+}
+
+// WithBackoff changes the time to wait before trying again to reach
+// the server once its circuit is open.
+func WithBackoff(b Backoff) Option {
+	return func(r *RPC) {
+		r.cb.backoff = b
+	}
+}
+
+// WithThreshold changes the number of consecutive failures opening the circuit.
+func WithThreshold(failures int) Option {
+	return func(r *RPC) {
+		r.cb.threshold = failures
+	}
+}
+
+// WithStateChange calls this function on each change of the state of the circuit.
+func WithStateChange(fn func(from, to State)) Option {
+	return func(r *RPC) {
+		r.cb.onChange = fn
+	}
+}
+
+// Codec is the wire codec used to call the cache server.
+type Codec int
+
+// List of available codecs.
+const (
+	// Gob is the default codec of net/rpc.
+	Gob Codec = iota
+	// JSONRPC uses the JSON-RPC 2.0 protocol.
+	JSONRPC
+)
+
+// WithCodec uses this codec to call the cache server.
+func WithCodec(codec Codec) Option {
+	return func(r *RPC) {
+		r.codec = codec
+	}
+}
+
+// NewTLSConfig returns a TLS configuration to connect to the cache servers.
+// The CA file is used to verify the certificate of the servers,
+// if empty, the host's root CA set is used.
+// The certificate and its key are only required by the servers verifying the clients.
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, ErrFailure
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// dial connects to the cache server, authenticates the connection
+// and changes its namespace if required.
+func (r *RPC) dial() (*rpc.Client, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if r.tls != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: r.timeout}, "tcp", r.dsn, r.tls)
+	} else {
+		conn, err = net.DialTimeout("tcp", r.dsn, r.timeout)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return rpc.NewClient(c), nil
+	var c *rpc.Client
+	switch r.codec {
+	case JSONRPC:
+		c = rpc.NewClientWithCodec(cache.NewJSONClientCodec(conn))
+	default:
+		c = rpc.NewClient(conn)
+	}
+	var ok bool
+	if r.token != "" {
+		if err = c.Call("Cache.Auth", r.token, &ok); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	if r.ns != "" {
+		if err = c.Call("Cache.Use", r.ns, &ok); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 // NewRPC returns a new instance of RPC.
 // This instance has no mechanism to reconnect on failure.
-func NewRPC(conn Caller) *RPC {
-	return &RPC{c: conn}
+// Only the options of the circuit breaker are used.
+func NewRPC(conn Caller, opts ...Option) *RPC {
+	c := &RPC{pool: []Caller{conn}, size: 1, cb: newBreaker()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// RPC is client with connection to cache'service.
+// RPC is client with a pool of connections to cache'service.
+// A circuit breaker fails fast the calls while the server is unreachable.
 type RPC struct {
-	c       Caller
+	cb      *breaker
+	pool    []Caller
+	next    uint32
+	size    int
 	dsn     string
-	mu      sync.Mutex
+	mu      sync.RWMutex
 	tick    *time.Ticker
 	timeout time.Duration
+	tls     *tls.Config
+	token   string
+	ns      string
+	codec   Codec
 }
 
+// reconnectOnFail replaces each broken connection of the pool by a new one.
+// While the circuit is open, the server is only probed once the backoff elapsed:
+// the connections are replaced, then one call checks the server.
 func (r *RPC) reconnectOnFail() {
-	if r.Available() {
-		return
+	switch r.cb.current() {
+	case Closed:
+		if err := r.redial(); err != nil {
+			r.cb.done(time.Now(), err)
+		}
+	case Open:
+		if !r.cb.probe(time.Now()) {
+			return
+		}
+		err := r.redial()
+		if err == nil {
+			r.mu.RLock()
+			c := r.pool[0]
+			r.mu.RUnlock()
+			err = r.callOn(c, "Cache.Stats", true, &cache.Metrics{})
+		}
+		r.cb.done(time.Now(), err)
 	}
-	c, err := newRPC(r.dsn, r.timeout)
-	if err != nil {
+}
+
+// redial replaces the broken connections of the pool by new ones.
+func (r *RPC) redial() error {
+	for i := 0; i < r.size; i++ {
+		r.mu.RLock()
+		c := r.pool[i]
+		r.mu.RUnlock()
+		if connected(c) {
+			continue
+		}
+		nc, err := r.dial()
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.pool[i] = nc
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// drop removes the broken connection from the pool, to be replaced by a new one.
+// Only the clients able to reconnect do it.
+func (r *RPC) drop(c Caller) {
+	if r.tick == nil {
 		return
 	}
 	r.mu.Lock()
-	r.c = c
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+	for i := range r.pool {
+		if r.pool[i] == c {
+			r.pool[i] = nil
+			_ = c.Close()
+		}
+	}
 }
 
 // Available implements the Checker interface.
+// The server is unavailable while its circuit is open.
 func (r *RPC) Available() bool {
 	_, err := r.Stats()
 	return err == nil
@@ -79,6 +291,14 @@ func (r *RPC) Available() bool {
 // It acknowledges the boolean if it succeeds.
 // An error occurs if the call fails.
 func (r *RPC) Bulk(batch map[string]interface{}) error {
+	return r.BulkWithTTL(batch, 0)
+}
+
+// BulkWithTTL applies the item modifications on the cache,
+// each of them expiring after the given time to live.
+// A zero duration means no expiration.
+// It implements the deploy.Expirer interface.
+func (r *RPC) BulkWithTTL(batch map[string]interface{}, ttl time.Duration) error {
 	var i int
 	if i = len(batch); i == 0 {
 		return nil
@@ -86,7 +306,7 @@ func (r *RPC) Bulk(batch map[string]interface{}) error {
 	items := make([]*cache.Item, i)
 	for k, v := range batch {
 		i--
-		items[i] = &cache.Item{Key: k, Value: v}
+		items[i] = &cache.Item{Key: k, Value: v, TTL: ttl}
 	}
 	var bulked bool
 	if err := r.call("Cache.Bulk", items, &bulked); err != nil {
@@ -118,10 +338,16 @@ func (r *RPC) Close() error {
 	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if r.c != nil {
-		return r.c.Close()
+	var err error
+	for _, c := range r.pool {
+		if !connected(c) {
+			continue
+		}
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
 	}
-	return nil
+	return err
 }
 
 // Delete removes this key in the cache and acknowledges the boolean if it succeeds.
@@ -152,6 +378,23 @@ func (r *RPC) Lookup(key string) (interface{}, bool) {
 	return value, err == nil
 }
 
+// MultiGet returns in one call the values of the keys found in the cache.
+// It implements the MultiGetter interface.
+func (r *RPC) MultiGet(keys ...string) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if len(keys) == 0 {
+		return m, nil
+	}
+	var items []*cache.Item
+	if err := r.call("Cache.MultiGet", keys, &items); err != nil {
+		return nil, err
+	}
+	for _, i := range items {
+		m[i.Key] = i.Value
+	}
+	return m, nil
+}
+
 // Raw returns the value behind the key or an error if it not exists
 func (r *RPC) Raw(key string) (interface{}, error) {
 	var item cache.Item
@@ -164,8 +407,15 @@ func (r *RPC) Raw(key string) (interface{}, error) {
 // Set saves the item and acknowledges the boolean if it succeeds.
 // An error occurs if the call fails.
 func (r *RPC) Set(key string, value interface{}) error {
+	return r.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL saves the item with a time to live.
+// A zero duration means no expiration.
+// An error occurs if the call fails.
+func (r *RPC) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
 	var added bool
-	item := &cache.Item{Key: key, Value: value}
+	item := &cache.Item{Key: key, Value: value, TTL: ttl}
 	if err := r.call("Cache.Put", item, &added); err != nil {
 		return err
 	}
@@ -175,6 +425,21 @@ func (r *RPC) Set(key string, value interface{}) error {
 	return nil
 }
 
+// TTL returns the remaining time to live of the key, 0 if it never expires.
+// An error occurs if the key not exists or the call fails.
+func (r *RPC) TTL(key string) (time.Duration, error) {
+	var item cache.Item
+	if err := r.call("Cache.Get", key, &item); err != nil {
+		return 0, err
+	}
+	return item.TTL, nil
+}
+
+// State returns the state of the circuit breaker.
+func (r *RPC) State() State {
+	return r.cb.current()
+}
+
 // Stats returns statistics about the current server.
 // An error occurs and returned if the call fails.
 func (r *RPC) Stats() (*cache.Metrics, error) {
@@ -183,12 +448,53 @@ func (r *RPC) Stats() (*cache.Metrics, error) {
 	return req, err
 }
 
+// call calls the service on the next connection of the pool.
+// It fails fast while the circuit is open.
 func (r *RPC) call(service string, args, reply interface{}) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if r.c == (*rpc.Client)(nil) {
-		// An interface value is equal to nil only if both its value and dynamic type are nil.
+	if !r.cb.allow(time.Now()) {
+		return ErrOpenCircuit
+	}
+	r.mu.RLock()
+	c := r.pool[int(atomic.AddUint32(&r.next, 1)%uint32(len(r.pool)))]
+	r.mu.RUnlock()
+	err := r.callOn(c, service, args, reply)
+	if err != ErrTimeout && connected(c) && unreachable(err) {
+		// The connection is broken.
+		r.drop(c)
+	}
+	r.cb.done(time.Now(), err)
+	return err
+}
+
+// callOn calls the service on this connection.
+// The asynchronous call is used if available to not block the other callers,
+// and to give up once the timeout elapsed, if any.
+func (r *RPC) callOn(c Caller, service string, args, reply interface{}) error {
+	if !connected(c) {
 		return ErrConn
 	}
-	return r.c.Call(service, args, reply)
+	g, ok := c.(interface {
+		Go(service string, args, reply interface{}, done chan *rpc.Call) *rpc.Call
+	})
+	if !ok {
+		return c.Call(service, args, reply)
+	}
+	done := g.Go(service, args, reply, make(chan *rpc.Call, 1)).Done
+	if r.timeout <= 0 {
+		return (<-done).Error
+	}
+	t := time.NewTimer(r.timeout)
+	defer t.Stop()
+	select {
+	case call := <-done:
+		return call.Error
+	case <-t.C:
+		return ErrTimeout
+	}
+}
+
+// connected returns true if the caller is usable.
+func connected(c Caller) bool {
+	// An interface value is equal to nil only if both its value and dynamic type are nil.
+	return c != nil && c != (*rpc.Client)(nil)
 }
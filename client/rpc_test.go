@@ -6,6 +6,9 @@ package client_test
 
 import (
 	"errors"
+	"net"
+	netrpc "net/rpc"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -47,9 +50,19 @@ func (c *rpc) Call(service string, args, reply interface{}) error {
 	case "Cache.Get":
 		if args == dataBool {
 			reply.(*cache.Item).Value = true
+			reply.(*cache.Item).TTL = time.Minute
 			return nil
 		}
 		return cache.ErrNotFound
+	case "Cache.MultiGet":
+		items := make([]*cache.Item, 0)
+		for _, k := range args.([]string) {
+			if k == dataBool {
+				items = append(items, &cache.Item{Key: k, Value: true})
+			}
+		}
+		*reply.(*[]*cache.Item) = items
+		return nil
 	case "Cache.Put":
 		item := args.(*cache.Item)
 		switch item.Key {
@@ -78,6 +91,69 @@ func TestOpenRPC(t *testing.T) {
 	}
 }
 
+func TestRPCTimeout(t *testing.T) {
+	// This server accepts the connections but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			if _, err := l.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	r, err := client.OpenRPC(l.Addr().String(), 20*time.Millisecond, client.WithThreshold(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer func() { _ = r.Close() }()
+	if _, err = r.Raw(dataBool); err != client.ErrTimeout {
+		t.Fatalf("error mismatch: got=%q exp=%q", err, client.ErrTimeout)
+	}
+	if s := r.State(); s != client.Open {
+		t.Fatalf("state mismatch: got=%v exp=%v", s, client.Open)
+	}
+}
+
+// countCache is a test's cache service counting the calls of Stats.
+type countCache struct {
+	stats int64
+}
+
+// Stats counts the calls.
+func (c *countCache) Stats(all bool, data *cache.Metrics) error {
+	atomic.AddInt64(&c.stats, 1)
+	return nil
+}
+
+func TestOpenRPCProbe(t *testing.T) {
+	srv := netrpc.NewServer()
+	cc := &countCache{}
+	if err := srv.RegisterName("Cache", cc); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go srv.Accept(l)
+
+	r, err := client.OpenRPC(l.Addr().String(), time.Second, client.WithPoolSize(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	defer func() { _ = r.Close() }()
+	// The server is not probed while its circuit is closed.
+	time.Sleep(1100 * time.Millisecond)
+	if n := atomic.LoadInt64(&cc.stats); n != 0 {
+		t.Fatalf("calls mismatch: got=%d exp=0", n)
+	}
+}
+
 func TestRPCGet(t *testing.T) {
 	var dt = []struct {
 		in     string
@@ -177,3 +253,43 @@ func TestRPCBulk(t *testing.T) {
 		}
 	}
 }
+
+func TestRPCTTL(t *testing.T) {
+	var dt = []struct {
+		in  string
+		ttl time.Duration
+		err error
+	}{
+		{in: dataBool, ttl: time.Minute},
+		{in: dataErr, err: cache.ErrNotFound},
+	}
+	for i, tt := range dt {
+		ttl, err := c.TTL(tt.in)
+		if !reflect.DeepEqual(err, tt.err) {
+			t.Fatalf("%d. error mismatch for %q: got=%q exp=%q", i, tt.in, err, tt.err)
+		}
+		if ttl != tt.ttl {
+			t.Errorf("%d. ttl mismatch for %q: got=%v exp=%v", i, tt.in, ttl, tt.ttl)
+		}
+	}
+}
+
+func TestRPCMultiGet(t *testing.T) {
+	var dt = []struct {
+		in  []string
+		out map[string]interface{}
+	}{
+		{out: map[string]interface{}{}},
+		{in: []string{dataErr}, out: map[string]interface{}{}},
+		{in: []string{dataBool, dataErr}, out: map[string]interface{}{dataBool: true}},
+	}
+	for i, tt := range dt {
+		out, err := c.MultiGet(tt.in...)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %q", i, err)
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("%d. content mismatch: got=%v exp=%v", i, out, tt.out)
+		}
+	}
+}
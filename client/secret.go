@@ -0,0 +1,34 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package client
+
+import "github.com/rvflash/eve/keyring"
+
+// Decrypter is the asserter used to decrypt the secret values.
+type Decrypter struct {
+	k *keyring.Keyring
+}
+
+// NewDecrypter returns a Decrypter using this keyring.
+func NewDecrypter(k *keyring.Keyring) *Decrypter {
+	return &Decrypter{k: k}
+}
+
+// Assert decrypts the value if it is a secret, only available as string.
+// The other values are returned as is.
+func (d *Decrypter) Assert(value interface{}, typ Kind) (interface{}, bool) {
+	s, ok := value.(string)
+	if !ok || !keyring.Sealed(s) {
+		return value, true
+	}
+	if typ != StringVal {
+		return nil, false
+	}
+	plain, err := d.k.Decrypt(s)
+	if err != nil {
+		return nil, false
+	}
+	return plain, true
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package client_test
+
+import (
+	"testing"
+
+	"github.com/rvflash/eve/client"
+	"github.com/rvflash/eve/keyring"
+)
+
+func TestDecrypterAssert(t *testing.T) {
+	k, err := keyring.New("k1", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	other, _ := keyring.New("k2", []byte("fedcba9876543210fedcba9876543210"))
+	secret, _ := k.Encrypt("rv")
+	unknown, _ := other.Encrypt("rv")
+
+	d := client.NewDecrypter(k)
+	var dt = []struct {
+		in  interface{}
+		typ client.Kind
+		out interface{}
+		ok  bool
+	}{
+		{in: 42, typ: client.IntVal, out: 42, ok: true},
+		{in: "rv", typ: client.StringVal, out: "rv", ok: true},
+		{in: secret, typ: client.StringVal, out: "rv", ok: true},
+		{in: secret, typ: client.IntVal},
+		{in: unknown, typ: client.StringVal},
+	}
+	for i, tt := range dt {
+		out, ok := d.Assert(tt.in, tt.typ)
+		if ok != tt.ok {
+			t.Fatalf("%d. ok mismatch: exp=%t got=%t", i, tt.ok, ok)
+		}
+		if out != tt.out {
+			t.Errorf("%d. content mismatch: exp=%v got=%v", i, tt.out, out)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package db
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/rvflash/eve/deploy"
+)
+
+// Approval is a deployment to protected values of environments, waiting the approval of another user than its Author.
+// It holds the differences shown to its author and the form of the request, replayed once approved.
+// From is the identifier of the deployment to roll back, if any.
+// Without decision, it expires at ExpireTs.
+type Approval struct {
+	ID           uint64                     `json:"id"`
+	ProjectID    string                     `json:"project_id"`
+	From         uint64                     `json:"from,omitempty"`
+	Author       string                     `json:"author,omitempty"`
+	EnvsValues   [][]string                 `json:"envs"`
+	Protected    []string                   `json:"protected"`
+	VarList      []string                   `json:"vars,omitempty"`
+	ServerList   []*Server                  `json:"servers"`
+	Diff         map[string]*deploy.Changes `json:"diff,omitempty"`
+	Form         url.Values                 `json:"form,omitempty"`
+	Ts           time.Time                  `json:"ts"`
+	ExpireTs     time.Time                  `json:"expire_ts"`
+	LastUpdateTs time.Time                  `json:"upd_ts"`
+}
+
+// Expired returns true if the approval can not be given anymore.
+func (a *Approval) Expired() bool {
+	return !a.ExpireTs.After(time.Now())
+}
+
+// AutoIncrementing return true in order to have auo-increment primary key.
+func (a *Approval) AutoIncrementing() bool {
+	return true
+}
+
+// Key returns the key of the approval.
+func (a *Approval) Key() []byte {
+	if a.ID == 0 {
+		return nil
+	}
+	return itob(a.ID)
+}
+
+// SetKey returns if error if the change of the key failed.
+func (a *Approval) SetKey(k []byte) error {
+	a.ID = btoi(k)
+	return nil
+}
+
+// Updated changes the last update date of the approval.
+func (a *Approval) Updated() {
+	a.LastUpdateTs = time.Now()
+}
+
+// Valid checks if all required data as well formed.
+func (a *Approval) Valid(insert bool) error {
+	if a.ProjectID == "" || len(a.ServerList) == 0 || len(a.Protected) == 0 {
+		return ErrMissing
+	}
+	if !insert && a.ID == 0 {
+		return ErrOutOfBounds
+	}
+	return nil
+}
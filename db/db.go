@@ -30,6 +30,12 @@ var (
 	envs     = []byte("envs")
 	vars     = []byte("vars")
 	nodes    = []byte("nodes")
+	meta     = []byte("meta")
+	history  = []byte("history")
+	deploys  = []byte("deploys")
+	hooks    = []byte("hooks")
+	queue    = []byte("deliveries")
+	requests = []byte("approvals")
 
 	// unique indexes
 	idxEnvs = []byte("ix_envs")
@@ -38,7 +44,13 @@ var (
 
 // Data manages the collection of buckets.
 type Data struct {
-	db *bolt.DB
+	db       *bolt.DB
+	onChange func(project string, d *Var, c *Change)
+}
+
+// OnChange sets the function called after each change of the values of a variable.
+func (m *Data) OnChange(fn func(project string, d *Var, c *Change)) {
+	m.onChange = fn
 }
 
 // Open opens a new connection to the database.
@@ -50,14 +62,15 @@ func Open(db string) (*Data, error) {
 	if err != nil {
 		return nil, err
 	}
-	// Initializes the database by creating the default buckets.
+	// Initializes the database by creating the default buckets,
+	// then upgrades the data saved with a previous version.
 	err = r.Update(func(tx *bolt.Tx) error {
-		for _, b := range [][]byte{projects, envs, vars, nodes, idxEnvs, idxVars} {
+		for _, b := range [][]byte{projects, envs, vars, nodes, meta, history, deploys, hooks, queue, requests, idxEnvs, idxVars} {
 			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
 				return err
 			}
 		}
-		return nil
+		return migrate(tx)
 	})
 	return &Data{db: r}, err
 }
@@ -150,6 +163,174 @@ func (m *Data) UpsertProject(p *Project) error {
 	})
 }
 
+// Deploys returns the list of deployments of the project, the last one first.
+func (m *Data) Deploys(project string) (res []Keyer, err error) {
+	err = m.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(deploys).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			d := &Deploy{}
+			if err := json.Unmarshal(v, d); err != nil {
+				return err
+			}
+			if d.ProjectID == project {
+				res = append(res, d)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// AddDeploy saves a deployment.
+func (m *Data) AddDeploy(d *Deploy) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return m.put(tx, d, deploys, true)
+	})
+}
+
+// UpdateDeploy updates a deployment, like the status of a canary one.
+func (m *Data) UpdateDeploy(d *Deploy) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return m.put(tx, d, deploys, false)
+	})
+}
+
+// GetDeploy returns an error or the deployment if it exists.
+func (m *Data) GetDeploy(key uint64) (Keyer, error) {
+	return m.get(itob(key), deploys)
+}
+
+// Hooks returns the hooks of the project and the global ones.
+// Without project, it only returns the global ones.
+func (m *Data) Hooks(project string) (res []Keyer, err error) {
+	all, err := m.all(hooks)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range all {
+		if h := d.(*Hook); h.Global() || h.ProjectID == project {
+			res = append(res, h)
+		}
+	}
+	return res, nil
+}
+
+// AddHook saves a hook.
+func (m *Data) AddHook(h *Hook) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return m.put(tx, h, hooks, true)
+	})
+}
+
+// GetHook returns an error or the hook if it exists.
+func (m *Data) GetHook(key uint64) (Keyer, error) {
+	return m.get(itob(key), hooks)
+}
+
+// DeleteHook removes a hook and its deliveries.
+func (m *Data) DeleteHook(h *Hook) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		var keys [][]byte
+		b := tx.Bucket(queue)
+		err := b.ForEach(func(k, v []byte) error {
+			d := &Delivery{}
+			if err := json.Unmarshal(v, d); err != nil {
+				return err
+			}
+			if d.HookID == h.ID {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// The keys are deleted once the iteration done.
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(hooks).Delete(h.Key())
+	})
+}
+
+// Deliveries returns the deliveries waiting their success, the oldest first.
+// Before limits them to the ones to try before this date, abandoned ones excluded.
+func (m *Data) Deliveries(before time.Time) (res []*Delivery, err error) {
+	err = m.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(queue).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			d := &Delivery{}
+			if err := json.Unmarshal(v, d); err != nil {
+				return err
+			}
+			if before.IsZero() || (!d.Abandoned() && !d.NextTs.After(before)) {
+				res = append(res, d)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// AddDelivery queues a delivery.
+func (m *Data) AddDelivery(d *Delivery) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return m.put(tx, d, queue, true)
+	})
+}
+
+// UpdateDelivery updates a delivery after a failed attempt.
+func (m *Data) UpdateDelivery(d *Delivery) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return m.put(tx, d, queue, false)
+	})
+}
+
+// DeleteDelivery removes a delivery, once succeeded.
+func (m *Data) DeleteDelivery(d *Delivery) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queue).Delete(d.Key())
+	})
+}
+
+// Approvals returns the deployments waiting an approval, the oldest first.
+func (m *Data) Approvals() (res []*Approval, err error) {
+	err = m.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(requests).ForEach(func(k, v []byte) error {
+			a := &Approval{}
+			if err := json.Unmarshal(v, a); err != nil {
+				return err
+			}
+			res = append(res, a)
+			return nil
+		})
+	})
+	return
+}
+
+// AddApproval saves a deployment waiting an approval.
+func (m *Data) AddApproval(a *Approval) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return m.put(tx, a, requests, true)
+	})
+}
+
+// UpdateApproval updates a deployment waiting an approval, like its differences computed again.
+func (m *Data) UpdateApproval(a *Approval) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return m.put(tx, a, requests, false)
+	})
+}
+
+// DeleteApproval removes a deployment waiting an approval, once decided or expired.
+func (m *Data) DeleteApproval(a *Approval) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(requests).Delete(a.Key())
+	})
+}
+
 // Envs returns the list of available envs and skip those
 // to ignore as asked.
 func (m *Data) Envs(ignores ...uint64) ([]Keyer, error) {
@@ -255,7 +436,7 @@ func (m *Data) GetVarInProject(key uint64, project string) (Keyer, error) {
 	}
 	// Ensure to manipulate ok values for current environments.
 	p := dp.(*Project)
-	if err = d.CleanValues(p.FirstEnv(), p.SecondEnv()); err != nil {
+	if err = d.CleanValues(p.environments()...); err != nil {
 		return nil, errors.WithMessage(err, "var")
 	}
 	return d, err
@@ -276,7 +457,7 @@ func (m *Data) AddVarInProject(d *Var, project string) error {
 		return errors.WithMessage(err, "var")
 	}
 	// Ensure to manipulate ok values for current environments.
-	if err = d.CleanValues(p.FirstEnv(), p.SecondEnv()); err != nil {
+	if err = d.CleanValues(p.environments()...); err != nil {
 		return errors.WithMessage(err, "var")
 	}
 	return m.db.Update(func(tx *bolt.Tx) error {
@@ -340,12 +521,85 @@ func (m *Data) UpdateVarInProject(d *Var, project string) error {
 		return errors.WithMessage(err, "var")
 	}
 	// Ensure to manipulate ok values for current environments.
-	if err = d.CleanValues(p.FirstEnv(), p.SecondEnv()); err != nil {
+	if err = d.CleanValues(p.environments()...); err != nil {
 		return errors.WithMessage(err, "var")
 	}
-	return m.db.Update(func(tx *bolt.Tx) error {
-		return m.put(tx, d, vars, false)
+	var c *Change
+	err = m.db.Update(func(tx *bolt.Tx) error {
+		// Keeps the stored version to know the changed cells.
+		var old Var
+		if err := json.Unmarshal(tx.Bucket(vars).Get(d.Key()), &old); err != nil {
+			return errors.WithMessage(err, "var")
+		}
+		if err := old.CleanValues(p.environments()...); err != nil {
+			return errors.WithMessage(err, "var")
+		}
+		if err := m.put(tx, d, vars, false); err != nil {
+			return err
+		}
+		cells := d.Diff(&old)
+		if len(cells) == 0 {
+			return nil
+		}
+		// Appends the change to the history of the variable.
+		b, err := tx.Bucket(history).CreateBucketIfNotExists(d.Key())
+		if err != nil {
+			return errors.WithMessage(err, "history")
+		}
+		c = &Change{VarID: d.ID, Author: d.Author, Cells: cells, Ts: d.LastUpdateTs}
+		if c.ID, err = b.NextSequence(); err != nil {
+			return errors.WithMessage(err, "history")
+		}
+		buf, err := json.Marshal(c)
+		if err != nil {
+			return errors.WithMessage(err, "history")
+		}
+		return b.Put(itob(c.ID), buf)
 	})
+	if err == nil && c != nil && m.onChange != nil {
+		// Once committed, notifies the change.
+		m.onChange(project, d, c)
+	}
+	return err
+}
+
+// History returns the changes of the variable, the last one first.
+func (m *Data) History(key uint64) (res []*Change, err error) {
+	err = m.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(history).Bucket(itob(key))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			d := &Change{}
+			if err := json.Unmarshal(v, d); err != nil {
+				return err
+			}
+			res = append(res, d)
+		}
+		return nil
+	})
+	return
+}
+
+// GetChange returns an error or the change of the variable if it exists.
+func (m *Data) GetChange(key, change uint64) (*Change, error) {
+	d := &Change{}
+	err := m.db.View(func(tx *bolt.Tx) error {
+		var v []byte
+		if b := tx.Bucket(history).Bucket(itob(key)); b != nil {
+			v = b.Get(itob(change))
+		}
+		if len(v) == 0 {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
 // AutoIncrementer must be implement to manage the kind of primary key.
@@ -467,6 +721,12 @@ func newFor(table []byte) (Keyer, error) {
 	if bytes.Equal(table, nodes) {
 		return &Node{}, nil
 	}
+	if bytes.Equal(table, deploys) {
+		return &Deploy{}, nil
+	}
+	if bytes.Equal(table, hooks) {
+		return &Hook{}, nil
+	}
 	return nil, errors.WithMessage(ErrUnknown, "new")
 }
 
@@ -4,7 +4,9 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/pkg/errors"
 	"github.com/rvflash/eve/db"
 )
@@ -168,6 +170,53 @@ func TestOpen(t *testing.T) {
 	}
 }
 
+func TestOpenMigrate(t *testing.T) {
+	// Saves the projects as before the version 1 of the schema.
+	_ = os.Remove(dbTest)
+	r, err := bolt.Open(dbTest, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("projects"))
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte("a"), []byte(`{"id":"a","name":"a","envs":[1,2]}`)); err != nil {
+			return err
+		}
+		return b.Put([]byte("b"), []byte(`{"id":"b","name":"b","envs":[4,3]}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Opens it twice to check that the migration is only applied once.
+	for i := 0; i < 2; i++ {
+		d, err := db.Open(dbTest)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		l, err := d.Projects()
+		if err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		exp := map[string][]uint64{"a": {2, 1}, "b": {4, 3}}
+		for _, k := range l {
+			p := k.(*db.Project)
+			if !reflect.DeepEqual(p.EnvList, exp[p.ID]) {
+				t.Errorf("%d. %s envs mismatch: exp=%v got=%v", i, p.ID, exp[p.ID], p.EnvList)
+			}
+		}
+		if err = d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = os.Remove(dbTest)
+}
+
 func TestDataNodes(t *testing.T) {
 	// Opens the database.
 	dbt, err := openDb()
@@ -188,6 +237,55 @@ func TestDataNodes(t *testing.T) {
 	}
 }
 
+func TestEnvValid(t *testing.T) {
+	var dt = []struct {
+		env *db.Env
+		err bool
+	}{
+		{env: db.NewEnv("stage", []string{"dev", "prod"})},
+		{env: &db.Env{Name: "stage", Values: []string{"dev", "prod"}, Protected: []string{"prod"}}},
+		{env: &db.Env{Name: "stage", Values: []string{"dev", "prod"}, Protected: []string{"qa"}}, err: true},
+	}
+	for i, tt := range dt {
+		if err := tt.env.Valid(true); (err != nil) != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		}
+	}
+}
+
+func TestNodeValid(t *testing.T) {
+	var dt = []struct {
+		node *db.Node
+		err  bool
+		file string
+	}{
+		{node: db.NewNode(":9090")},
+		{node: &db.Node{Addr: "/etc/eve", Type: "ftp"}, err: true},
+		{node: &db.Node{Addr: "etc/eve", Type: db.DotenvNode}, err: true},
+		{node: &db.Node{Addr: "/etc/eve/", Type: db.DotenvNode}, file: "/etc/eve/alpha.env"},
+		{node: &db.Node{Addr: "/etc/eve", Type: db.JSONNode}, file: "/etc/eve/alpha.json"},
+		{node: &db.Node{Addr: "/k8s", Type: db.ConfigMapNode}, file: "/k8s/alpha-configmap.yaml"},
+		{node: &db.Node{Addr: "/k8s", Type: db.SecretNode}, file: "/k8s/alpha-secret.yaml"},
+		{node: &db.Node{Addr: "/etc/eve", Type: db.SystemdNode}, file: "/etc/eve/alpha.conf"},
+	}
+	for i, tt := range dt {
+		if err := tt.node.Valid(true); (err != nil) != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		}
+		if tt.err {
+			continue
+		}
+		f := tt.node.File("alpha")
+		if tt.file == "" {
+			if f != nil {
+				t.Errorf("%d. file mismatch: exp=nil got=%v", i, f.Path())
+			}
+		} else if f == nil || f.Path() != tt.file {
+			t.Errorf("%d. file mismatch: exp=%v got=%v", i, tt.file, f)
+		}
+	}
+}
+
 func TestDataProjects(t *testing.T) {
 	// Opens the database.
 	dbt, err := openDb()
@@ -542,6 +640,268 @@ func TestDataVarLifeCycle(t *testing.T) {
 	}
 }
 
+func TestDataVarHistory(t *testing.T) {
+	// Opens the database.
+	dbt, err := openDb()
+	if err != nil {
+		t.Fatalf("open %s: %s", dbTest, err)
+	}
+	defer func() { _ = dbt.stop() }()
+
+	if err := dbt.createProjectWithVar(); err != nil {
+		t.Fatalf("unable to create the scoped test's project: %v", err)
+	}
+	var notified []uint64
+	dbt.r.OnChange(func(project string, v *db.Var, c *db.Change) {
+		if project == "test" && v.ID == c.VarID {
+			notified = append(notified, c.ID)
+		}
+	})
+	// Changes twice the value, then one time only the description.
+	var dt = []struct {
+		author, value, desc string
+	}{
+		{author: "rv", value: "true"},
+		{author: "hg", value: "false"},
+		{author: "hg", value: "false", desc: "no change"},
+	}
+	for i, tt := range dt {
+		d, err := dbt.r.GetVarInProject(dbt.v, "test")
+		if err != nil {
+			t.Fatalf("%d. unable to get var: got=%q", i, err)
+		}
+		v := d.(*db.Var)
+		v.Author, v.Description = tt.author, tt.desc
+		if err = v.SetValues(map[string]string{"_.": tt.value}); err != nil {
+			t.Fatalf("%d. unable to change var's values: got=%q", i, err)
+		}
+		if err = dbt.r.UpdateVarInProject(v, "test"); err != nil {
+			t.Fatalf("%d. unable to update var: got=%q", i, err)
+		}
+	}
+	h, err := dbt.r.History(dbt.v)
+	if err != nil {
+		t.Fatalf("unable to get history: got=%q", err)
+	}
+	if len(h) != 2 {
+		t.Fatalf("history mismatch: exp=2 got=%d", len(h))
+	}
+	if exp := []uint64{1, 2}; !reflect.DeepEqual(notified, exp) {
+		t.Errorf("notified changes mismatch: exp=%v got=%v", exp, notified)
+	}
+	exp := []db.Cell{{ID: "_.", Before: true, After: false}}
+	if h[0].ID != 2 || h[0].Author != "hg" || !reflect.DeepEqual(exp, h[0].Cells) {
+		t.Errorf("change mismatch: exp=%v got=%v", exp, h[0])
+	}
+	// Reverts the last change.
+	c, err := dbt.r.GetChange(dbt.v, 2)
+	if err != nil {
+		t.Fatalf("unable to get change: got=%q", err)
+	}
+	d, _ := dbt.r.GetVarInProject(dbt.v, "test")
+	v := d.(*db.Var)
+	if err = v.Revert(c); err != nil {
+		t.Fatalf("unable to revert: got=%q", err)
+	}
+	if err = dbt.r.UpdateVarInProject(v, "test"); err != nil {
+		t.Fatalf("unable to update var: got=%q", err)
+	}
+	if h, _ = dbt.r.History(dbt.v); len(h) != 3 || h[0].Cells[0].After != true {
+		t.Errorf("history mismatch: got=%v", h)
+	}
+	if _, err = dbt.r.GetChange(dbt.v, 4); err != db.ErrNotFound {
+		t.Errorf("error mismatch: exp=%q got=%q", db.ErrNotFound, err)
+	}
+}
+
+func TestDataDeploys(t *testing.T) {
+	// Opens the database.
+	dbt, err := openDb()
+	if err != nil {
+		t.Fatalf("open %s: %s", dbTest, err)
+	}
+	defer func() { _ = dbt.stop() }()
+
+	// Records a failed then a successful deployment.
+	var dt = []struct {
+		pid   string
+		items map[string]interface{}
+		errs  []string
+		err   error
+	}{
+		{pid: "test", err: db.ErrMissing},
+		{pid: "test", items: map[string]interface{}{"TEST_BOOL": true}, errs: []string{"", "failure"}},
+		{pid: "other", items: map[string]interface{}{"OTHER_BOOL": true}, errs: []string{"", ""}},
+		{pid: "test", items: map[string]interface{}{"TEST_BOOL": false}, errs: []string{"", ""}},
+	}
+	for i, tt := range dt {
+		d := db.NewDeploy(tt.pid, ":9090", ":9091")
+		d.ItemList = tt.items
+		for k, e := range tt.errs {
+			d.ServerList[k].Succeeded, d.ServerList[k].Err = e == "", e
+		}
+		if err := dbt.r.AddDeploy(d); err != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		}
+	}
+	all, err := dbt.r.Deploys("test")
+	if err != nil {
+		t.Fatalf("unable to list deploys: got=%q", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("deploys mismatch: exp=2 got=%d", len(all))
+	}
+	if d := all[0].(*db.Deploy); d.ID != 3 || !d.Succeeded() {
+		t.Errorf("deploy mismatch: got=%v", d)
+	}
+	d, err := dbt.r.GetDeploy(1)
+	if err != nil {
+		t.Fatalf("unable to get deploy: got=%q", err)
+	}
+	if dp := d.(*db.Deploy); dp.Succeeded() || dp.ServerList[1].Err != "failure" {
+		t.Errorf("deploy mismatch: got=%v", dp)
+	}
+	if _, err = dbt.r.GetDeploy(4); err != db.ErrNotFound {
+		t.Errorf("error mismatch: exp=%q got=%q", db.ErrNotFound, err)
+	}
+	// Promotes a canary deployment.
+	dp := db.NewDeploy("test", ":9090", ":9091")
+	dp.ItemList = map[string]interface{}{"TEST_BOOL": true}
+	dp.ServerList[0].Succeeded, dp.Stage = true, db.StageCanary
+	if err = dbt.r.UpdateDeploy(dp); err != db.ErrOutOfBounds {
+		t.Errorf("error mismatch: exp=%q got=%q", db.ErrOutOfBounds, err)
+	}
+	if err = dbt.r.AddDeploy(dp); err != nil {
+		t.Fatalf("unable to add deploy: got=%q", err)
+	}
+	if !dp.Staged() || dp.Succeeded() || dp.ServerList[0].Pending() || !dp.ServerList[1].Pending() {
+		t.Errorf("canary deploy mismatch: got=%v", dp)
+	}
+	dp.ServerList[1].Succeeded, dp.Stage = true, ""
+	if err = dbt.r.UpdateDeploy(dp); err != nil {
+		t.Fatalf("unable to update deploy: got=%q", err)
+	}
+	if d, err = dbt.r.GetDeploy(dp.ID); err != nil || d.(*db.Deploy).Staged() || !d.(*db.Deploy).Succeeded() {
+		t.Errorf("promoted deploy mismatch: got=%v (%v)", d, err)
+	}
+}
+
+func TestDataApprovals(t *testing.T) {
+	// Opens the database.
+	dbt, err := openDb()
+	if err != nil {
+		t.Fatalf("open %s: %s", dbTest, err)
+	}
+	defer func() { _ = dbt.stop() }()
+
+	if err = dbt.r.AddApproval(&db.Approval{ProjectID: "test"}); err != db.ErrMissing {
+		t.Errorf("error mismatch: exp=%v got=%v", db.ErrMissing, err)
+	}
+	for _, a := range []*db.Approval{
+		{ProjectID: "test", Protected: []string{"prod"}, ServerList: []*db.Server{{TCPAddr: ":9090"}}, ExpireTs: time.Now().Add(time.Hour)},
+		{ProjectID: "test", From: 1, Protected: []string{"prod"}, ServerList: []*db.Server{{TCPAddr: ":9090"}}},
+	} {
+		if err = dbt.r.AddApproval(a); err != nil {
+			t.Fatalf("unable to add approval: got=%q", err)
+		}
+	}
+	l, err := dbt.r.Approvals()
+	if err != nil {
+		t.Fatalf("unable to list approvals: got=%q", err)
+	}
+	if len(l) != 2 || l[0].ID != 1 || l[0].Expired() || l[1].From != 1 || !l[1].Expired() {
+		t.Fatalf("approvals mismatch: got=%v", l)
+	}
+	l[0].Author = "rv"
+	if err = dbt.r.UpdateApproval(l[0]); err != nil {
+		t.Fatalf("unable to update approval: got=%q", err)
+	}
+	if err = dbt.r.DeleteApproval(l[1]); err != nil {
+		t.Fatalf("unable to delete approval: got=%q", err)
+	}
+	if l, _ = dbt.r.Approvals(); len(l) != 1 || l[0].Author != "rv" {
+		t.Errorf("approvals mismatch: got=%v", l)
+	}
+}
+
+func TestDataHooks(t *testing.T) {
+	// Opens the database.
+	dbt, err := openDb()
+	if err != nil {
+		t.Fatalf("open %s: %s", dbTest, err)
+	}
+	defer func() { _ = dbt.stop() }()
+
+	var dt = []struct {
+		hook *db.Hook
+		err  error
+	}{
+		{hook: db.NewHook("", "ftp://example.com", ""), err: db.ErrInvalid},
+		{hook: db.NewHook("", "https://example.com/hook", "s3cr3t")},
+		{hook: db.NewHook("test", "http://example.com/test", "")},
+		{hook: db.NewHook("other", "http://example.com/other", "")},
+	}
+	for i, tt := range dt {
+		if err := dbt.r.AddHook(tt.hook); err != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		}
+	}
+	all, err := dbt.r.Hooks("test")
+	if err != nil {
+		t.Fatalf("unable to list hooks: got=%q", err)
+	}
+	if len(all) != 2 || !all[0].(*db.Hook).Global() || all[1].(*db.Hook).ProjectID != "test" {
+		t.Errorf("hooks mismatch: got=%v", all)
+	}
+	if all, _ = dbt.r.Hooks(""); len(all) != 1 {
+		t.Errorf("global hooks mismatch: exp=1 got=%d", len(all))
+	}
+	// Queues one delivery now and one later.
+	now := time.Now()
+	for _, d := range []*db.Delivery{
+		db.NewDelivery(1, []byte(`{}`)),
+		{HookID: 2, Payload: []byte(`{}`), NextTs: now.Add(time.Hour)},
+	} {
+		if err = dbt.r.AddDelivery(d); err != nil {
+			t.Fatalf("unable to add delivery: got=%q", err)
+		}
+	}
+	if err = dbt.r.AddDelivery(db.NewDelivery(1, nil)); err != db.ErrMissing {
+		t.Errorf("error mismatch: exp=%v got=%v", db.ErrMissing, err)
+	}
+	q, err := dbt.r.Deliveries(time.Now())
+	if err != nil {
+		t.Fatalf("unable to list deliveries: got=%q", err)
+	}
+	if len(q) != 1 || q[0].HookID != 1 {
+		t.Fatalf("deliveries mismatch: got=%v", q)
+	}
+	// Abandons the first one.
+	q[0].Attempts, q[0].NextTs = 3, time.Time{}
+	if err = dbt.r.UpdateDelivery(q[0]); err != nil {
+		t.Fatalf("unable to update delivery: got=%q", err)
+	}
+	if q, _ = dbt.r.Deliveries(now.Add(2 * time.Hour)); len(q) != 1 || q[0].HookID != 2 {
+		t.Errorf("deliveries mismatch: got=%v", q)
+	}
+	// Deletes the second hook with its delivery, then the first delivery.
+	if err = dbt.r.DeleteHook(dt[2].hook); err != nil {
+		t.Fatalf("unable to delete hook: got=%q", err)
+	}
+	if q, _ = dbt.r.Deliveries(time.Time{}); len(q) != 1 || !q[0].Abandoned() {
+		t.Fatalf("deliveries mismatch: got=%v", q)
+	}
+	if err = dbt.r.DeleteDelivery(q[0]); err != nil {
+		t.Fatalf("unable to delete delivery: got=%q", err)
+	}
+	if q, _ = dbt.r.Deliveries(time.Time{}); len(q) != 0 {
+		t.Errorf("deliveries mismatch: got=%v", q)
+	}
+	if _, err = dbt.r.GetHook(2); err != db.ErrNotFound {
+		t.Errorf("error mismatch: exp=%v got=%v", db.ErrNotFound, err)
+	}
+}
+
 func TestDataEnv(t *testing.T) {
 	// Opens the database.
 	dbt, err := openDb()
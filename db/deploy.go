@@ -6,25 +6,60 @@ package db
 
 import (
 	"net"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rvflash/eve/deploy"
+)
+
+// Stages of a canary deployment, pushed on some servers first.
+// Once promoted, a deployment has no stage.
+const (
+	StageCanary  = "canary"
+	StageAborted = "aborted"
 )
 
 // Server represents one node with the status of the deployment on it.
+// Latency is the duration of the last attempt of the push on it.
+// Before contains the values of a canary node before the push, restored if the deployment is aborted.
 type Server struct {
-	TCPAddr   string `json:"naddr"`
-	Succeeded bool   `json:"ok,omitempty"`
+	TCPAddr   string                 `json:"naddr"`
+	Type      string                 `json:"type,omitempty"`
+	Succeeded bool                   `json:"ok,omitempty"`
+	Err       string                 `json:"err,omitempty"`
+	Latency   time.Duration          `json:"latency,omitempty"`
+	Attempts  int                    `json:"attempts,omitempty"`
+	Before    map[string]interface{} `json:"before,omitempty"`
+}
+
+// Pending returns true if the deployment has not been pushed yet on the server.
+func (s *Server) Pending() bool {
+	return !s.Succeeded && s.Err == ""
 }
 
 // Deploy represents one deployment.
+// EnvsValues are the values of the environments deployed, in their order.
+// ItemList contains the data pushed and LogList, for each of them,
+// its value before and after the push.
+// A canary deployment waits its promotion until BakeTs, if not zero.
+// A deployment to a protected value of an environment is approved by another user than its Author.
 type Deploy struct {
-	ID           uint64                 `json:"id"`
-	ProjectID    string                 `json:"project_id"`
-	ServerList   []*Server              `json:"servers"`
-	ItemList     map[string]interface{} `json:"items"`
-	LastUpdateTs time.Time              `json:"upd_ts"`
+	ID           uint64                    `json:"id"`
+	ProjectID    string                    `json:"project_id"`
+	EnvsValues   [][]string                `json:"envs"`
+	ServerList   []*Server                 `json:"servers"`
+	ItemList     map[string]interface{}    `json:"items"`
+	LogList      map[string][2]interface{} `json:"log,omitempty"`
+	TTL          time.Duration             `json:"ttl,omitempty"`
+	Duration     time.Duration             `json:"duration"`
+	Author       string                    `json:"author,omitempty"`
+	Approver     string                    `json:"approver,omitempty"`
+	Stage        string                    `json:"stage,omitempty"`
+	BakeTs       time.Time                 `json:"bake_ts,omitempty"`
+	LastUpdateTs time.Time                 `json:"upd_ts"`
 }
 
 // NewDeploy returns a new instance of Deploy.
@@ -36,6 +71,21 @@ func NewDeploy(projectID string, servers ...string) *Deploy {
 	return &Deploy{ProjectID: projectID, ServerList: naddr}
 }
 
+// Succeeded returns true if the deployment succeeded on all its servers.
+func (d *Deploy) Succeeded() bool {
+	for _, srv := range d.ServerList {
+		if !srv.Succeeded {
+			return false
+		}
+	}
+	return len(d.ServerList) > 0
+}
+
+// Staged returns true if the deployment waits to be promoted on all its servers.
+func (d *Deploy) Staged() bool {
+	return d.Stage == StageCanary
+}
+
 // AutoIncrementing return true in order to have auo-increment primary key.
 func (d *Deploy) AutoIncrementing() bool {
 	return true
@@ -72,6 +122,10 @@ func (d *Deploy) Valid(insert bool) error {
 		return ErrNotFound
 	}
 	for _, naddr := range d.ServerList {
+		if naddr.Type != RPCNode {
+			// The files have no TCP address.
+			continue
+		}
 		// Parses addr as a TCP address of the form "host:port".
 		if _, err := net.ResolveTCPAddr("tcp", naddr.TCPAddr); err != nil {
 			// Fails to resolve it.
@@ -83,3 +137,183 @@ func (d *Deploy) Valid(insert bool) error {
 	}
 	return nil
 }
+
+// rollback is the source to deploy again the items of a deployment.
+type rollback struct {
+	pid   string
+	envs  [][]string
+	items map[string]interface{}
+}
+
+// Key implements the deploy.Source interface.
+func (r *rollback) Key() []byte {
+	return []byte(r.pid)
+}
+
+// EnvsValues implements the deploy.Source interface.
+func (r *rollback) EnvsValues() [][]string {
+	return r.envs
+}
+
+// ToDeploy implements the deploy.Source interface.
+func (r *rollback) ToDeploy(_ [][]string) map[string]interface{} {
+	// The release can limit the data to push, so it works on a copy.
+	m := make(map[string]interface{}, len(r.items))
+	for k, v := range r.items {
+		m[k] = v
+	}
+	return m
+}
+
+// Rollback returns the source to deploy again the items of this deployment of the project,
+// to the same environments values. The items keep the kind of their variable.
+func (p *Project) Rollback(d *Deploy) (deploy.Source, error) {
+	if d.ProjectID != p.ID {
+		return nil, ErrInvalid
+	}
+	if len(d.ItemList) == 0 {
+		return nil, ErrMissing
+	}
+	items, err := p.typed(d.EnvsValues, d.ItemList)
+	if err != nil {
+		return nil, err
+	}
+	return &rollback{pid: p.ID, envs: d.EnvsValues, items: items}, nil
+}
+
+// Canary returns the source of this canary deployment of the project, to promote or abort it,
+// with by position the values of its canary servers before the push.
+// Like Rollback, the values keep the kind of their variable.
+func (p *Project) Canary(d *Deploy) (deploy.Source, map[int]map[string]interface{}, error) {
+	if !d.Staged() {
+		return nil, nil, ErrInvalid
+	}
+	src, err := p.Rollback(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	before := make(map[int]map[string]interface{})
+	for i, srv := range d.ServerList {
+		if srv.Before == nil {
+			continue
+		}
+		if before[i], err = p.typed(d.EnvsValues, srv.Before); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(before) == 0 {
+		return nil, nil, ErrMissing
+	}
+	return src, before, nil
+}
+
+// typed returns a copy of the data, as read from the database,
+// with the values asserted to the kind of their variable.
+func (p *Project) typed(envsValues [][]string, data map[string]interface{}) (map[string]interface{}, error) {
+	cells := p.cells(envsValues)
+	res := make(map[string]interface{}, len(data))
+	for k, value := range data {
+		if c, ok := cells[k]; ok && value != nil {
+			if value, ok = c.v.Kind.Assert(value); !ok {
+				return nil, errors.WithMessage(ErrInvalid, k)
+			}
+		}
+		res[k] = value
+	}
+	return res, nil
+}
+
+// Restore overrides the values of the variables of the project with the ones of this deployment.
+// The raw value of each cell at the time of the deployment is restored with the changes made since,
+// given newest first by variable identifier, to keep its references and its inheritance.
+// Only the cells whose value no longer resolves to the deployed item are restored, and with the item
+// itself if their defaults or their references changed since. Names limits the restoration to these variables.
+// It returns the changed variables, sorted by name, or an error if one of them breaks its rules or its references.
+// On error, the variables are unchanged.
+func (p *Project) Restore(d *Deploy, history map[uint64][]*Change, names ...string) (res []*Var, err error) {
+	only := make(map[string]bool, len(names))
+	for _, name := range names {
+		only[strings.ToUpper(name)] = true
+	}
+	// Lists the cells whose value differs from the deployed one.
+	items := make(map[varCell]interface{})
+	for k, c := range p.cells(d.EnvsValues) {
+		item, ok := d.ItemList[k]
+		if !ok || item == nil || (len(only) > 0 && !only[strings.ToUpper(c.v.Name)]) {
+			continue
+		}
+		if item, ok = c.v.Kind.Assert(item); !ok {
+			continue
+		}
+		if cur, err := p.Resolve(c.v, c.id); err != nil || !reflect.DeepEqual(cur, item) {
+			items[c] = item
+		}
+	}
+	changed := make(map[*Var]map[string]string)
+	saved := make(map[*Var]EnvsValue)
+	defer func() {
+		if err != nil {
+			for v, values := range saved {
+				v.Values = values
+			}
+		}
+	}()
+	restore := func(c varCell, value interface{}) {
+		if changed[c.v] == nil {
+			changed[c.v] = make(map[string]string)
+			saved[c.v] = c.v.Values
+			c.v.Values = make(EnvsValue, len(saved[c.v]))
+			for k, d := range saved[c.v] {
+				c.v.Values[k] = d
+			}
+		}
+		set(c.v.Values, c.id, value)
+		changed[c.v][c.id] = ""
+	}
+	// Restores first the raw values, as they refer to each other.
+	for c := range items {
+		raw, ok := rawAt(history[c.v.ID], c.id, d.LastUpdateTs)
+		if ok && raw != nil {
+			// As read from the database.
+			raw, ok = c.v.Kind.Assert(raw)
+		}
+		if ok {
+			restore(c, raw)
+		}
+	}
+	for c, item := range items {
+		if cur, err := p.Resolve(c.v, c.id); err != nil || !reflect.DeepEqual(cur, item) {
+			restore(c, item)
+		}
+	}
+	// Checks the restored values as the new ones.
+	for v, ids := range changed {
+		if err = v.Rules.Check(v.Kind, v.resolve(ids)); err != nil {
+			return nil, errors.WithMessage(err, v.Name)
+		}
+		if err = p.Refer(v); err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// rawAt returns the raw value of the cell at this time, before the changes made since,
+// given newest first. The boolean is false if the cell has not changed since.
+func rawAt(changes []*Change, id string, ts time.Time) (d interface{}, ok bool) {
+	for _, c := range changes {
+		if !c.Ts.After(ts) {
+			break
+		}
+		for _, cell := range c.Cells {
+			if cell.ID == id {
+				d, ok = cell.Before, true
+			}
+		}
+	}
+	return
+}
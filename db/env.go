@@ -13,10 +13,12 @@ import (
 var DefaultEnv = &Env{Values: []string{""}}
 
 // Env represents a env of execution.
+// The deployments to its protected values need the approval of another user.
 type Env struct {
 	ID           uint64    `json:"id"`
 	Name         string    `json:"name"`
 	Values       []string  `json:"vals"`
+	Protected    []string  `json:"protected,omitempty"`
 	LastUpdateTs time.Time `json:"upd_ts"`
 }
 
@@ -38,6 +40,16 @@ func (s *Env) Default() bool {
 	return false
 }
 
+// Protects returns true if the deployments to this value need an approval.
+func (s *Env) Protects(value string) bool {
+	for _, v := range s.Protected {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // Key returns the key of the env.
 func (s *Env) Key() []byte {
 	if s.ID == 0 {
@@ -67,6 +79,17 @@ func (s *Env) Valid(insert bool) error {
 	if len(s.Values) == 0 {
 		return ErrMissing
 	}
+	// Only its values can be protected.
+	s.Protected = uniqueness(s.Protected)
+	known := make(map[string]bool, len(s.Values))
+	for _, v := range s.Values {
+		known[v] = true
+	}
+	for _, v := range s.Protected {
+		if !known[v] {
+			return ErrInvalid
+		}
+	}
 	if !insert && s.ID == 0 {
 		return ErrOutOfBounds
 	}
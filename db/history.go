@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package db
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCell is the identifier of the cell of the default value of a variable.
+// The default of one environment value is identified by its value with this prefix.
+const DefaultCell = "*"
+
+// Cell is the value of one cell of a variable before and after a change.
+// Its identifier is the one of a combination of environments values,
+// or one starting with DefaultCell for the defaults.
+// A nil value means not overridden.
+type Cell struct {
+	ID     string      `json:"id"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Default returns true if the cell is one of the defaults of the variable.
+func (c Cell) Default() bool {
+	return strings.HasPrefix(c.ID, DefaultCell)
+}
+
+// String returns the name of the cell, like `prod / fr` or `default of prod`.
+func (c Cell) String() string {
+	if ev := strings.TrimPrefix(c.ID, DefaultCell); c.Default() {
+		if ev == "" {
+			return "default"
+		}
+		return "default of " + ev
+	}
+	var names []string
+	for _, ev := range *NewVarID(c.ID) {
+		if ev != "" {
+			names = append(names, ev)
+		}
+	}
+	if len(names) == 0 {
+		return "all"
+	}
+	return strings.Join(names, " / ")
+}
+
+// Change is one change of the values of a variable, made by its author.
+type Change struct {
+	ID     uint64    `json:"id"`
+	VarID  uint64    `json:"vid"`
+	Author string    `json:"author,omitempty"`
+	Cells  []Cell    `json:"cells"`
+	Ts     time.Time `json:"ts"`
+}
+
+// Diff returns the cells of the variable changed since the old version, sorted by identifier.
+func (v *Var) Diff(old *Var) []Cell {
+	var cells []Cell
+	add := func(id string, before, after interface{}) {
+		if !reflect.DeepEqual(before, after) {
+			cells = append(cells, Cell{ID: id, Before: before, After: after})
+		}
+	}
+	add(DefaultCell, old.Default, v.Default)
+	for _, id := range keys(old.Defaults, v.Defaults) {
+		add(DefaultCell+id, old.Defaults[id], v.Defaults[id])
+	}
+	for _, id := range keys(old.Values, v.Values) {
+		add(id, old.Values[id], v.Values[id])
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i].ID < cells[j].ID
+	})
+	return cells
+}
+
+// Revert restores the values of the cells before the change.
+// It returns an error if one of the resulting values breaks the rules of the variable.
+func (v *Var) Revert(c *Change) error {
+	if c == nil || c.VarID != v.ID {
+		return ErrNotFound
+	}
+	w := *v
+	w.Values = make(EnvsValue, len(v.Values))
+	for k, d := range v.Values {
+		w.Values[k] = d
+	}
+	w.Defaults = make(EnvsValue, len(v.Defaults))
+	for k, d := range v.Defaults {
+		w.Defaults[k] = d
+	}
+	ids := map[string]string{"default": ""}
+	for _, cell := range c.Cells {
+		d := cell.Before
+		if d != nil {
+			var ok bool
+			if d, ok = v.Kind.Assert(d); !ok {
+				return errors.WithMessage(ErrInvalid, cell.String())
+			}
+		}
+		ev := strings.TrimPrefix(cell.ID, DefaultCell)
+		switch {
+		case cell.ID == DefaultCell:
+			w.Default = d
+		case cell.Default():
+			set(w.Defaults, ev, d)
+			ids[VarID{ev}.String()] = ""
+		default:
+			set(w.Values, cell.ID, d)
+			ids[cell.ID] = ""
+		}
+	}
+	// Checks the reverted values as the new ones.
+	if err := v.Rules.Check(v.Kind, w.resolve(ids)); err != nil {
+		return err
+	}
+	if len(w.Defaults) == 0 {
+		w.Defaults = nil
+	}
+	v.Values, v.Default, v.Defaults = w.Values, w.Default, w.Defaults
+
+	return nil
+}
+
+// keys returns the sorted list of the keys of both maps.
+func keys(a, b EnvsValue) []string {
+	var all []string
+	for k := range a {
+		all = append(all, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			all = append(all, k)
+		}
+	}
+	sort.Strings(all)
+	return all
+}
+
+// set sets the value or deletes it if nil.
+func set(m EnvsValue, k string, d interface{}) {
+	if d == nil {
+		delete(m, k)
+	} else {
+		m[k] = d
+	}
+}
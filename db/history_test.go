@@ -0,0 +1,93 @@
+package db_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/rvflash/eve/db"
+)
+
+func TestCellString(t *testing.T) {
+	var dt = []struct {
+		in  db.Cell
+		out string
+		def bool
+	}{
+		{in: db.Cell{ID: "_."}, out: "all"},
+		{in: db.Cell{ID: "_prod.fr"}, out: "prod / fr"},
+		{in: db.Cell{ID: "_.fr"}, out: "fr"},
+		{in: db.Cell{ID: db.DefaultCell}, out: "default", def: true},
+		{in: db.Cell{ID: db.DefaultCell + "prod"}, out: "default of prod", def: true},
+	}
+	for i, tt := range dt {
+		if out := tt.in.String(); out != tt.out {
+			t.Errorf("%d. content mismatch: exp=%q got=%q", i, tt.out, out)
+		}
+		if ok := tt.in.Default(); ok != tt.def {
+			t.Errorf("%d. default mismatch: exp=%t got=%t", i, tt.def, ok)
+		}
+	}
+}
+
+func TestVarDiff(t *testing.T) {
+	old := db.NewVar("port", db.Int.Int())
+	old.Default = 80
+	old.Values = db.EnvsValue{"_dev.": 8080, "_qa.": 8081}
+	v := db.NewVar("port", db.Int.Int())
+	v.Defaults = db.EnvsValue{"prod": 443}
+	v.Values = db.EnvsValue{"_dev.": 8080, "_qa.": 8082}
+
+	exp := []db.Cell{
+		{ID: db.DefaultCell, Before: 80},
+		{ID: db.DefaultCell + "prod", After: 443},
+		{ID: "_qa.", Before: 8081, After: 8082},
+	}
+	if cells := v.Diff(old); !reflect.DeepEqual(exp, cells) {
+		t.Errorf("content mismatch: exp=%v got=%v", exp, cells)
+	}
+	if cells := v.Diff(v); cells != nil {
+		t.Errorf("content mismatch: exp=nil got=%v", cells)
+	}
+}
+
+func TestVarRevert(t *testing.T) {
+	min := 10.
+	v := db.NewVar("port", db.Int.Int())
+	v.ID = 1
+	v.Rules.Min = &min
+	v.Default = 80
+	v.Values = db.EnvsValue{"_dev.": 8080}
+
+	var dt = []struct {
+		in   *db.Change
+		out  db.EnvsValue
+		def  interface{}
+		defs db.EnvsValue
+		err  error
+	}{
+		{err: db.ErrNotFound},
+		{in: &db.Change{VarID: 2}, err: db.ErrNotFound},
+		{in: &db.Change{VarID: 1, Cells: []db.Cell{{ID: "_dev.", Before: "a"}}}, err: db.ErrInvalid},
+		{in: &db.Change{VarID: 1, Cells: []db.Cell{{ID: "_qa.", Before: 8.}}}, err: db.ErrRule},
+		{
+			in: &db.Change{VarID: 1, Cells: []db.Cell{
+				{ID: db.DefaultCell, Before: 81.},
+				{ID: db.DefaultCell + "prod", Before: 443.},
+				{ID: "_dev.", After: 8080},
+				{ID: "_qa.", Before: 8081.},
+			}},
+			out:  db.EnvsValue{"_qa.": 8081},
+			def:  81,
+			defs: db.EnvsValue{"prod": 443},
+		},
+	}
+	for i, tt := range dt {
+		w := *v
+		if err := w.Revert(tt.in); errors.Cause(err) != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		} else if err == nil && (!reflect.DeepEqual(tt.out, w.Values) || tt.def != w.Default || !reflect.DeepEqual(tt.defs, w.Defaults)) {
+			t.Errorf("%d. content mismatch: exp=%v %v %v got=%v %v %v", i, tt.out, tt.def, tt.defs, w.Values, w.Default, w.Defaults)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package db
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Hook is an HTTP webhook called after each deployment and each change of a variable.
+// Without project, it is called for all of them. Its secret signs the payloads.
+type Hook struct {
+	ID           uint64    `json:"id"`
+	ProjectID    string    `json:"project_id,omitempty"`
+	URL          string    `json:"url"`
+	Secret       string    `json:"secret,omitempty"`
+	LastUpdateTs time.Time `json:"upd_ts"`
+}
+
+// NewHook returns a new instance of Hook.
+func NewHook(projectID, url, secret string) *Hook {
+	return &Hook{ProjectID: projectID, URL: url, Secret: secret}
+}
+
+// Global returns true if the hook is called for all the projects.
+func (h *Hook) Global() bool {
+	return h.ProjectID == ""
+}
+
+// AutoIncrementing return true in order to have auo-increment primary key.
+func (h *Hook) AutoIncrementing() bool {
+	return true
+}
+
+// Key returns the key of the hook.
+func (h *Hook) Key() []byte {
+	if h.ID == 0 {
+		return nil
+	}
+	return itob(h.ID)
+}
+
+// SetKey returns if error if the change of the key failed.
+func (h *Hook) SetKey(k []byte) error {
+	h.ID = btoi(k)
+	return nil
+}
+
+// Updated changes the last update date of the hook.
+func (h *Hook) Updated() {
+	h.LastUpdateTs = time.Now()
+}
+
+// Valid checks if all required data as well formed.
+// The URL must be an absolute HTTP one.
+func (h *Hook) Valid(insert bool) error {
+	h.URL = strings.TrimSpace(h.URL)
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalid
+	}
+	if !insert && h.ID == 0 {
+		return ErrOutOfBounds
+	}
+	return nil
+}
+
+// Delivery is one payload to post to a webhook, kept until its success.
+// A delivery without next attempt has been abandoned after too many failures.
+type Delivery struct {
+	ID           uint64          `json:"id"`
+	HookID       uint64          `json:"hook_id"`
+	Payload      json.RawMessage `json:"payload"`
+	Attempts     int             `json:"attempts,omitempty"`
+	Err          string          `json:"err,omitempty"`
+	NextTs       time.Time       `json:"next_ts"`
+	LastUpdateTs time.Time       `json:"upd_ts"`
+}
+
+// NewDelivery returns a new delivery of the payload to the hook, to post now.
+func NewDelivery(hook uint64, payload []byte) *Delivery {
+	return &Delivery{HookID: hook, Payload: payload, NextTs: time.Now()}
+}
+
+// Abandoned returns true if the delivery will not be tried again.
+func (d *Delivery) Abandoned() bool {
+	return d.NextTs.IsZero()
+}
+
+// AutoIncrementing return true in order to have auo-increment primary key.
+func (d *Delivery) AutoIncrementing() bool {
+	return true
+}
+
+// Key returns the key of the delivery.
+func (d *Delivery) Key() []byte {
+	if d.ID == 0 {
+		return nil
+	}
+	return itob(d.ID)
+}
+
+// SetKey returns if error if the change of the key failed.
+func (d *Delivery) SetKey(k []byte) error {
+	d.ID = btoi(k)
+	return nil
+}
+
+// Updated changes the last update date of the delivery.
+func (d *Delivery) Updated() {
+	d.LastUpdateTs = time.Now()
+}
+
+// Valid checks if all required data as well formed.
+func (d *Delivery) Valid(insert bool) error {
+	if d.HookID == 0 || len(d.Payload) == 0 {
+		return ErrMissing
+	}
+	if !insert && d.ID == 0 {
+		return ErrOutOfBounds
+	}
+	return nil
+}
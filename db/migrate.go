@@ -0,0 +1,75 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package db
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// Version is the version of the schema of the database.
+const Version = 1
+
+// versionKey is the key of the schema's version in the meta bucket.
+var versionKey = []byte("version")
+
+// migrations lists the upgrades to apply, by version of the schema.
+var migrations = []func(tx *bolt.Tx) error{
+	orderEnvs,
+}
+
+// migrate applies the upgrades required by the data
+// saved with a previous version of the schema.
+func migrate(tx *bolt.Tx) error {
+	b := tx.Bucket(meta)
+	var from uint64
+	if v := b.Get(versionKey); len(v) > 0 {
+		from = btoi(v)
+	}
+	if from >= Version {
+		return nil
+	}
+	for i := from; i < Version; i++ {
+		if err := migrations[i](tx); err != nil {
+			return errors.WithMessage(err, "migration")
+		}
+	}
+	return b.Put(versionKey, itob(Version))
+}
+
+// orderEnvs saves the environments of the projects in the order of their dimensions.
+// Before the version 1, a project had until two environments
+// and the one with the bigger identifier was the first one.
+func orderEnvs(tx *bolt.Tx) error {
+	b := tx.Bucket(projects)
+	upd := make(map[string][]byte)
+	err := b.ForEach(func(k, v []byte) error {
+		p := &Project{}
+		if err := json.Unmarshal(v, p); err != nil {
+			return err
+		}
+		if len(p.EnvList) != 2 || p.EnvList[0] > p.EnvList[1] {
+			return nil
+		}
+		p.EnvList[0], p.EnvList[1] = p.EnvList[1], p.EnvList[0]
+		buf, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		upd[string(k)] = buf
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, v := range upd {
+		if err = b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,13 +6,32 @@ package db
 
 import (
 	"net"
+	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/rvflash/eve/deploy"
+)
+
+// List of types of node.
+// By default, a node is a cache server reached by RPC, the others
+// write a file by project in the directory given as address.
+const (
+	RPCNode       = ""
+	DotenvNode    = "dotenv"
+	JSONNode      = "json"
+	ConfigMapNode = "configmap"
+	SecretNode    = "secret"
+	SystemdNode   = "systemd"
 )
 
-// Node represents a server used as cache by EVE.
+// NodeTypes lists the types of node writing files.
+var NodeTypes = []string{DotenvNode, JSONNode, ConfigMapNode, SecretNode, SystemdNode}
+
+// Node represents a server used as cache by EVE, or a destination of the deployments.
 type Node struct {
 	Addr  string    `json:"naddr"`
+	Type  string    `json:"type,omitempty"`
 	AddTs time.Time `json:"upd_ts"`
 }
 
@@ -21,6 +40,27 @@ func NewNode(server string) *Node {
 	return &Node{Addr: server}
 }
 
+// File returns the destination writing the data of the project in the directory of the node.
+// It returns nil for a cache server.
+func (c *Node) File(project string) *deploy.File {
+	path := func(ext string) string {
+		return filepath.Join(c.Addr, project+ext)
+	}
+	switch c.Type {
+	case DotenvNode:
+		return deploy.NewFile(path(".env"), deploy.Dotenv)
+	case JSONNode:
+		return deploy.NewFile(path(".json"), deploy.JSON)
+	case ConfigMapNode:
+		return deploy.NewFile(path("-configmap.yaml"), deploy.ConfigMap(project))
+	case SecretNode:
+		return deploy.NewFile(path("-secret.yaml"), deploy.Secret(project))
+	case SystemdNode:
+		return deploy.NewFile(path(".conf"), deploy.Systemd)
+	}
+	return nil
+}
+
 // AutoIncrementing return true in order to have auo-increment primary key.
 func (c *Node) AutoIncrementing() bool {
 	return false
@@ -53,7 +93,24 @@ func (c *Node) Valid(insert bool) error {
 	if c.Addr == "" {
 		return ErrInvalid
 	}
+	if c.Type != RPCNode {
+		// Expects the absolute path of a directory.
+		if !c.known() || !filepath.IsAbs(c.Addr) {
+			return ErrInvalid
+		}
+		c.Addr = filepath.Clean(c.Addr)
+		return nil
+	}
 	// Tries to resolve the server names as TCP address.
 	_, err := net.ResolveTCPAddr("tcp", c.Addr)
 	return err
 }
+
+func (c *Node) known() bool {
+	for _, t := range NodeTypes {
+		if c.Type == t {
+			return true
+		}
+	}
+	return false
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/rvflash/eve/deploy"
 )
 
@@ -59,95 +60,174 @@ func (p *Project) DeleteVar(v *Var) (err error) {
 }
 
 // ToDeploy returns the list of key / value to deploy.
-// This list if filtered by the values of the first and the second env.
-// Variable to remove are given with nil value.
-func (p *Project) ToDeploy(firstEnvValues, secondEnvValues []string) map[string]interface{} {
-	// Transforms the values of envs as map to perform search on it.
-	ev1 := toMap(p.FirstEnv().Values)
-	ev2 := toMap(p.SecondEnv().Values)
-	// Gets a map with the list of available environment combinations.
-	combined := func() map[string]struct{} {
-		size := len(firstEnvValues) * len(secondEnvValues)
-		envs := make(map[string]struct{}, size)
-		for _, e1 := range firstEnvValues {
-			if _, ok := ev1[e1]; !ok {
-				return nil
-			}
-			for _, e2 := range secondEnvValues {
-				if _, ok := ev2[e2]; !ok {
-					return nil
-				}
-				v := &VarID{e1, e2}
-				envs[v.String()] = struct{}{}
-			}
-		}
-		return envs
-	}
-	envs := combined()
+// This list if filtered by the values of each environment, given in their order.
+// The values not overridden are resolved with the defaults of their variable,
+// and the references to other variables with their values.
+// Variable to remove are given with nil value, like the ones without value for these environments,
+// to remove the values previously deployed.
+func (p *Project) ToDeploy(envsValues [][]string) map[string]interface{} {
+	envs := p.combine(envsValues)
 	if len(envs) == 0 {
 		return nil
 	}
 	//  returns the variable's key used by the deployment.
 	deployKey := func(varID, varName string) string {
-		i := NewVarID(varID)
-		return deploy.Key(p.ID, i.EnvValue1, i.EnvValue2, varName)
+		parts := append([]string{p.ID}, envs[varID]...)
+		return deploy.Key(append(parts, varName)...)
 	}
 	// Builds the list of values that match the environments to deploy.
 	deployed := make(map[string]interface{})
 	for _, d := range p.vars {
 		v := d.(*Var)
-		for key, value := range v.Values {
-			if _, ok := envs[key]; ok {
-				if v.Deleted() {
-					value = nil
-				}
-				deployed[deployKey(key, v.Name)] = value
+		for key := range envs {
+			// The broken references are refused by the validation before any deployment.
+			value, err := p.Resolve(v, key)
+			if err != nil {
+				value = v.Value(key)
+			}
+			if v.Deleted() {
+				value = nil
 			}
+			deployed[deployKey(key, v.Name)] = value
 		}
 	}
 	return deployed
 }
 
+// varCell is the cell of a variable for one combination of environments values.
+type varCell struct {
+	v  *Var
+	id string
+}
+
+// cells returns by deploy key the cells of the live variables for these environments.
+func (p *Project) cells(envsValues [][]string) map[string]varCell {
+	envs := p.combine(envsValues)
+	m := make(map[string]varCell)
+	for _, d := range p.vars {
+		v := d.(*Var)
+		if v.Deleted() {
+			continue
+		}
+		for id, c := range envs {
+			m[deploy.Key(append(append([]string{p.ID}, c...), v.Name)...)] = varCell{v: v, id: id}
+		}
+	}
+	return m
+}
+
+// Validate checks the references and the rules of the variables on the values
+// to deploy for these environments. Without names, all the variables are checked.
+// It implements the deploy.Validator interface.
+func (p *Project) Validate(envsValues [][]string, names ...string) error {
+	envs := p.combine(envsValues)
+	only := toMap(names)
+	for _, d := range p.vars {
+		v := d.(*Var)
+		if v.Deleted() {
+			continue
+		}
+		if _, ok := only[v.Name]; len(only) > 0 && !ok {
+			continue
+		}
+		var err error
+		values := make(EnvsValue, len(envs))
+		for k := range envs {
+			if values[k], err = p.Resolve(v, k); err != nil {
+				return errors.WithMessage(err, v.Name)
+			}
+		}
+		if err = v.Rules.Check(v.Kind, values); err != nil {
+			return errors.WithMessage(err, v.Name)
+		}
+	}
+	return nil
+}
+
+// Tags returns the sorted list of the tags used by the variables of the project.
+func (p *Project) Tags() []string {
+	var all []string
+	for _, d := range p.vars {
+		if v := d.(*Var); !v.Deleted() {
+			all = append(all, v.Tags...)
+		}
+	}
+	return tags(all)
+}
+
+// Tagged returns the names of the variables having at least one of these tags.
+func (p *Project) Tagged(tags ...string) []string {
+	var names []string
+	for _, d := range p.vars {
+		if v := d.(*Var); !v.Deleted() && v.Tagged(tags...) {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
 // Vars returns all the variables of the project.
 func (p *Project) Vars() []Keyer {
 	return p.vars
 }
 
-// Envs returns the envs of the project.
-func (p *Project) Envs() (envs []Keyer) {
-	envs = make([]Keyer, 2)
-	switch len(p.envs) {
-	case 2:
-		// A choice must be done to select a persistent "main" environment.
-		// We arbitrary choose the env with the bigger identifier as leader.
-		// Prevents flip flaps in case of changing of number of environment values.
-		if p.envs[0].(*Env).ID > p.envs[1].(*Env).ID {
-			envs[0], envs[1] = p.envs[0], p.envs[1]
-		} else {
-			envs[0], envs[1] = p.envs[1], p.envs[0]
-		}
-	case 1:
-		envs[0], envs[1] = p.envs[0], DefaultEnv
-	default:
-		envs[0], envs[1] = DefaultEnv, DefaultEnv
+// minEnvs is the minimum number of environments of a project,
+// completed if necessary by the default environment.
+const minEnvs = 2
+
+// Envs returns the envs of the project, in the order of their dimensions.
+func (p *Project) Envs() []Keyer {
+	envs := make([]Keyer, 0, minEnvs)
+	envs = append(envs, p.envs...)
+	for len(envs) < minEnvs {
+		envs = append(envs, DefaultEnv)
 	}
-	return
+	return envs
 }
 
 // EnvsValues returns all available values by environment.
-func (p *Project) EnvsValues() (firstEnvValues, secondEnvValues []string) {
-	return p.FirstEnv().Values, p.SecondEnv().Values
+func (p *Project) EnvsValues() [][]string {
+	envs := p.environments()
+	values := make([][]string, len(envs))
+	for i, e := range envs {
+		values[i] = e.Values
+	}
+	return values
 }
 
-// AddEnv adds a env to the project.
-// Only two dimensions are managed by the system.
-func (p *Project) AddEnv(e *Env) error {
-	if len(p.EnvList) > 1 {
-		return ErrOutOfBounds
+// Protected returns the protected values in these environments values,
+// the ones whose deployment needs an approval.
+func (p *Project) Protected(envsValues [][]string) []string {
+	var res []string
+	for i, e := range p.environments() {
+		if i >= len(envsValues) {
+			break
+		}
+		for _, v := range envsValues[i] {
+			if e.Protects(v) {
+				res = append(res, v)
+			}
+		}
 	}
+	return res
+}
+
+// Rows returns the combinations of the values of the environments after the first one.
+// With the values of the first environment as columns, they allow to display the values as a table.
+func (p *Project) Rows() [][]string {
+	return deploy.Combine(p.EnvsValues()[1:])
+}
+
+// AddEnv adds a env to the project, as its last dimension.
+func (p *Project) AddEnv(e *Env) error {
 	if e.ID == 0 {
 		return ErrMissing
 	}
+	for _, id := range p.EnvList {
+		if id == e.ID {
+			return ErrAlreadyExists
+		}
+	}
 	p.EnvList = append(p.EnvList, e.ID)
 
 	return nil
@@ -174,6 +254,38 @@ func (p *Project) SecondEnv() *Env {
 	return p.Envs()[1].(*Env)
 }
 
+// environments returns the envs of the project, in the order of their dimensions.
+func (p *Project) environments() []*Env {
+	envs := p.Envs()
+	res := make([]*Env, len(envs))
+	for i, e := range envs {
+		res[i] = e.(*Env)
+	}
+	return res
+}
+
+// combine returns the VarIDs of the combinations of these environments values
+// with their values. It returns nil if one of them is unknown.
+func (p *Project) combine(envsValues [][]string) map[string][]string {
+	all := p.EnvsValues()
+	if len(envsValues) != len(all) {
+		return nil
+	}
+	for i, values := range envsValues {
+		known := toMap(all[i])
+		for _, v := range values {
+			if _, ok := known[v]; !ok {
+				return nil
+			}
+		}
+	}
+	envs := make(map[string][]string)
+	for _, c := range deploy.Combine(envsValues) {
+		envs[VarID(c).String()] = c
+	}
+	return envs
+}
+
 // Deployed returns true if the project if already deployed.
 func (p *Project) Deployed() bool {
 	return !p.LastDeployTs.IsZero()
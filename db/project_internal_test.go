@@ -3,6 +3,9 @@ package db
 import (
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 func TestProjectEnvs(t *testing.T) {
@@ -11,7 +14,7 @@ func TestProjectEnvs(t *testing.T) {
 	}{
 		{om: DefaultEnv, os: DefaultEnv},
 		{im: &Env{ID: 1}, om: &Env{ID: 1}, os: DefaultEnv},
-		{im: &Env{ID: 1}, is: &Env{ID: 2}, om: &Env{ID: 2}, os: &Env{ID: 1}},
+		{im: &Env{ID: 1}, is: &Env{ID: 2}, om: &Env{ID: 1}, os: &Env{ID: 2}},
 		{im: &Env{ID: 11}, is: &Env{ID: 2}, om: &Env{ID: 11}, os: &Env{ID: 2}},
 	}
 	for i, tt := range dt {
@@ -37,3 +40,351 @@ func TestProjectEnvs(t *testing.T) {
 		}
 	}
 }
+
+func TestProjectValidate(t *testing.T) {
+	p := NewProject("test", "")
+	p.envs = []Keyer{&Env{ID: 1, Values: []string{"dev", "prod"}}}
+	host := NewVar("host", String.Int())
+	host.Values = EnvsValue{"_dev.": "", "_prod.": ""}
+	host.Rules.Required = []string{"prod"}
+	port := NewVar("port", Int.Int())
+	port.Values = EnvsValue{"_dev.": 80}
+	port.Rules.Required = []string{"dev", "prod"}
+	p.vars = []Keyer{host, port}
+
+	var dt = []struct {
+		envs  []string
+		names []string
+		ok    bool
+	}{
+		{envs: []string{"dev"}, ok: true},
+		{envs: []string{"prod"}},
+		{envs: []string{"dev", "prod"}},
+		{envs: []string{"prod"}, names: []string{"port"}},
+		{envs: []string{"prod"}, names: []string{"other"}, ok: true},
+	}
+	for i, tt := range dt {
+		if err := p.Validate([][]string{tt.envs, {""}}, tt.names...); (err == nil) != tt.ok {
+			t.Errorf("%d. validity mismatch: exp=%t got=%v", i, tt.ok, err)
+		}
+	}
+}
+
+func TestProjectTags(t *testing.T) {
+	p := NewProject("test", "")
+	if tags := p.Tags(); tags != nil {
+		t.Fatalf("tags mismatch: exp=nil got=%q", tags)
+	}
+	flag := NewVar("flag", Bool.Int())
+	flag.Tags = []string{"home", "feature-flag"}
+	host := NewVar("host", String.Int())
+	host.Tags = []string{"api"}
+	old := NewVar("old", Bool.Int())
+	old.Tags = []string{"feature-flag", "legacy"}
+	old.DeletionTs = time.Now()
+	p.vars = []Keyer{flag, host, old}
+
+	if exp, tags := []string{"api", "feature-flag", "home"}, p.Tags(); !reflect.DeepEqual(exp, tags) {
+		t.Errorf("tags mismatch: exp=%q got=%q", exp, tags)
+	}
+	var dt = []struct {
+		in, out []string
+	}{
+		{in: []string{"legacy"}},
+		{in: []string{"feature-flag"}, out: []string{"flag"}},
+		{in: []string{"home", "api"}, out: []string{"flag", "host"}},
+	}
+	for i, tt := range dt {
+		if names := p.Tagged(tt.in...); !reflect.DeepEqual(tt.out, names) {
+			t.Errorf("%d. names mismatch: exp=%q got=%q", i, tt.out, names)
+		}
+	}
+}
+
+func TestProjectProtected(t *testing.T) {
+	p := NewProject("test", "")
+	p.envs = []Keyer{
+		&Env{ID: 1, Values: []string{"dev", "prod"}, Protected: []string{"prod"}},
+		&Env{ID: 2, Values: []string{"eu", "us"}},
+	}
+	var dt = []struct {
+		in  [][]string
+		out []string
+	}{
+		{in: [][]string{{"dev"}, {"eu", "us"}}},
+		{in: [][]string{{"dev", "prod"}, {"eu"}}, out: []string{"prod"}},
+		{in: [][]string{{"prod"}}, out: []string{"prod"}},
+	}
+	for i, tt := range dt {
+		if out := p.Protected(tt.in); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. protected mismatch: exp=%q got=%q", i, tt.out, out)
+		}
+	}
+}
+
+func TestProjectThreeEnvs(t *testing.T) {
+	p := NewProject("test", "")
+	p.envs = []Keyer{
+		&Env{ID: 1, Values: []string{"eu", "us"}},
+		&Env{ID: 2, Values: []string{"dev", "prod"}},
+		&Env{ID: 3, Values: []string{"fr"}},
+	}
+	if n := len(p.Envs()); n != 3 {
+		t.Fatalf("envs mismatch: exp=3 got=%d", n)
+	}
+	exp := [][]string{{"dev", "fr"}, {"prod", "fr"}}
+	if rows := p.Rows(); !reflect.DeepEqual(rows, exp) {
+		t.Errorf("rows mismatch: exp=%q got=%q", exp, rows)
+	}
+	host := NewVar("host", String.Int())
+	if err := host.CleanValues(p.environments()...); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if n := len(host.NewValues(p.environments()...)); n != 4 {
+		t.Fatalf("values mismatch: exp=4 got=%d", n)
+	}
+	host.Values["_eu.prod.fr"] = "eu.example.com"
+	host.Values["_us.prod.fr"] = "us.example.com"
+	host.Defaults = EnvsValue{"dev": "dev.example.com"}
+	var dt = []struct {
+		in  [][]string
+		out map[string]interface{}
+	}{
+		{in: [][]string{{"eu"}, {"prod"}}},
+		{in: [][]string{{"eu"}, {"qa"}, {"fr"}}},
+		{
+			in:  [][]string{{"eu", "us"}, {"prod"}, {"fr"}},
+			out: map[string]interface{}{"TEST_EU_PROD_FR_HOST": "eu.example.com", "TEST_US_PROD_FR_HOST": "us.example.com"},
+		},
+		{
+			in:  [][]string{{"eu"}, {"dev", "prod"}, {"fr"}},
+			out: map[string]interface{}{"TEST_EU_DEV_FR_HOST": "dev.example.com", "TEST_EU_PROD_FR_HOST": "eu.example.com"},
+		},
+	}
+	p.vars = []Keyer{host}
+	for i, tt := range dt {
+		if out := p.ToDeploy(tt.in); !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("%d. content mismatch: exp=%v got=%v", i, tt.out, out)
+		}
+	}
+	// A cell inheriting no value is deployed as nil to remove the previous one.
+	delete(host.Values, "_us.prod.fr")
+	out := p.ToDeploy([][]string{{"us"}, {"prod"}, {"fr"}})
+	if v, ok := out["TEST_US_PROD_FR_HOST"]; !ok || v != nil {
+		t.Errorf("content mismatch: exp=nil got=%v (%t)", v, ok)
+	}
+}
+
+func TestProjectResolve(t *testing.T) {
+	p := NewProject("test", "")
+	p.envs = []Keyer{&Env{ID: 1, Values: []string{"dev", "prod"}}}
+	host := NewVar("db_host", String.Int())
+	host.Values = EnvsValue{"_dev.": "localhost", "_prod.": "db.example.com"}
+	port := NewVar("DB_PORT", Int.Int())
+	port.Default = 5432
+	pass := NewVar("DB_PASS", Secret.Int())
+	pass.Default = "secret"
+	dsn := NewVar("DSN", URL.Int())
+	dsn.Default = "postgres://${DB_HOST}:${DB_PORT}/app"
+	dsn.Values = EnvsValue{"_prod.": "postgres://${DB_HOST}:${DB_PORT}/${DB_NAME}"}
+	loop := NewVar("loop", String.Int())
+	loop.Default = "${LOOP}"
+	p.vars = []Keyer{host, port, pass, dsn, loop}
+
+	var dt = []struct {
+		v   *Var
+		id  string
+		out interface{}
+		err error
+	}{
+		{v: port, id: "_dev.", out: 5432},
+		{v: dsn, id: "_dev.", out: "postgres://localhost:5432/app"},
+		{v: dsn, id: "_prod.", err: ErrNotFound},
+		{v: loop, id: "_dev.", err: ErrCycle},
+	}
+	for i, tt := range dt {
+		out, err := p.Resolve(tt.v, tt.id)
+		if errors.Cause(err) != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		} else if !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. content mismatch: exp=%v got=%v", i, tt.out, out)
+		}
+	}
+	out := p.ToDeploy([][]string{{"dev"}, {""}})
+	if d := out["TEST_DEV_DSN"]; d != "postgres://localhost:5432/app" {
+		t.Errorf("deploy mismatch: got=%v", d)
+	}
+	if err := p.Validate([][]string{{"dev", "prod"}, {""}}, "DSN"); errors.Cause(err) != ErrNotFound {
+		t.Errorf("validity mismatch: exp=%v got=%v", ErrNotFound, err)
+	}
+}
+
+func TestProjectRefer(t *testing.T) {
+	p := NewProject("test", "")
+	host := NewVar("DB_HOST", String.Int())
+	pass := NewVar("DB_PASS", String.Int())
+	pass.Sensitive = true
+	url := NewVar("URL", String.Int())
+	url.Default = "http://${DB_HOST}"
+	p.vars = []Keyer{host, pass, url}
+
+	var dt = []struct {
+		v   *Var
+		def string
+		err error
+	}{
+		{v: NewVar("DSN", String.Int()), def: "${DB_HOST}:${URL}"},
+		{v: NewVar("DSN", String.Int()), def: "${DB_NAME}", err: ErrNotFound},
+		{v: NewVar("DSN", String.Int()), def: "${DB_PASS}", err: ErrInvalid},
+		{v: NewVar("DSN", String.Int()), def: "${DSN}", err: ErrCycle},
+		{v: host, def: "${url}", err: ErrCycle},
+	}
+	for i, tt := range dt {
+		tt.v.Default = tt.def
+		if err := p.Refer(tt.v); errors.Cause(err) != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		}
+	}
+}
+
+func TestProjectRollback(t *testing.T) {
+	p := NewProject("test", "")
+	p.envs = []Keyer{&Env{ID: 1, Values: []string{"dev", "prod"}}}
+	port := NewVar("port", Int.Int())
+	port.Values = EnvsValue{"_prod.": 80}
+	host := NewVar("host", String.Int())
+	host.Default = "localhost"
+	p.vars = []Keyer{port, host}
+
+	// The values as read from the database.
+	d := NewDeploy("test", ":9090")
+	d.EnvsValues = [][]string{{"prod"}, {""}}
+	d.ItemList = map[string]interface{}{"TEST_PROD_PORT": 8080., "TEST_PROD_HOST": "localhost", "TEST_PROD_OLD": nil}
+
+	if _, err := p.Rollback(NewDeploy("other")); err != ErrInvalid {
+		t.Errorf("error mismatch: exp=%v got=%v", ErrInvalid, err)
+	}
+	if _, err := p.Rollback(NewDeploy("test")); err != ErrMissing {
+		t.Errorf("error mismatch: exp=%v got=%v", ErrMissing, err)
+	}
+	src, err := p.Rollback(d)
+	if err != nil {
+		t.Fatalf("unexpected error: got=%v", err)
+	}
+	exp := map[string]interface{}{"TEST_PROD_PORT": 8080, "TEST_PROD_HOST": "localhost", "TEST_PROD_OLD": nil}
+	if out := src.ToDeploy(nil); !reflect.DeepEqual(exp, out) {
+		t.Errorf("content mismatch: exp=%v got=%v", exp, out)
+	}
+	if envs := src.EnvsValues(); !reflect.DeepEqual(d.EnvsValues, envs) {
+		t.Errorf("envs mismatch: exp=%v got=%v", d.EnvsValues, envs)
+	}
+
+	// Only the port differs from the current values.
+	if vars, err := p.Restore(d, nil, "host"); err != nil || len(vars) != 0 {
+		t.Errorf("restore mismatch: exp=none got=%v (%v)", vars, err)
+	}
+	vars, err := p.Restore(d, nil)
+	if err != nil || len(vars) != 1 || vars[0] != port {
+		t.Fatalf("restore mismatch: exp=[port] got=%v (%v)", vars, err)
+	}
+	if d := port.Values["_prod."]; d != 8080 {
+		t.Errorf("value mismatch: exp=8080 got=%v", d)
+	}
+}
+
+func TestProjectCanary(t *testing.T) {
+	p := NewProject("test", "")
+	p.envs = []Keyer{&Env{ID: 1, Values: []string{"dev", "prod"}}}
+	port := NewVar("port", Int.Int())
+	p.vars = []Keyer{port}
+
+	// The values as read from the database.
+	d := NewDeploy("test", ":9090", ":9091")
+	d.EnvsValues = [][]string{{"prod"}, {""}}
+	d.ItemList = map[string]interface{}{"TEST_PROD_PORT": 8080.}
+	d.ServerList[0].Before = map[string]interface{}{"TEST_PROD_PORT": 80.}
+
+	if _, _, err := p.Canary(d); err != ErrInvalid {
+		t.Errorf("error mismatch: exp=%v got=%v", ErrInvalid, err)
+	}
+	d.Stage = StageCanary
+	src, before, err := p.Canary(d)
+	if err != nil {
+		t.Fatalf("unexpected error: got=%v", err)
+	}
+	exp := map[int]map[string]interface{}{0: {"TEST_PROD_PORT": 80}}
+	if !reflect.DeepEqual(exp, before) {
+		t.Errorf("before mismatch: exp=%v got=%v", exp, before)
+	}
+	if out := src.ToDeploy(nil); out["TEST_PROD_PORT"] != 8080 {
+		t.Errorf("content mismatch: exp=8080 got=%v", out["TEST_PROD_PORT"])
+	}
+	// Without the values before the push, the deployment can not be aborted.
+	d.ServerList[0].Before = nil
+	if _, _, err := p.Canary(d); err != ErrMissing {
+		t.Errorf("error mismatch: exp=%v got=%v", ErrMissing, err)
+	}
+}
+
+func TestProjectRestore(t *testing.T) {
+	p := NewProject("test", "")
+	p.envs = []Keyer{&Env{ID: 1, Values: []string{"dev", "prod"}}}
+	host := NewVar("host", String.Int())
+	host.ID, host.Default = 1, "localhost"
+	url := NewVar("url", String.Int())
+	url.ID, url.Values = 2, EnvsValue{"_prod.": "http://example.com"}
+	name := NewVar("name", String.Int())
+	name.ID, name.Default, name.Values = 3, "eve", EnvsValue{"_prod.": "other"}
+	tag := NewVar("tag", String.Int())
+	tag.ID, tag.Default = 4, "new"
+	port := NewVar("port", Int.Int())
+	port.ID, port.Values = 5, EnvsValue{"_prod.": 80}
+	port.Rules.Max = new(float64)
+	*port.Rules.Max = 1024
+	p.vars = []Keyer{host, url, name, tag, port}
+
+	d := NewDeploy("test", ":9090")
+	d.EnvsValues = [][]string{{"prod"}, {""}}
+	d.LastUpdateTs = time.Now().Add(-time.Hour)
+	d.ItemList = map[string]interface{}{
+		"TEST_PROD_HOST": "localhost",
+		"TEST_PROD_URL":  "http://localhost",
+		"TEST_PROD_NAME": "eve",
+		"TEST_PROD_TAG":  "old",
+		"TEST_PROD_PORT": 8080.,
+	}
+	// The changes made before and after the deployment, newest first.
+	history := map[uint64][]*Change{
+		2: {
+			{Cells: []Cell{{ID: "_prod.", Before: "http://${HOST}", After: "http://example.com"}}, Ts: time.Now()},
+			{Cells: []Cell{{ID: "_prod.", Before: "http://old", After: "http://${HOST}"}}, Ts: time.Now().Add(-2 * time.Hour)},
+		},
+		3: {{Cells: []Cell{{ID: "_prod.", After: "other"}}, Ts: time.Now()}},
+		4: {{Cells: []Cell{{ID: DefaultCell, Before: "old", After: "new"}}, Ts: time.Now()}},
+	}
+
+	// The port breaks its rules.
+	if _, err := p.Restore(d, history); errors.Cause(err) != ErrRule {
+		t.Fatalf("error mismatch: exp=%v got=%v", ErrRule, err)
+	}
+	if d := url.Values["_prod."]; d != "http://example.com" {
+		t.Fatalf("url mismatch: exp=unchanged got=%v", d)
+	}
+	vars, err := p.Restore(d, history, "host", "url", "name", "tag")
+	if err != nil {
+		t.Fatalf("unexpected error: got=%v", err)
+	}
+	if len(vars) != 3 || vars[0] != name || vars[1] != tag || vars[2] != url {
+		t.Fatalf("restore mismatch: exp=[name tag url] got=%v", vars)
+	}
+	// The reference and the inheritance are restored, the item only without them.
+	if d := url.Values["_prod."]; d != "http://${HOST}" {
+		t.Errorf("url mismatch: exp=%q got=%v", "http://${HOST}", d)
+	}
+	if !name.Inherited("_prod.") {
+		t.Errorf("name mismatch: exp=inherited got=%v", name.Values["_prod."])
+	}
+	if d := tag.Values["_prod."]; d != "old" || tag.Default != "new" {
+		t.Errorf("tag mismatch: exp=old got=%v", d)
+	}
+}
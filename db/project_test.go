@@ -66,8 +66,8 @@ func TestNewProject(t *testing.T) {
 
 func TestNewProjectEnvs(t *testing.T) {
 	var dt = []struct {
-		in, in1         *db.Env
-		err, err1, err2 error
+		in, in1               *db.Env
+		err, err1, err2, err3 error
 	}{
 		// error
 		{
@@ -76,18 +76,27 @@ func TestNewProjectEnvs(t *testing.T) {
 			err:  db.ErrMissing,
 			err1: db.ErrMissing,
 			err2: db.ErrMissing,
+			err3: db.ErrMissing,
 		},
 		// valid
 		{
 			in:   &db.Env{ID: 1},
 			in1:  &db.Env{ID: 1},
-			err2: db.ErrOutOfBounds,
+			err1: db.ErrNotFound,
+			err2: db.ErrAlreadyExists,
+			err3: db.ErrAlreadyExists,
 		},
 		{
 			in:   &db.Env{ID: 1},
 			in1:  &db.Env{ID: 2},
 			err1: db.ErrNotFound,
-			err2: db.ErrOutOfBounds,
+			err2: db.ErrAlreadyExists,
+		},
+		{
+			in:   &db.Env{ID: 3},
+			in1:  &db.Env{ID: 4},
+			err1: db.ErrNotFound,
+			err2: db.ErrAlreadyExists,
 		},
 	}
 	p := db.NewProject("test", "")
@@ -95,8 +104,8 @@ func TestNewProjectEnvs(t *testing.T) {
 		if err := p.AddEnv(tt.in); tt.err != err {
 			t.Errorf("%d. add error mismatch: exp=%q got=%q", i, tt.err, err)
 		}
-		if err := p.AddEnv(tt.in1); tt.err != err {
-			t.Errorf("%d. add error mismatch: exp=%q got=%q", i, tt.err, err)
+		if err := p.AddEnv(tt.in1); tt.err3 != err {
+			t.Errorf("%d. add error mismatch: exp=%q got=%q", i, tt.err3, err)
 		}
 		if err := p.AddEnv(tt.in1); tt.err2 != err {
 			t.Errorf("%d. add error mismatch: exp=%q got=%q", i, tt.err2, err)
@@ -108,6 +117,10 @@ func TestNewProjectEnvs(t *testing.T) {
 			t.Errorf("%d. del error mismatch: exp=%q got=%q", i, tt.err1, err)
 		}
 	}
+	// Keeps the order of the environments.
+	if exp := []uint64{2, 4}; !reflect.DeepEqual(p.EnvList, exp) {
+		t.Errorf("envs mismatch: exp=%v got=%v", exp, p.EnvList)
+	}
 }
 
 func TestNewProjectVars(t *testing.T) {
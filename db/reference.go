@@ -0,0 +1,142 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package db
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCycle is returned when a variable refers to itself, directly or through others.
+var ErrCycle = errors.New("cyclic reference")
+
+// reference matches a reference to another variable, like ${DB_HOST}.
+var reference = regexp.MustCompile(`\$\{([a-zA-Z0-9_-]+)\}`)
+
+// References returns the sorted names of the variables referred in the string.
+func References(s string) []string {
+	var names []string
+	exists := map[string]bool{}
+	for _, m := range reference.FindAllStringSubmatch(s, -1) {
+		if !exists[m[1]] {
+			exists[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// References returns the names of the variables referred by its values or defaults.
+func (v *Var) References() []string {
+	if !v.Kind.Interpolated() {
+		return nil
+	}
+	all := []string{v.Kind.Format(v.Default)}
+	for _, values := range []EnvsValue{v.Values, v.Defaults} {
+		for _, d := range values {
+			all = append(all, v.Kind.Format(d))
+		}
+	}
+	return References(strings.Join(all, " "))
+}
+
+// Refer checks that the references of the variable target the other visible
+// variables of the project, without coming back to it.
+func (p *Project) Refer(v *Var) error {
+	return p.refer(v, v, make(map[string]bool))
+}
+
+func (p *Project) refer(v, edited *Var, path map[string]bool) error {
+	key := strings.ToUpper(v.Name)
+	if path[key] {
+		return errors.WithMessage(ErrCycle, v.Name)
+	}
+	path[key] = true
+	defer delete(path, key)
+
+	for _, name := range v.References() {
+		w := edited
+		if !strings.EqualFold(name, edited.Name) {
+			w = p.variable(name)
+		}
+		if err := referable(w, name); err != nil {
+			return err
+		}
+		if err := p.refer(w, edited, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve returns the value of the variable for this combination of environments values,
+// with its references to the other variables of the project replaced by their values.
+func (p *Project) Resolve(v *Var, id string) (interface{}, error) {
+	return p.resolve(v, id, make(map[string]bool))
+}
+
+func (p *Project) resolve(v *Var, id string, path map[string]bool) (interface{}, error) {
+	d := v.Value(id)
+	if d == nil || !v.Kind.Interpolated() {
+		return d, nil
+	}
+	s := v.Kind.Format(d)
+	if !reference.MatchString(s) {
+		return d, nil
+	}
+	key := strings.ToUpper(v.Name)
+	if path[key] {
+		return nil, errors.WithMessage(ErrCycle, v.Name)
+	}
+	path[key] = true
+	defer delete(path, key)
+
+	var err error
+	s = reference.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		name := reference.FindStringSubmatch(ref)[1]
+		w := p.variable(name)
+		if err = referable(w, name); err != nil {
+			return ref
+		}
+		var r interface{}
+		if r, err = p.resolve(w, id, path); err == nil && r == nil {
+			err = errors.WithMessage(ErrMissing, name)
+		}
+		return w.Kind.Format(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.Kind.Parse(s)
+}
+
+// variable returns the live variable of the project with this name, case insensitive.
+func (p *Project) variable(name string) *Var {
+	for _, d := range p.vars {
+		if v := d.(*Var); !v.Deleted() && strings.EqualFold(v.Name, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// referable returns an error if the variable can not be referred by another one.
+// The hidden values are never copied into the other variables.
+func referable(v *Var, name string) error {
+	if v == nil {
+		return errors.WithMessage(ErrNotFound, name)
+	}
+	if v.Hidden() {
+		return errors.WithMessage(ErrInvalid, "hidden "+name)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package db
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rvflash/eve/keyring"
+)
+
+// ErrRule is returned when a value breaks one of the rules of its variable.
+var ErrRule = errors.New("broken rule")
+
+// Rules are the optional constraints on the values of a variable.
+// Min and Max bound the numbers, Pattern must match the strings
+// and Enum lists the only allowed values, formatted as strings.
+// Required lists the environment values for which the value can not be empty.
+type Rules struct {
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Pattern  string   `json:"regexp,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+	Required []string `json:"required,omitempty"`
+}
+
+// Valid checks if the rules are well formed.
+func (r *Rules) Valid() error {
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return errors.WithMessage(ErrInvalid, "min greater than max")
+	}
+	if r.Pattern != "" {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return errors.WithMessage(ErrInvalid, err.Error())
+		}
+	}
+	return nil
+}
+
+// Check returns an error if one of the values breaks the rules.
+// The values are identified by their VarID. A missing value is empty.
+// The encrypted values are only checked for emptiness.
+func (r *Rules) Check(kind Kind, values EnvsValue) error {
+	var re *regexp.Regexp
+	if r.Pattern != "" {
+		var err error
+		if re, err = regexp.Compile("^(?:" + r.Pattern + ")$"); err != nil {
+			return errors.WithMessage(ErrInvalid, err.Error())
+		}
+	}
+	for id, value := range values {
+		if err := r.check(kind, re, id, value); err != nil {
+			return errors.WithMessage(err, id)
+		}
+	}
+	return nil
+}
+
+func (r *Rules) check(kind Kind, re *regexp.Regexp, id string, value interface{}) error {
+	s := kind.Format(value)
+	if s == "" {
+		if r.required(NewVarID(id)) {
+			return errors.WithMessage(ErrRule, "value required")
+		}
+		return nil
+	}
+	if keyring.Sealed(s) || reference.MatchString(s) {
+		// Checked once decrypted or resolved.
+		return nil
+	}
+	switch kind {
+	case Int, Float:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return ErrInvalid
+		}
+		if r.Min != nil && f < *r.Min {
+			return errors.WithMessage(ErrRule, "less than "+strconv.FormatFloat(*r.Min, 'g', -1, 64))
+		}
+		if r.Max != nil && f > *r.Max {
+			return errors.WithMessage(ErrRule, "greater than "+strconv.FormatFloat(*r.Max, 'g', -1, 64))
+		}
+	case String, Secret, URL:
+		if re != nil && !re.MatchString(s) {
+			return errors.WithMessage(ErrRule, "not matching "+r.Pattern)
+		}
+	}
+	if len(r.Enum) == 0 {
+		return nil
+	}
+	items := []string{s}
+	if l, ok := kind.Assert(value); ok && kind == List {
+		items = l.([]string)
+	}
+	for _, v := range items {
+		if !r.allowed(v) {
+			return errors.WithMessage(ErrRule, v+" not in "+strings.Join(r.Enum, ", "))
+		}
+	}
+	return nil
+}
+
+// allowed returns true if the value is in the list of allowed values.
+func (r *Rules) allowed(s string) bool {
+	for _, v := range r.Enum {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// required returns true if the value of these environments can not be empty.
+func (r *Rules) required(id *VarID) bool {
+	for _, v := range r.Required {
+		for _, ev := range *id {
+			if v == ev {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/rvflash/eve/db"
+)
+
+func TestRulesValid(t *testing.T) {
+	one, two := 1.0, 2.0
+	var dt = []struct {
+		in db.Rules
+		ok bool
+	}{
+		{ok: true},
+		{in: db.Rules{Min: &one, Max: &two, Pattern: "^[a-z]+$"}, ok: true},
+		{in: db.Rules{Min: &two, Max: &one}},
+		{in: db.Rules{Pattern: "[a-z"}},
+	}
+	for i, tt := range dt {
+		if err := tt.in.Valid(); (err == nil) != tt.ok {
+			t.Errorf("%d. validity mismatch: exp=%t got=%q", i, tt.ok, err)
+		}
+	}
+}
+
+func TestRulesCheck(t *testing.T) {
+	one, ten := 1.0, 10.0
+	var dt = []struct {
+		rules  db.Rules
+		kind   db.Kind
+		values db.EnvsValue
+		err    error
+	}{
+		{kind: db.Int, values: db.EnvsValue{"_dev.": 42}},
+		{rules: db.Rules{Min: &one, Max: &ten}, kind: db.Int, values: db.EnvsValue{"_dev.": 5, "_qa.": 10}},
+		{rules: db.Rules{Min: &one, Max: &ten}, kind: db.Int, values: db.EnvsValue{"_dev.": 0}, err: db.ErrRule},
+		{rules: db.Rules{Min: &one, Max: &ten}, kind: db.Float, values: db.EnvsValue{"_dev.": 10.5}, err: db.ErrRule},
+		{rules: db.Rules{Pattern: "[a-z]+"}, kind: db.String, values: db.EnvsValue{"_dev.": "rv", "_qa.": ""}},
+		{rules: db.Rules{Pattern: "[a-z]+"}, kind: db.String, values: db.EnvsValue{"_dev.": "rv42"}, err: db.ErrRule},
+		{rules: db.Rules{Enum: []string{"fr", "en"}}, kind: db.String, values: db.EnvsValue{"_dev.": "fr"}},
+		{rules: db.Rules{Enum: []string{"fr", "en"}}, kind: db.String, values: db.EnvsValue{"_dev.": "de"}, err: db.ErrRule},
+		{rules: db.Rules{Enum: []string{"fr", "en"}}, kind: db.List, values: db.EnvsValue{"_dev.": []string{"en", "fr"}}},
+		{rules: db.Rules{Enum: []string{"fr", "en"}}, kind: db.List, values: db.EnvsValue{"_dev.": []interface{}{"en", "de"}}, err: db.ErrRule},
+		{rules: db.Rules{Required: []string{"prod"}}, kind: db.String, values: db.EnvsValue{"_dev.": "", "_prod.": "rv"}},
+		{rules: db.Rules{Required: []string{"prod"}}, kind: db.String, values: db.EnvsValue{"_dev.fr": "rv", "_prod.fr": ""}, err: db.ErrRule},
+		{rules: db.Rules{Required: []string{"fr"}}, kind: db.List, values: db.EnvsValue{"_dev.fr": []string{}}, err: db.ErrRule},
+		{rules: db.Rules{Required: []string{"fr"}}, kind: db.Int, values: db.EnvsValue{"_dev.fr": nil}, err: db.ErrRule},
+	}
+	for i, tt := range dt {
+		if err := tt.rules.Check(tt.kind, tt.values); errors.Cause(err) != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		}
+	}
+}
+
+func TestVarSetValuesWithRules(t *testing.T) {
+	v := db.NewVar("i", db.Int.Int())
+	v.Rules.Max = new(float64)
+	if err := v.SetValues(map[string]string{"_dev.": "1"}); errors.Cause(err) != db.ErrRule {
+		t.Fatalf("error mismatch: exp=%v got=%v", db.ErrRule, err)
+	}
+	if v.Values != nil {
+		t.Errorf("content mismatch: got=%v", v.Values)
+	}
+	if err := v.SetValues(map[string]string{"_dev.": "-1"}); err != nil {
+		t.Errorf("unexpected error: got=%q", err)
+	}
+}
@@ -6,6 +6,7 @@ package db
 
 import (
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -43,6 +44,23 @@ func uniqueness(s []string) []string {
 	return r
 }
 
+// Returns the cleaned tags, without empty or duplicate one, sorted.
+func tags(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	exists := map[string]bool{}
+	r := []string{}
+	for _, v := range s {
+		if v = clean(v); v != "" && !exists[v] {
+			exists[v] = true
+			r = append(r, v)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
 // Creates a map using string value in the slice as key.
 // Useful to check after if a value exists in the slice.
 func toMap(s []string) (m map[string]struct{}) {
@@ -5,11 +5,17 @@
 package db
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rvflash/eve/deploy"
+	"github.com/rvflash/eve/keyring"
 )
 
 // List of value's kind.
@@ -24,13 +30,22 @@ const (
 	// Non-numeric values
 	Bool
 	String
+
+	// Encrypted values
+	Secret
+
+	// Validated strings
+	Duration
+	JSON
+	List
+	URL
 )
 
 // Kind specifies the kind of value.
 type Kind int
 
 // Kinds returns the list of available kinds.
-var Kinds = []Kind{Int, Float, Bool, String}
+var Kinds = []Kind{Int, Float, Bool, String, Secret, Duration, JSON, List, URL}
 
 // NewKind returns an instance a Kind.
 func NewKind(kind int) Kind {
@@ -57,19 +72,85 @@ func (k Kind) Assert(value interface{}) (v interface{}, ok bool) {
 		v, ok = value.(float64)
 	case Bool:
 		v, ok = value.(bool)
-	case String:
+	case String, Secret:
 		v, ok = value.(string)
+	case Duration, JSON, URL:
+		var s string
+		if s, ok = value.(string); ok {
+			_, err := k.Parse(s)
+			v, ok = s, err == nil
+		}
+	case List:
+		switch d := value.(type) {
+		case []string:
+			v, ok = d, true
+		case []interface{}:
+			// JSON unmarshal stores the arrays as slice of interface.
+			l := make([]string, len(d))
+			for i := range d {
+				if l[i], ok = d[i].(string); !ok {
+					return nil, false
+				}
+			}
+			v, ok = l, true
+		}
 	}
 	return
 }
 
+// Format returns the value as string, as expected by Parse.
+func (k Kind) Format(value interface{}) string {
+	switch d := value.(type) {
+	case nil:
+		return ""
+	case []string:
+		return strings.Join(d, ", ")
+	case []interface{}:
+		if v, ok := k.Assert(d); ok {
+			return k.Format(v)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// Hidden returns true if the values of the kind must not be displayed.
+func (k Kind) Hidden() bool {
+	return k == Secret
+}
+
+// Input returns the type of the HTML input to use to change a value.
+// An URL is a text as it can refer to other variables, like ${HOST}.
+func (k Kind) Input() string {
+	if k == Secret {
+		return "password"
+	}
+	return "text"
+}
+
 // Int gives the value of the kind.
 func (k Kind) Int() int {
 	return int(k)
 }
 
+// Interpolated returns true if the values of the kind can refer to other variables.
+func (k Kind) Interpolated() bool {
+	switch k {
+	case String, Duration, JSON, List, URL:
+		return true
+	}
+	return false
+}
+
 // Parse converts the string to expected value.
+// A duration is normalized, a JSON value compacted, and a list is split on the commas.
 func (k Kind) Parse(s string) (interface{}, error) {
+	switch k {
+	case Duration, JSON, URL:
+		// The references to other variables are validated once resolved.
+		if reference.MatchString(s) {
+			return s, nil
+		}
+	}
 	switch k {
 	case Int:
 		return strconv.Atoi(s)
@@ -77,8 +158,44 @@ func (k Kind) Parse(s string) (interface{}, error) {
 		return strconv.ParseFloat(s, 64)
 	case Bool:
 		return strconv.ParseBool(s)
-	case String:
+	case String, Secret:
 		return s, nil
+	case Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		return d.String(), nil
+	case JSON:
+		s = strings.TrimSpace(s)
+		if !strings.HasPrefix(s, "{") && !strings.HasPrefix(s, "[") {
+			return nil, errors.WithMessage(ErrInvalid, "JSON object or array expected")
+		}
+		buf := new(bytes.Buffer)
+		if err := json.Compact(buf, []byte(s)); err != nil {
+			return nil, err
+		}
+		return buf.String(), nil
+	case List:
+		l := make([]string, 0)
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				l = append(l, v)
+			}
+		}
+		return l, nil
+	case URL:
+		if s == "" {
+			return s, nil
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, errors.WithMessage(ErrInvalid, "absolute URL expected")
+		}
+		return u.String(), nil
 	}
 	return nil, ErrOutOfBounds
 }
@@ -92,6 +209,10 @@ func (k Kind) Pattern() string {
 		return "[0-9]+"
 	case Float:
 		return "[0-9.]+"
+	case Duration:
+		return `-?([0-9.]+(ns|us|µs|ms|s|m|h))+|0`
+	case JSON:
+		return `\s*(\{|\[)[\s\S]*`
 	}
 	return ""
 }
@@ -107,6 +228,16 @@ func (k Kind) String() string {
 		return "Int"
 	case Float:
 		return "Float"
+	case Secret:
+		return "Secret"
+	case Duration:
+		return "Duration"
+	case JSON:
+		return "JSON"
+	case List:
+		return "List"
+	case URL:
+		return "URL"
 	}
 	return "Unknown"
 }
@@ -118,6 +249,10 @@ func (k Kind) ZeroString() string {
 		return "0"
 	case Bool:
 		return "false"
+	case Duration:
+		return "0s"
+	case JSON:
+		return "{}"
 	}
 	return ""
 }
@@ -129,20 +264,34 @@ func (k Kind) ZeroValue() interface{} {
 		return 0
 	case Bool:
 		return false
-	case String:
+	case String, Secret, URL:
 		return ""
+	case Duration:
+		return "0s"
+	case JSON:
+		return "{}"
+	case List:
+		return []string{}
 	}
 	return nil
 }
 
 // Var represents a variable.
 type Var struct {
-	ID           uint64    `json:"id"`
-	Name         string    `json:"name"`
-	Kind         Kind      `json:"kind"`
-	Values       EnvsValue `json:"vals,omitempty"`
-	LastUpdateTs time.Time `json:"upd_ts"`
-	DeletionTs   time.Time `json:"del_ts,omitempty"`
+	ID           uint64      `json:"id"`
+	Name         string      `json:"name"`
+	Kind         Kind        `json:"kind"`
+	Description  string      `json:"desc,omitempty"`
+	Owner        string      `json:"owner,omitempty"`
+	Tags         []string    `json:"tags,omitempty"`
+	Sensitive    bool        `json:"sensitive,omitempty"`
+	Values       EnvsValue   `json:"vals,omitempty"`
+	Default      interface{} `json:"default,omitempty"`
+	Defaults     EnvsValue   `json:"defaults,omitempty"`
+	Rules        Rules       `json:"rules"`
+	LastUpdateTs time.Time   `json:"upd_ts"`
+	Author       string      `json:"author,omitempty"`
+	DeletionTs   time.Time   `json:"del_ts,omitempty"`
 	Partial      bool
 }
 
@@ -164,6 +313,24 @@ func (v *Var) Deleted() bool {
 	return !v.DeletionTs.IsZero()
 }
 
+// Hidden returns true if the values of the variable must not be displayed.
+// It is the case of the secrets and of the variables flagged as sensitive.
+func (v *Var) Hidden() bool {
+	return v.Sensitive || v.Kind.Hidden()
+}
+
+// Tagged returns true if the variable has at least one of these tags.
+func (v *Var) Tagged(tags ...string) bool {
+	for _, t := range tags {
+		for _, d := range v.Tags {
+			if d == clean(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Key returns the key of the variable.
 func (v *Var) Key() []byte {
 	if v.ID == 0 {
@@ -196,103 +363,236 @@ func (v *Var) Valid(insert bool) error {
 	if !insert && v.ID == 0 {
 		return ErrOutOfBounds
 	}
-	return nil
+	v.Description = strings.TrimSpace(v.Description)
+	v.Owner = strings.TrimSpace(v.Owner)
+	v.Tags = tags(v.Tags)
+	return v.Rules.Valid()
 }
 
 // EnvsValue contains all variable's values by given envs.
 // Key combines environment names with a colon.
 type EnvsValue map[string]interface{}
 
+// Value returns the value of the variable for this combination of environments values.
+// Without override, it inherits the default of the first of these environments values
+// having one, then the default of the variable. It returns nil if there is none.
+func (v *Var) Value(id string) interface{} {
+	if d, ok := v.Values[id]; ok {
+		return d
+	}
+	for _, ev := range *NewVarID(id) {
+		if d, ok := v.Defaults[ev]; ok && ev != "" {
+			return d
+		}
+	}
+	return v.Default
+}
+
+// Inherited returns true if the value of this combination of environments values
+// is not overridden.
+func (v *Var) Inherited(id string) bool {
+	_, ok := v.Values[id]
+	return !ok
+}
+
 // NewValues returns a new map of values for the given environments.
 // Each value use the default value of the kind of the variable.
-func (v *Var) NewValues(env1, env2 *Env) EnvsValue {
-	m := make(EnvsValue, len(env1.Values)*len(env2.Values))
-	for _, mv := range env1.Values {
-		for _, sv := range env2.Values {
-			k := VarID{mv, sv}
-			m[k.String()] = v.Kind.ZeroValue()
-		}
+func (v *Var) NewValues(envs ...*Env) EnvsValue {
+	values := make([][]string, len(envs))
+	for i, e := range envs {
+		values[i] = e.Values
+	}
+	all := deploy.Combine(values)
+	m := make(EnvsValue, len(all))
+	for _, c := range all {
+		m[VarID(c).String()] = v.Kind.ZeroValue()
 	}
 	return m
 }
 
+// Inherit is the value given to SetValues for a combination of environments values
+// not overridden, inheriting the defaults of the variable.
+// Unlike it, an empty value overrides them with the zero value of a string.
+const Inherit = "\x00inherit"
+
 // SetValues sets the values of the variable without any check on the environments behind.
+// A value equal to Inherit is not overridden, it inherits the defaults of the variable.
+// It returns an error if one of the resulting values breaks the rules of the variable.
 func (v *Var) SetValues(m map[string]string) (err error) {
 	ev := make(EnvsValue, len(m))
 	for k, d := range m {
+		if d == Inherit {
+			continue
+		}
 		if ev[k], err = v.Kind.Parse(d); err != nil {
 			return errors.WithMessage(err, k)
 		}
 	}
+	w := *v
+	w.Values = ev
+	if err = v.Rules.Check(v.Kind, w.resolve(m)); err != nil {
+		return err
+	}
 	v.Values = ev
 
 	return nil
 }
 
-// CleanValues ensures that all values use the kind of the variable.
+// SetDefaults sets the default value of the variable and the ones by environment value.
+// An empty value is ignored.
+// It returns an error if one of the resulting values breaks the rules of the variable.
+func (v *Var) SetDefaults(def string, m map[string]string) (err error) {
+	var d interface{}
+	if def != "" {
+		if d, err = v.Kind.Parse(def); err != nil {
+			return errors.WithMessage(err, "default")
+		}
+	}
+	ev := make(EnvsValue, len(m))
+	for k, s := range m {
+		if k == "" || s == "" {
+			continue
+		}
+		if ev[k], err = v.Kind.Parse(s); err != nil {
+			return errors.WithMessage(err, k)
+		}
+	}
+	w := *v
+	w.Default, w.Defaults = d, ev
+	// Checks the defaults as the values of their environments.
+	keys := map[string]string{"default": ""}
+	for k := range ev {
+		keys[VarID{k}.String()] = ""
+	}
+	if err = v.Rules.Check(v.Kind, w.resolve(keys)); err != nil {
+		return err
+	}
+	if len(ev) == 0 {
+		ev = nil
+	}
+	v.Default, v.Defaults = d, ev
+
+	return nil
+}
+
+// resolve returns the values of the variable for these combinations of environments values.
+func (v *Var) resolve(ids map[string]string) EnvsValue {
+	res := make(EnvsValue, len(ids))
+	for k := range ids {
+		res[k] = v.Value(k)
+	}
+	return res
+}
+
+// Seal encrypts with the keyring the values and defaults of a secret variable not yet encrypted.
+// It returns an error if there is something to encrypt without keyring.
+func (v *Var) Seal(k *keyring.Keyring) (err error) {
+	if v.Kind != Secret {
+		return nil
+	}
+	seal := func(d interface{}) (interface{}, error) {
+		s, ok := d.(string)
+		if !ok || s == "" || keyring.Sealed(s) {
+			return d, nil
+		}
+		if k == nil {
+			return nil, errors.WithMessage(ErrMissing, "keyring")
+		}
+		return k.Encrypt(s)
+	}
+	var d interface{}
+	for _, values := range []EnvsValue{v.Values, v.Defaults} {
+		for id := range values {
+			if d, err = seal(values[id]); err != nil {
+				return errors.WithMessage(err, id)
+			}
+			values[id] = d
+		}
+	}
+	if d, err = seal(v.Default); err != nil {
+		return errors.WithMessage(err, "default")
+	}
+	v.Default = d
+	return nil
+}
+
+// CleanValues ensures that all values and defaults use the kind of the variable.
 // It also checks that only the current environments values are used.
 // A partial result is returned if one the environment does not exist.
 // It return on error if the kind of value does not match.
-func (v *Var) CleanValues(env1, env2 *Env) error {
+func (v *Var) CleanValues(envs ...*Env) error {
 	v.Partial = false
-	// Transforms the values of envs as map to perform search on it.
-	ev1 := toMap(env1.Values)
-	ev2 := toMap(env2.Values)
-	// Creates a temporary map to keep only the ok values.
-	val := v.NewValues(env1, env2)
-	// Checks each value for all envs.
+	// Lists the combinations and the values of the environments.
+	cells := v.NewValues(envs...)
+	known := make(map[string]struct{})
+	for _, e := range envs {
+		for _, ev := range e.Values {
+			known[ev] = struct{}{}
+		}
+	}
+	// Creates temporary maps to keep only the ok values.
+	val := make(EnvsValue, len(v.Values))
+	def := make(EnvsValue, len(v.Defaults))
 	var ok bool
 	for k, d := range v.Values {
-		vid := NewVarID(k)
-		if _, ok = ev1[vid.EnvValue1]; !ok {
-			// Unknown value in the main environment.
+		vid := NewVarID(k).String()
+		if _, ok = cells[vid]; !ok {
+			// Unknown value in one of the environments.
 			v.Partial = true
 			continue
 		}
-		if _, ok = ev2[vid.EnvValue2]; !ok {
-			// Unknown value in the second environment.
+		if val[vid], ok = v.Kind.Assert(d); !ok {
+			return errors.WithMessage(ErrInvalid, k)
+		}
+	}
+	for k, d := range v.Defaults {
+		if _, ok = known[k]; !ok || k == "" {
 			v.Partial = true
 			continue
 		}
-		if val[vid.String()], ok = v.Kind.Assert(d); !ok {
+		if def[k], ok = v.Kind.Assert(d); !ok {
 			return errors.WithMessage(ErrInvalid, k)
 		}
 	}
-	v.Values = val
+	if v.Default != nil {
+		if v.Default, ok = v.Kind.Assert(v.Default); !ok {
+			return errors.WithMessage(ErrInvalid, "default")
+		}
+	}
+	if len(def) == 0 {
+		def = nil
+	}
+	v.Values, v.Defaults = val, def
 
 	return nil
 }
 
-// envTie is used to join two environment'values.
+// envTie is used to join the environment'values.
 const (
 	VarIDPrefix = "_"
 	VarIDTie    = "."
 )
 
 // VarID is the internal name of the variable for the combination of
-// the environments values.
-type VarID struct {
-	EnvValue1,
-	EnvValue2 string
-}
+// the environments values, in the order of the environments.
+type VarID []string
 
 // NewVarID returns a VarID by parsing its string representation.
+// Like the projects, it has at least two environments, the missing values are empty.
 func NewVarID(s string) *VarID {
-	v := &VarID{}
+	v := make(VarID, minEnvs)
 	if !strings.HasPrefix(s, VarIDPrefix) {
-		return v
+		return &v
 	}
-	d := strings.SplitN(strings.TrimPrefix(s, VarIDPrefix), VarIDTie, 2)
-	switch len(d) {
-	case 2:
-		v.EnvValue1, v.EnvValue2 = d[0], d[1]
-	case 1:
-		v.EnvValue1 = d[0]
+	d := strings.Split(strings.TrimPrefix(s, VarIDPrefix), VarIDTie)
+	if len(d) > minEnvs {
+		v = make(VarID, len(d))
 	}
-	return v
+	copy(v, d)
+	return &v
 }
 
 // String returns the string representation of the VarID.
-func (v *VarID) String() string {
-	return VarIDPrefix + v.EnvValue1 + VarIDTie + v.EnvValue2
+func (v VarID) String() string {
+	return VarIDPrefix + strings.Join(v, VarIDTie)
 }
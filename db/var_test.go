@@ -6,6 +6,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/keyring"
 )
 
 func TestNewKind(t *testing.T) {
@@ -20,6 +21,11 @@ func TestNewKind(t *testing.T) {
 		{i: 2, out: db.Float, id: 2, str: "Float", zstr: "0", regexp: "[0-9.]+", zval: 0},
 		{i: 3, out: db.Bool, id: 3, str: "Bool", zstr: "false", regexp: `\b(true|false)\b`, zval: false},
 		{i: 4, out: db.String, id: 4, str: "String", zstr: "", regexp: "", zval: ""},
+		{i: 5, out: db.Secret, id: 5, str: "Secret", zstr: "", regexp: "", zval: ""},
+		{i: 6, out: db.Duration, id: 6, str: "Duration", zstr: "0s", regexp: `-?([0-9.]+(ns|us|µs|ms|s|m|h))+|0`, zval: "0s"},
+		{i: 7, out: db.JSON, id: 7, str: "JSON", zstr: "{}", regexp: `\s*(\{|\[)[\s\S]*`, zval: "{}"},
+		{i: 8, out: db.List, id: 8, str: "List", zstr: "", regexp: "", zval: []string{}},
+		{i: 9, out: db.URL, id: 9, str: "URL", zstr: "", regexp: "", zval: ""},
 	}
 	for i, tt := range dt {
 		k := db.NewKind(tt.i)
@@ -35,7 +41,7 @@ func TestNewKind(t *testing.T) {
 		if v := k.ZeroString(); tt.zstr != v {
 			t.Errorf("%d. %q kind name mismatch: exp=%q got=%q", i, tt.i, tt.zstr, v)
 		}
-		if v := k.ZeroValue(); tt.zval != v {
+		if v := k.ZeroValue(); !reflect.DeepEqual(tt.zval, v) {
 			t.Errorf("%d. %q kind name mismatch: exp=%q got=%q", i, tt.i, tt.zval, v)
 		}
 		if v := k.Pattern(); tt.regexp != v {
@@ -58,11 +64,22 @@ func TestKindAssert(t *testing.T) {
 		{db.Int, 1, 1, true},
 		{db.Float, 3.14, 3.14, true},
 		{db.Bool, true, true, true},
+		{db.Secret, "rv", "rv", true},
+		{db.Duration, "1m30s", "1m30s", true},
+		{db.JSON, `{"a":1}`, `{"a":1}`, true},
+		{db.List, []interface{}{"a", "b"}, []string{"a", "b"}, true},
+		{db.List, []string{"a"}, []string{"a"}, true},
+		{db.URL, "http://sh01.prod", "http://sh01.prod", true},
+		// ko
+		{db.Duration, "1 minute", "1 minute", false},
+		{db.JSON, "rv", "rv", false},
+		{db.List, []interface{}{"a", 1}, nil, false},
+		{db.URL, "/path", "/path", false},
 	}
 	for i, tt := range dt {
 		if out, ok := tt.on.Assert(tt.in); ok != tt.ok {
 			t.Errorf("%d. kind mismatch: exp=%t got=%t", i, tt.ok, ok)
-		} else if out != tt.out {
+		} else if !reflect.DeepEqual(out, tt.out) {
 			t.Errorf("%d. result mismatch: exp=%v got=%v", i, tt.out, out)
 		}
 	}
@@ -95,6 +112,36 @@ func TestKindParse(t *testing.T) {
 			in:  "false",
 			out: "false",
 		},
+		{
+			on:  db.NewVar("d", db.Duration.Int()),
+			in:  "90s",
+			out: "1m30s",
+		},
+		{
+			on:  db.NewVar("j", db.JSON.Int()),
+			in:  ` [ 1, {"a": true} ] `,
+			out: `[1,{"a":true}]`,
+		},
+		{
+			on:  db.NewVar("u", db.URL.Int()),
+			in:  "postgres://${DB_USER}@${DB_HOST}:${DB_PORT}/app",
+			out: "postgres://${DB_USER}@${DB_HOST}:${DB_PORT}/app",
+		},
+		{
+			on:  db.NewVar("l", db.List.Int()),
+			in:  "a, b,,c ",
+			out: []string{"a", "b", "c"},
+		},
+		{
+			on:  db.NewVar("l", db.List.Int()),
+			in:  "",
+			out: []string{},
+		},
+		{
+			on:  db.NewVar("url", db.URL.Int()),
+			in:  "https://sh01.prod:8080/v1?q=rv",
+			out: "https://sh01.prod:8080/v1?q=rv",
+		},
 		{
 			on:  db.NewVar("u", db.Unknown.Int()),
 			in:  "false",
@@ -113,6 +160,42 @@ func TestKindParse(t *testing.T) {
 	}
 }
 
+func TestKindParseInvalid(t *testing.T) {
+	var dt = []struct {
+		on db.Kind
+		in string
+	}{
+		{on: db.Duration, in: "1 minute"},
+		{on: db.JSON, in: `"rv"`},
+		{on: db.JSON, in: `{"a":}`},
+		{on: db.URL, in: "sh01.prod"},
+		{on: db.URL, in: "http://%zz"},
+	}
+	for i, tt := range dt {
+		if _, err := tt.on.Parse(tt.in); err == nil {
+			t.Errorf("%d. expected error with %q", i, tt.in)
+		}
+	}
+}
+
+func TestKindFormat(t *testing.T) {
+	var dt = []struct {
+		on  db.Kind
+		in  interface{}
+		out string
+	}{
+		{on: db.String, in: nil, out: ""},
+		{on: db.Int, in: 42, out: "42"},
+		{on: db.List, in: []string{"a", "b"}, out: "a, b"},
+		{on: db.List, in: []interface{}{"a", "b"}, out: "a, b"},
+	}
+	for i, tt := range dt {
+		if out := tt.on.Format(tt.in); out != tt.out {
+			t.Errorf("%d. content mismatch: exp=%q got=%q", i, tt.out, out)
+		}
+	}
+}
+
 func TestNewVarID(t *testing.T) {
 	var dt = []struct {
 		in  string
@@ -124,7 +207,8 @@ func TestNewVarID(t *testing.T) {
 		{db.VarIDPrefix + db.VarIDTie, db.NewVarID("")},
 		{db.VarIDPrefix + "" + db.VarIDTie + "", db.NewVarID("")},
 		{db.VarIDPrefix + "r" + db.VarIDTie + "v", &db.VarID{"r", "v"}},
-		{db.VarIDPrefix + "r" + db.VarIDTie + "v" + db.VarIDTie, &db.VarID{"r", "v" + db.VarIDTie}},
+		{db.VarIDPrefix + "r", &db.VarID{"r", ""}},
+		{db.VarIDPrefix + "r" + db.VarIDTie + "v" + db.VarIDTie + "c", &db.VarID{"r", "v", "c"}},
 	}
 	for i, tt := range dt {
 		if out := db.NewVarID(tt.in); !reflect.DeepEqual(out, tt.out) {
@@ -172,6 +256,21 @@ func TestVarSetValues(t *testing.T) {
 			in:  map[string]string{"name": "true"},
 			out: db.EnvsValue{"name": "true"},
 		},
+		{
+			on:  db.NewVar("i", db.Int.Int()),
+			in:  map[string]string{"name": db.Inherit, "name1": "1"},
+			out: db.EnvsValue{"name1": 1},
+		},
+		{
+			on:  db.NewVar("s", db.String.Int()),
+			in:  map[string]string{"name": "", "name1": db.Inherit},
+			out: db.EnvsValue{"name": ""},
+		},
+		{
+			on:  db.NewVar("i", db.Int.Int()),
+			in:  map[string]string{"name": ""},
+			err: errors.New(`name: strconv.Atoi: parsing "": invalid syntax`),
+		},
 	}
 	for i, tt := range dt {
 		if err := tt.on.SetValues(tt.in); err != nil {
@@ -188,6 +287,171 @@ func TestVarSetValues(t *testing.T) {
 	}
 }
 
+func TestReferences(t *testing.T) {
+	var dt = []struct {
+		in  string
+		out []string
+	}{
+		{in: ""},
+		{in: "$DB_HOST {DB_HOST} ${DB HOST}"},
+		{in: "${DB_PORT}", out: []string{"DB_PORT"}},
+		{in: "postgres://${DB_USER}@${DB_HOST}:${DB_PORT}/${DB_USER}", out: []string{"DB_HOST", "DB_PORT", "DB_USER"}},
+	}
+	for i, tt := range dt {
+		if out := db.References(tt.in); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. content mismatch: exp=%q got=%q", i, tt.out, out)
+		}
+	}
+}
+
+func TestVarValue(t *testing.T) {
+	v := db.NewVar("port", db.Int.Int())
+	if d := v.Value("_dev.fr"); d != nil {
+		t.Fatalf("content mismatch: exp=nil got=%v", d)
+	}
+	v.Default = 80
+	v.Defaults = db.EnvsValue{"prod": 443, "fr": 8080}
+	v.Values = db.EnvsValue{"_prod.fr": 8443}
+	var dt = []struct {
+		id        string
+		out       interface{}
+		inherited bool
+	}{
+		{id: "_prod.fr", out: 8443},
+		{id: "_prod.en", out: 443, inherited: true},
+		{id: "_dev.fr", out: 8080, inherited: true},
+		{id: "_dev.en", out: 80, inherited: true},
+		{id: "_.", out: 80, inherited: true},
+	}
+	for i, tt := range dt {
+		if d := v.Value(tt.id); d != tt.out {
+			t.Errorf("%d. content mismatch: exp=%v got=%v", i, tt.out, d)
+		}
+		if ok := v.Inherited(tt.id); ok != tt.inherited {
+			t.Errorf("%d. inheritance mismatch: exp=%t got=%t", i, tt.inherited, ok)
+		}
+	}
+}
+
+func TestVarSetDefaults(t *testing.T) {
+	min := 10.
+	var dt = []struct {
+		def  string
+		in   map[string]string
+		out  interface{}
+		outs db.EnvsValue
+		err  error
+	}{
+		{def: "ten", err: errors.New(`default: strconv.Atoi: parsing "ten": invalid syntax`)},
+		{in: map[string]string{"prod": "ten"}, err: errors.New(`prod: strconv.Atoi: parsing "ten": invalid syntax`)},
+		{def: "8", err: errors.New("default: less than 10: broken rule")},
+		{def: "80", in: map[string]string{"prod": "8"}, err: errors.New("_prod: less than 10: broken rule")},
+		{},
+		{def: "80", in: map[string]string{"prod": "443", "dev": ""}, out: 80, outs: db.EnvsValue{"prod": 443}},
+	}
+	for i, tt := range dt {
+		v := db.NewVar("port", db.Int.Int())
+		v.Rules.Min = &min
+		if err := v.SetDefaults(tt.def, tt.in); err != nil {
+			if tt.err == nil {
+				t.Errorf("%d. expected no error: got=%q", i, err)
+			} else if err.Error() != tt.err.Error() {
+				t.Errorf("%d. error mismatch: exp=%q got=%q", i, tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("%d. expected error: exp=%q got=%q", i, tt.err, err)
+		} else if tt.out != v.Default || !reflect.DeepEqual(tt.outs, v.Defaults) {
+			t.Errorf("%d. content mismatch: exp=%v %v got=%v %v", i, tt.out, tt.outs, v.Default, v.Defaults)
+		}
+	}
+}
+
+func TestVarSeal(t *testing.T) {
+	k, err := keyring.New("k1", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	sealed, _ := k.Encrypt("hg")
+	v := db.NewVar("s", db.Secret.Int())
+	v.Values = db.EnvsValue{"a": "rv", "b": sealed, "c": ""}
+	v.Default = "eve"
+	if err := v.Seal(nil); errors.Cause(err) != db.ErrMissing {
+		t.Fatalf("error mismatch: exp=%q got=%q", db.ErrMissing, err)
+	}
+	if err := v.Seal(k); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	var dt = []struct {
+		id, plain string
+	}{
+		{id: "a", plain: "rv"},
+		{id: "b", plain: "hg"},
+	}
+	for i, tt := range dt {
+		s := v.Values[tt.id].(string)
+		if plain, err := k.Decrypt(s); err != nil {
+			t.Errorf("%d. unexpected error: got=%q", i, err)
+		} else if plain != tt.plain {
+			t.Errorf("%d. content mismatch: exp=%q got=%q", i, tt.plain, plain)
+		}
+	}
+	if plain, err := k.Decrypt(v.Default.(string)); err != nil || plain != "eve" {
+		t.Errorf("default mismatch: exp=%q got=%q (%v)", "eve", plain, err)
+	}
+	if v.Values["b"] != sealed || v.Values["c"] != "" {
+		t.Errorf("content mismatch: got=%q", v.Values)
+	}
+}
+
+func TestVarTags(t *testing.T) {
+	v := db.NewVar("flag", db.Bool.Int())
+	v.Description = " Enables the new home page. "
+	v.Tags = []string{"Feature Flag", "", "home", "feature-flag"}
+	if err := v.Valid(true); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if v.Description != "Enables the new home page." {
+		t.Errorf("description mismatch: got=%q", v.Description)
+	}
+	if exp := []string{"feature-flag", "home"}; !reflect.DeepEqual(v.Tags, exp) {
+		t.Fatalf("tags mismatch: exp=%q got=%q", exp, v.Tags)
+	}
+	var dt = []struct {
+		in []string
+		ok bool
+	}{
+		{in: nil},
+		{in: []string{"api"}},
+		{in: []string{"home"}, ok: true},
+		{in: []string{"api", "Feature flag"}, ok: true},
+	}
+	for i, tt := range dt {
+		if ok := v.Tagged(tt.in...); ok != tt.ok {
+			t.Errorf("%d. tagged mismatch: exp=%t got=%t", i, tt.ok, ok)
+		}
+	}
+}
+
+func TestVarHidden(t *testing.T) {
+	var dt = []struct {
+		kind      db.Kind
+		sensitive bool
+		ok        bool
+	}{
+		{kind: db.String},
+		{kind: db.String, sensitive: true, ok: true},
+		{kind: db.Int, sensitive: true, ok: true},
+		{kind: db.Secret, ok: true},
+	}
+	for i, tt := range dt {
+		v := db.NewVar("v", tt.kind.Int())
+		v.Sensitive = tt.sensitive
+		if ok := v.Hidden(); ok != tt.ok {
+			t.Errorf("%d. hidden mismatch: exp=%t got=%t", i, tt.ok, ok)
+		}
+	}
+}
+
 func TestVarCleanValues(t *testing.T) {
 	var dt = []struct {
 		on      *db.Var
@@ -207,13 +471,13 @@ func TestVarCleanValues(t *testing.T) {
 		{
 			on:      &db.Var{Name: "b", Kind: db.Bool, Values: db.EnvsValue{"_r.": false}},
 			with:    [2]*db.Env{db.DefaultEnv, db.DefaultEnv},
-			out:     db.EnvsValue{"_.": false},
+			out:     db.EnvsValue{},
 			partial: true,
 		},
 		{
 			on:      &db.Var{Name: "b", Kind: db.Bool, Values: db.EnvsValue{"_.v": false}},
 			with:    [2]*db.Env{db.DefaultEnv, db.DefaultEnv},
-			out:     db.EnvsValue{"_.": false},
+			out:     db.EnvsValue{},
 			partial: true,
 		},
 		{
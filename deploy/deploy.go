@@ -5,8 +5,10 @@
 package deploy
 
 import (
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -15,8 +17,11 @@ import (
 
 // Error message.
 var (
-	ErrInvalid = errors.New("invalid data")
-	ErrMissing = errors.New("nothing to deploy")
+	ErrInvalid  = errors.New("invalid data")
+	ErrMissing  = errors.New("nothing to deploy")
+	ErrExpiry   = errors.New("time to live not supported")
+	ErrMismatch = errors.New("values not matching after the push")
+	ErrStage    = errors.New("no canary release waiting")
 )
 
 // Key returns the name of the variable used as key in the cache.
@@ -33,6 +38,23 @@ func Key(parts ...string) (k string) {
 	return strings.ToUpper(k)
 }
 
+// Combine returns all the combinations of the values of these environments.
+// In each combination, the values follow the order of the environments.
+// If one of the environments has no value, there is no combination.
+func Combine(envs [][]string) [][]string {
+	all := [][]string{{}}
+	for _, values := range envs {
+		next := make([][]string, 0, len(all)*len(values))
+		for _, c := range all {
+			for _, v := range values {
+				next = append(next, append(append([]string{}, c...), v))
+			}
+		}
+		all = next
+	}
+	return all
+}
+
 // Task maintains counter of change.
 type Task struct {
 	Add, Del, Upd, NoOp uint64
@@ -79,6 +101,11 @@ func (s *devNull) Bulk(data map[string]interface{}) error {
 	return nil
 }
 
+// BulkWithTTL implements the Expirer interface.
+func (s *devNull) BulkWithTTL(data map[string]interface{}, ttl time.Duration) error {
+	return nil
+}
+
 // Lookup implements the Dest interface.
 func (s *devNull) Lookup(key string) (interface{}, bool) {
 	return nil, false
@@ -93,24 +120,52 @@ type Dest interface {
 	Lookup(key string) (interface{}, bool)
 }
 
+// Expirer must be implemented by any Dest able to deploy data with a time to live.
+type Expirer interface {
+	BulkWithTTL(data map[string]interface{}, ttl time.Duration) error
+}
+
 // Source must be implemented by any source want to be deployed.
 // Key returns the identifier of the project.
-// EnvsValues returns the values of each environments behind the project.
+// EnvsValues returns the values of each environments behind the project, in their order.
 // ToDeploy gives the list of variable to deploy, with their deploy's name as key.
 type Source interface {
 	Key() []byte
-	EnvsValues() (firstEnvValues, secondEnvValues []string)
-	ToDeploy(firstEnvValues, secondEnvValues []string) map[string]interface{}
+	EnvsValues() [][]string
+	ToDeploy(envsValues [][]string) map[string]interface{}
+}
+
+// Validator must be implemented by any Source able to check its data
+// before their deployment for these environments.
+// Names limits the check to these variables, all of them if empty.
+type Validator interface {
+	Validate(envsValues [][]string, names ...string) error
 }
 
 // Release represents a new deployment.
 type Release struct {
 	ref           Source
 	to            []Dest
-	env1, env2    []string
+	envs          [][]string
 	src, dst, dep map[string]interface{}
+	dsts          []map[string]interface{}
 	task          *Task
+	ttl           time.Duration
+	verify        bool
+	retries       int
+	delay         time.Duration
+	canary        map[int]bool
+	staged        bool
 	err           error
+	res           []*Result
+}
+
+// Result is the result of the push on one server.
+// Latency is the duration of its last attempt.
+type Result struct {
+	Err      error
+	Latency  time.Duration
+	Attempts int
 }
 
 // New returns a new Release.
@@ -138,9 +193,9 @@ func (d *Release) Replicate() int {
 	return size
 }
 
-// Checkout allows to define until 2 environments.
-// The adding's order is important, the first must be
-// the first environment defined in the EVE's project.
+// Checkout defines the values of the environments to deploy.
+// The adding's order is important, it must be the one of the environments
+// defined in the EVE's project. The environments without value to choose can be omitted.
 // It returns an error if the number of environment is unexpected.
 func (d *Release) Checkout(envs ...[]string) error {
 	// Checks if the values of the source slice matched with those in the reference.
@@ -157,7 +212,7 @@ func (d *Release) Checkout(envs ...[]string) error {
 		return true
 	}
 	// Gets the environments values of the project.
-	env1, env2 := d.ref.EnvsValues()
+	ref := d.ref.EnvsValues()
 	size := func(envs [][]string) int {
 		var l int
 		for _, env := range envs {
@@ -168,40 +223,35 @@ func (d *Release) Checkout(envs ...[]string) error {
 		return l
 	}(envs)
 
-	// Calculates the number of environment in entry.
-	sizes := func(first, second []string) int {
-		firstSize, secondSize := len(first), len(second)
-		switch {
-		case firstSize == 0, secondSize == 0:
-			return 0
-		case first[0] == "" && second[0] == "":
-			return 0
-		case first[0] != "" && second[0] == "":
-			return 1
-		}
-		return 2
-	}(env1, env2)
-
-	switch {
-	case size < sizes || size > 2:
+	// Calculates the number of environments with values to choose,
+	// until the last one without the only default value.
+	required := func(envs [][]string) (l int) {
+		for i, env := range envs {
+			switch {
+			case len(env) == 0:
+				return 0
+			case len(env) > 1, env[0] != "":
+				l = i + 1
+			}
+		}
+		return
+	}(ref)
+
+	if size < required || size > len(ref) {
 		return ErrInvalid
-	case size == 2:
-		if !in(envs[1], env2) {
-			return ErrInvalid
+	}
+	values := make([][]string, len(ref))
+	for i := range ref {
+		if i >= size {
+			values[i] = []string{""}
+			continue
 		}
-		d.env2 = envs[1]
-		fallthrough
-	case size == 1:
-		if !in(envs[0], env1) {
+		if !in(envs[i], ref[i]) {
 			return ErrInvalid
 		}
-		d.env1 = envs[0]
-		if size == 1 {
-			d.env2 = []string{""}
-		}
-	case size == 0:
-		d.env1, d.env2 = []string{""}, []string{""}
+		values[i] = envs[i]
 	}
+	d.envs = values
 	return nil
 }
 
@@ -223,12 +273,10 @@ func (d *Release) Diff() map[string]*Changes {
 	}
 	// Builds the list of available prefixes of deploy keys
 	// with project name and env values.
+	pid := string(d.ref.Key())
 	prefix := make([]string, 0)
-	for _, ev1 := range d.env1 {
-		for _, ev2 := range d.env2 {
-			pid := string(d.ref.Key())
-			prefix = append(prefix, Key(pid, ev1, ev2)+"_")
-		}
+	for _, c := range Combine(d.envs) {
+		prefix = append(prefix, Key(append([]string{pid}, c...)...)+"_")
 	}
 	// Returns only the name of the variable from the deploy key.
 	name := func(s string) string {
@@ -251,6 +299,40 @@ func (d *Release) Diff() map[string]*Changes {
 	return c
 }
 
+// Changed returns the sorted names of the variables whose changes differ
+// between two results of Diff, like a release and the same one computed again later.
+// The values are compared as displayed, a result of Diff may have been read back from a database.
+func Changed(before, after map[string]*Changes) []string {
+	shown := func(a, b interface{}) bool {
+		return equal(a, b) || a != nil && b != nil && Stringify(a) == Stringify(b)
+	}
+	same := func(a, b *Changes) bool {
+		if a == nil || b == nil || len(a.Log) != len(b.Log) {
+			return false
+		}
+		for k, v := range a.Log {
+			w, ok := b.Log[k]
+			if !ok || !shown(v[0], w[0]) || !shown(v[1], w[1]) {
+				return false
+			}
+		}
+		return true
+	}
+	var names []string
+	for n, c := range before {
+		if !same(c, after[n]) {
+			names = append(names, n)
+		}
+	}
+	for n := range after {
+		if _, ok := before[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Log shows the push's logs.
 // For each key, it returns the value before and after the push.
 func (d *Release) Log() map[string][2]interface{} {
@@ -286,94 +368,393 @@ func change(before, after interface{}) [2]interface{} {
 // FirstEnvValues returns the values of the first environment
 // used to checkout the release.
 func (d *Release) FirstEnvValues() []string {
-	return d.env1
+	return d.envValues(0)
 }
 
 // SecondEnvValues returns the values of the second environment
 // used to checkout the release.
 func (d *Release) SecondEnvValues() []string {
-	return d.env2
+	return d.envValues(1)
+}
+
+// EnvsValues returns the values of each environment used to checkout the release.
+func (d *Release) EnvsValues() [][]string {
+	return d.envs
+}
+
+// Rows returns the combinations of the values of the environments after the first one.
+// With the values of the first environment as columns, they allow to display the values as a table.
+func (d *Release) Rows() [][]string {
+	if len(d.envs) == 0 {
+		return nil
+	}
+	return Combine(d.envs[1:])
+}
+
+func (d *Release) envValues(i int) []string {
+	if i < len(d.envs) {
+		return d.envs[i]
+	}
+	return nil
+}
+
+// Expire sets the time to live of the data to push.
+// Once expired, the data disappears by itself of the cache servers.
+// A zero duration deploys data without expiration.
+func (d *Release) Expire(ttl time.Duration) {
+	d.ttl = ttl
+}
+
+// TTL returns the time to live of the data to push, 0 if they never expire.
+func (d *Release) TTL() time.Duration {
+	return d.ttl
 }
 
 // Push uploads via RPC to the cache servers all the required data
 // in one bulk.
 // It can take as parameter the exclusive list of variable's names to push.
 // This list do not have the project ID or envs names as components.
+// With a time to live, all the servers must implement the Expirer interface.
+// If the source implements the Validator interface, its data are checked before.
 // It returns on error if the process fails.
 func (d *Release) Push(only ...string) error {
+	if v, ok := d.ref.(Validator); ok {
+		if d.err = v.Validate(d.envs, only...); d.err != nil {
+			return d.err
+		}
+	}
 	if _ = d.merge(); len(d.src) == 0 {
 		return ErrMissing
 	}
 	if d.rebase(only); len(d.src) == 0 {
 		return ErrMissing
 	}
+	if d.ttl > 0 {
+		for _, server := range d.to {
+			if _, ok := server.(Expirer); !ok {
+				return ErrExpiry
+			}
+		}
+	}
+	d.res = make([]*Result, len(d.to))
+	if len(d.canary) == 0 {
+		d.err = d.send(d.src, d.ttl, d.nodes(false, true))
+		return d.err
+	}
+	// Only the canary servers are updated, the others wait the promotion.
+	if d.err = d.send(d.src, d.ttl, d.nodes(true, false)); d.err == nil {
+		d.staged = true
+	}
+	return d.err
+}
+
+// Canary limits the push to the servers at these positions, in the order of their adding.
+// The other servers are updated once the release promoted.
+func (d *Release) Canary(nodes ...int) {
+	d.canary = make(map[int]bool)
+	for _, i := range nodes {
+		if i >= 0 && i < len(d.to) {
+			d.canary[i] = true
+		}
+	}
+}
+
+// CanaryPct limits the push to this percentage of the servers, at least one of them
+// and never all of them. The first servers in the order of their adding are chosen.
+func (d *Release) CanaryPct(pct int) {
+	var real []int
+	for i, dest := range d.to {
+		if _, ok := dest.(*devNull); !ok {
+			real = append(real, i)
+		}
+	}
+	if pct <= 0 || pct >= 100 || len(real) < 2 {
+		d.canary = nil
+		return
+	}
+	n := (len(real)*pct + 99) / 100
+	if n == len(real) {
+		n--
+	}
+	d.Canary(real[:n]...)
+}
+
+// Staged returns true if the release has been pushed on its canary servers
+// and waits to be promoted or aborted.
+func (d *Release) Staged() bool {
+	return d.staged
+}
+
+// Promote pushes the release on the servers not updated by the canary push.
+func (d *Release) Promote() error {
+	if !d.staged {
+		return ErrStage
+	}
+	d.staged = false
+	d.err = d.send(d.src, d.ttl, d.nodes(false, false))
+	return d.err
+}
+
+// Abort restores on the canary servers the values that they had before the push.
+// The other servers are never updated.
+func (d *Release) Abort() error {
+	if !d.staged {
+		return ErrStage
+	}
+	d.staged = false
 	var g errgroup.Group
-	for _, server := range d.to {
-		c := server
+	for i := range d.canary {
+		i, prev := i, make(map[string]interface{}, len(d.src))
+		for k := range d.src {
+			// A nil value deletes the ones unknown before the push.
+			prev[k] = d.dsts[i][k]
+		}
 		g.Go(func() error {
-			return c.Bulk(d.src)
+			d.res[i] = d.sendTo(d.to[i], prev, 0)
+			return d.res[i].Err
 		})
 	}
 	d.err = g.Wait()
 	return d.err
 }
 
+// Before returns the values on the server at this position before the push, of the data deployed.
+// The unknown ones are nil.
+func (d *Release) Before(i int) map[string]interface{} {
+	if d.res == nil || i < 0 || i >= len(d.dsts) {
+		return nil
+	}
+	prev := make(map[string]interface{}, len(d.src))
+	for k := range d.src {
+		prev[k] = d.dsts[i][k]
+	}
+	return prev
+}
+
+// Resume stages again a release already pushed on its canary servers, like after a restart,
+// in order to promote or abort it. It must be checked out first.
+// Before gives, by position of the canary servers, their values before the push.
+func (d *Release) Resume(before map[int]map[string]interface{}) error {
+	nodes := make([]int, 0, len(before))
+	for i := range before {
+		nodes = append(nodes, i)
+	}
+	if d.Canary(nodes...); len(d.canary) == 0 {
+		return ErrStage
+	}
+	// All the data of the source have already been pushed on the canary servers.
+	if _ = d.merge(); len(d.dsts) > 0 {
+		d.src = d.ref.ToDeploy(d.envs)
+	}
+	if len(d.src) == 0 {
+		return ErrMissing
+	}
+	for i := range d.canary {
+		for k := range d.src {
+			d.dsts[i][k] = before[i][k]
+		}
+	}
+	d.res = make([]*Result, len(d.to))
+	d.staged = true
+	return nil
+}
+
+// IsCanary returns true if the server at this position is a canary one.
+func (d *Release) IsCanary(i int) bool {
+	return d.canary[i]
+}
+
+// nodes returns the positions of the canary servers or the other ones.
+// With all, it returns all of them.
+func (d *Release) nodes(canary, all bool) []int {
+	var res []int
+	for i := range d.to {
+		if all || d.canary[i] == canary {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// send pushes the data on the servers at these positions in parallel.
+// It returns the first error.
+func (d *Release) send(data map[string]interface{}, ttl time.Duration, nodes []int) error {
+	var g errgroup.Group
+	for _, i := range nodes {
+		i := i
+		g.Go(func() error {
+			d.res[i] = d.sendTo(d.to[i], data, ttl)
+			return d.res[i].Err
+		})
+	}
+	return g.Wait()
+}
+
+// sendTo pushes the data on the server, verifies them if required,
+// and tries again on failure as many times as allowed.
+func (d *Release) sendTo(c Dest, data map[string]interface{}, ttl time.Duration) *Result {
+	res := &Result{}
+	for {
+		start := time.Now()
+		if ttl > 0 {
+			res.Err = c.(Expirer).BulkWithTTL(data, ttl)
+		} else {
+			res.Err = c.Bulk(data)
+		}
+		if res.Err == nil && d.verify {
+			res.Err = check(c, data)
+		}
+		res.Latency = time.Since(start)
+		if res.Attempts++; res.Err == nil || res.Attempts > d.retries {
+			return res
+		}
+		time.Sleep(d.delay)
+	}
+}
+
+// check reads back the pushed data on the server.
+// It returns an error with the keys of the values not matching.
+func check(c Dest, data map[string]interface{}) error {
+	if _, ok := c.(*devNull); ok {
+		return nil
+	}
+	var keys []string
+	for k, v := range data {
+		cv, found := c.Lookup(k)
+		if v == nil && found || v != nil && (!found || !equal(cv, v)) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	return errors.WithMessage(ErrMismatch, strings.Join(keys, ", "))
+}
+
+// Verify enables the reading back of the pushed data on each server.
+// A push on a server whose values do not match fails.
+func (d *Release) Verify() {
+	d.verify = true
+}
+
+// Retry sets the number of new attempts of the push on a server after a failure,
+// with the delay to wait between them.
+func (d *Release) Retry(n int, delay time.Duration) {
+	d.retries, d.delay = n, delay
+}
+
+// Items returns the data sent to the servers by the push, with their deploy's name as key.
+func (d *Release) Items() map[string]interface{} {
+	if d.res == nil {
+		return nil
+	}
+	return d.src
+}
+
+// Results returns the result of the push on each server, in the order of their adding.
+// It returns nil if nothing has been sent, and a nil result for a server waiting the promotion.
+func (d *Release) Results() []*Result {
+	return d.res
+}
+
+// NodeDiff returns for the server at this position, in the order of their adding,
+// the value before and after the push of each data to deploy that differs on it.
+func (d *Release) NodeDiff(i int) map[string][2]interface{} {
+	if _ = d.merge(); i < 0 || i >= len(d.dsts) {
+		return nil
+	}
+	log := make(map[string][2]interface{})
+	for k, v := range d.src {
+		if d.differs(i, k, v) {
+			log[k] = change(d.dsts[i][k], v)
+		}
+	}
+	return log
+}
+
+// Dests returns the servers of the release, in the order of their adding.
+func (d *Release) Dests() []Dest {
+	return d.to
+}
+
 // Status gives various counters about the tasks to do to deploy it.
 func (d *Release) Status() *Task {
 	_ = d.merge()
 	return d.task
 }
 
-// Gets from cache the data with same key that the data to deploy.
+// Gets from each cache server the data with same key that the data to deploy.
 // Unknown keys in cache are ignored.
-func (d *Release) fetch() map[string]interface{} {
+func (d *Release) fetch() []map[string]interface{} {
 	var gap = struct {
-		data map[string]interface{}
+		data []map[string]interface{}
 		mu   sync.Mutex
 	}{
-		data: make(map[string]interface{}),
+		data: make([]map[string]interface{}, len(d.to)),
 	}
 	var wg sync.WaitGroup
-	for k, v := range d.src {
-		wg.Add(1)
-		go func(k string, _ interface{}) {
-			defer wg.Done()
-			// we arbitrary choose the first server as data reference.
-			cv, found := d.to[0].Lookup(k)
-			if !found {
-				return
-			}
-			gap.mu.Lock()
-			gap.data[k] = cv
-			gap.mu.Unlock()
-		}(k, v)
+	for i, server := range d.to {
+		gap.data[i] = make(map[string]interface{})
+		for k := range d.src {
+			wg.Add(1)
+			go func(i int, c Dest, k string) {
+				defer wg.Done()
+				cv, found := c.Lookup(k)
+				if !found {
+					return
+				}
+				gap.mu.Lock()
+				gap.data[i][k] = cv
+				gap.mu.Unlock()
+			}(i, server, k)
+		}
 	}
 	wg.Wait()
 
 	return gap.data
 }
 
+// differs returns true if the value of the key on the server at this position
+// is not the one to deploy.
+func (d *Release) differs(i int, k string, v interface{}) bool {
+	cv, found := d.dsts[i][k]
+	if !found {
+		return v != nil
+	}
+	return !equal(cv, v)
+}
+
 // Merges local with cached data to keep only differences.
 func (d *Release) merge() map[string]interface{} {
 	if len(d.dep) > 0 {
 		return d.dep
 	}
-	if d.src = d.ref.ToDeploy(d.env1, d.env2); len(d.src) == 0 {
+	if d.src = d.ref.ToDeploy(d.envs); len(d.src) == 0 {
 		// No variable in this project for these environments
 		return nil
 	}
-	d.dst = d.fetch()
+	// The first server is the reference of the values before the push,
+	// but a value must be deployed as soon as one server differs.
+	d.dsts = d.fetch()
+	d.dst = d.dsts[0]
 	d.dep = make(map[string]interface{})
 	for k, sv := range d.src {
 		dv, ok := d.dst[k]
 		switch {
+		case sv == nil && !ok && !d.drift(k, sv):
+			// Nothing to remove, the key is unknown on all the servers.
+			delete(d.src, k)
+		case sv == nil:
+			d.dep[k] = sv
+			d.task.Del++
 		case !ok:
 			d.dep[k] = sv
 			d.task.Add++
-		case sv == nil:
+		case !equal(sv, dv):
 			d.dep[k] = sv
-			d.task.Del++
-		case sv != dv:
+			d.task.Upd++
+		case d.drift(k, sv):
 			d.dep[k] = sv
 			d.task.Upd++
 		default:
@@ -383,19 +764,27 @@ func (d *Release) merge() map[string]interface{} {
 	return d.dep
 }
 
+// drift returns true if one of the other servers has not the value to deploy.
+func (d *Release) drift(k string, v interface{}) bool {
+	for i := 1; i < len(d.dsts); i++ {
+		if d.differs(i, k, v) {
+			return true
+		}
+	}
+	return false
+}
+
 // Limits the scope of the push to these variable's name.
 func (d *Release) rebase(with []string) {
 	if len(with) == 0 {
 		return
 	}
 	// Converts variable names in map of deploy keys.
+	pid := string(d.ref.Key())
 	only := make(map[string]struct{})
 	for _, name := range with {
-		for _, ev1 := range d.env1 {
-			for _, ev2 := range d.env2 {
-				pid := string(d.ref.Key())
-				only[Key(pid, ev1, ev2, name)] = struct{}{}
-			}
+		for _, c := range Combine(d.envs) {
+			only[Key(append(append([]string{pid}, c...), name)...)] = struct{}{}
 		}
 	}
 	// Cleans the source by removing all the data to ignore.
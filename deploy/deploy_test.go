@@ -10,7 +10,10 @@ import (
 	"testing"
 
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/rvflash/eve/client"
 	"github.com/rvflash/eve/deploy"
 	cache "github.com/rvflash/eve/rpc"
@@ -79,41 +82,46 @@ func (s src) Key() []byte {
 }
 
 // EnvsValues implements the deploy.Source interface.
-func (s src) EnvsValues() (firstEnvValues, secondEnvValues []string) {
+func (s src) EnvsValues() [][]string {
 	switch s {
+	case threeEnv:
+		return [][]string{{"dev"}, {"fr", "en"}, {"eu", "us"}}
 	case twoEnv:
-		return []string{"dev"}, []string{"fr", "en"}
+		return [][]string{{"dev"}, {"fr", "en"}}
 	case oneEnv:
-		return []string{"dev"}, []string{""}
+		return [][]string{{"dev"}, {""}}
 	case errEnv:
-		return nil, nil
+		return [][]string{nil, nil}
 
 	}
-	return []string{""}, []string{""}
+	return [][]string{{""}, {""}}
 }
 
 // ToDeploy implements the deploy.Source interface.
-func (s src) ToDeploy(firstEnvValues, secondEnvValues []string) map[string]interface{} {
+func (s src) ToDeploy(envsValues [][]string) map[string]interface{} {
 	switch s {
+	case threeEnv:
+		return map[string]interface{}{"4_DEV_FR_EU_BOOL": true, "4_DEV_FR_US_BOOL": false}
 	case twoEnv:
 		return map[string]interface{}{"2_DEV_FR_BOOL": true, "2_DEV_FR_FLOAT": 3.14}
 	case oneEnv:
 		return map[string]interface{}{"1_DEV_BOOL": true, "1_DEV_FLOAT": 3.14}
 	case noEnv:
-		if len(firstEnvValues) == 0 || len(secondEnvValues) == 0 {
+		if len(deploy.Combine(envsValues)) == 0 {
 			return nil
 		}
-		return map[string]interface{}{"0_BOOL": true, "0_FLOAT": 3.14, "0_STR": nil, "0_INT": 12}
+		return map[string]interface{}{"0_BOOL": true, "0_FLOAT": 3.14, "0_STR": nil, "0_INT": 12, "0_NONE": nil}
 	}
 	return nil
 }
 
-// Sources without, or with one or two environments.
+// Sources without, or with one, two or three environments.
 const (
 	noEnv src = iota
 	oneEnv
 	twoEnv
 	errEnv
+	threeEnv
 )
 
 func ExampleNew() {
@@ -179,6 +187,7 @@ func TestReleaseCheckout(t *testing.T) {
 		{src: twoEnv, dst: rpcClient, err: deploy.ErrInvalid},
 		{src: twoEnv, dst: rpcClient, ev1: []string{"dev"}, err: deploy.ErrInvalid},
 		{src: twoEnv, dst: rpcClient, ev1: []string{"dev"}, ev2: []string{"dev"}, err: deploy.ErrInvalid},
+		{src: threeEnv, dst: rpcClient, ev1: []string{"dev"}, ev2: []string{"fr"}, err: deploy.ErrInvalid},
 	}
 	for i, tt := range dt {
 		r := deploy.New(tt.src, tt.dst)
@@ -232,6 +241,53 @@ func TestReleaseDiff(t *testing.T) {
 	}
 }
 
+// TestChanged tests the comparison of two results of Diff.
+func TestChanged(t *testing.T) {
+	diff := map[string]*deploy.Changes{
+		"BOOL": {Var: "BOOL", Log: map[string][2]interface{}{"0_BOOL": {false, true}}},
+		"INT":  {Var: "INT", Log: map[string][2]interface{}{"0_INT": {nil, 12}}},
+	}
+	var dt = []struct {
+		after map[string]*deploy.Changes
+		out   []string
+	}{
+		{out: []string{"BOOL", "INT"}},
+		{after: diff},
+		{
+			after: map[string]*deploy.Changes{
+				"BOOL": {Var: "BOOL", Log: map[string][2]interface{}{"0_BOOL": {false, true}}},
+				"INT":  {Var: "INT", Log: map[string][2]interface{}{"0_INT": {nil, "12"}}},
+			},
+		},
+		{
+			after: map[string]*deploy.Changes{
+				"BOOL": diff["BOOL"],
+				"INT":  {Var: "INT", Log: map[string][2]interface{}{"0_INT": {nil, 12.}}},
+			},
+		},
+		{
+			after: map[string]*deploy.Changes{
+				"BOOL": diff["BOOL"],
+				"INT":  {Var: "INT", Log: map[string][2]interface{}{"0_INT": {"", 12}}},
+			},
+			out: []string{"INT"},
+		},
+		{
+			after: map[string]*deploy.Changes{
+				"BOOL": {Var: "BOOL", Log: map[string][2]interface{}{"0_BOOL": {true, false}}},
+				"INT":  diff["INT"],
+				"STR":  {Var: "STR", Log: map[string][2]interface{}{"0_STR": {"rv", nil}}},
+			},
+			out: []string{"BOOL", "STR"},
+		},
+	}
+	for i, tt := range dt {
+		if out := deploy.Changed(diff, tt.after); !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("%d. changed mismatch: got=%q exp=%q", i, out, tt.out)
+		}
+	}
+}
+
 // TestRelease_Push tests the Push methods on Release.
 func TestReleasePush(t *testing.T) {
 	var dt = []struct {
@@ -287,6 +343,275 @@ func TestReleasePush(t *testing.T) {
 	}
 }
 
+// TestReleaseResults tests the Results and Items methods.
+func TestReleaseResults(t *testing.T) {
+	r := deploy.New(noEnv, rpcClient, errClient)
+	if err := r.Checkout([]string{""}, []string{""}); err != nil {
+		t.Fatalf("unexpected error=%q", err)
+	}
+	if res, items := r.Results(), r.Items(); res != nil || items != nil {
+		t.Fatalf("results mismatch before push: got=%v %v", res, items)
+	}
+	if err := r.Push("bool"); err != client.ErrFailure {
+		t.Fatalf("error mismatch: got=%q exp=%q", err, client.ErrFailure)
+	}
+	exp := []error{nil, client.ErrFailure}
+	res := r.Results()
+	if len(res) != len(exp) {
+		t.Fatalf("results mismatch: got=%d exp=%d", len(res), len(exp))
+	}
+	for i, err := range exp {
+		if res[i].Err != err || res[i].Attempts != 1 {
+			t.Errorf("%d. result mismatch: got=%v exp=%v", i, res[i], err)
+		}
+	}
+	items := map[string]interface{}{"0_BOOL": true}
+	if out := r.Items(); !reflect.DeepEqual(out, items) {
+		t.Errorf("items mismatch: got=%v exp=%v", out, items)
+	}
+}
+
+// store is the test's cache server, keeping the data pushed.
+// It fails as many times as asked and never stores the lost key.
+type store struct {
+	mu    sync.Mutex
+	data  map[string]interface{}
+	fails int
+	lost  string
+}
+
+// Bulk implements the deploy.Dest interface.
+func (s *store) Bulk(data map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return client.ErrFailure
+	}
+	if s.data == nil {
+		s.data = make(map[string]interface{})
+	}
+	for k, v := range data {
+		switch {
+		case k == s.lost:
+		case v == nil:
+			delete(s.data, k)
+		default:
+			s.data[k] = v
+		}
+	}
+	return nil
+}
+
+// Lookup implements the deploy.Dest interface.
+func (s *store) Lookup(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// TestReleaseVerify tests the Verify and Retry methods on Release.
+func TestReleaseVerify(t *testing.T) {
+	var dt = []struct {
+		dst      *store
+		verify   bool
+		retries  int
+		err      error
+		attempts int
+	}{
+		{dst: &store{}, verify: true, attempts: 1},
+		{dst: &store{fails: 1}, err: client.ErrFailure, attempts: 1},
+		{dst: &store{fails: 2}, retries: 2, attempts: 3},
+		{dst: &store{lost: "0_INT"}, attempts: 1},
+		{dst: &store{lost: "0_INT"}, verify: true, retries: 1, err: deploy.ErrMismatch, attempts: 2},
+	}
+	for i, tt := range dt {
+		r := deploy.New(noEnv, tt.dst)
+		if tt.verify {
+			r.Verify()
+		}
+		r.Retry(tt.retries, time.Millisecond)
+		if err := r.Checkout([]string{""}, []string{""}); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		err := r.Push()
+		if errors.Cause(err) != tt.err {
+			t.Errorf("%d. error mismatch: got=%q exp=%q", i, err, tt.err)
+		}
+		res := r.Results()[0]
+		if errors.Cause(res.Err) != tt.err || res.Attempts != tt.attempts {
+			t.Errorf("%d. result mismatch: got=%v (%d) exp=%v (%d)", i, res.Err, res.Attempts, tt.err, tt.attempts)
+		}
+	}
+}
+
+// TestReleaseNodeDiff tests the diff on each server of the release.
+func TestReleaseNodeDiff(t *testing.T) {
+	node := &store{data: map[string]interface{}{"0_BOOL": true, "0_FLOAT": 3.14}}
+	r := deploy.New(noEnv, rpcClient, node)
+	if err := r.Checkout([]string{""}, []string{""}); err != nil {
+		t.Fatalf("unexpected error=%q", err)
+	}
+	// 0_INT is up to date on the first server but missing on the second one.
+	diff := map[string][2]interface{}{"0_INT": {12, 12}}
+	if out, ok := r.Diff()["INT"]; !ok || !reflect.DeepEqual(out.Log, diff) {
+		t.Errorf("diff mismatch: got=%v exp=%v", out, diff)
+	}
+	task := &deploy.Task{Add: 1, Del: 1, Upd: 2}
+	if out := r.Status(); !reflect.DeepEqual(out, task) {
+		t.Errorf("status mismatch: got=%v exp=%v", out, task)
+	}
+	var dt = []map[string][2]interface{}{
+		{"0_BOOL": {false, true}, "0_FLOAT": {nil, 3.14}, "0_STR": {"rv", nil}},
+		{"0_INT": {nil, 12}},
+		nil,
+	}
+	for i, tt := range dt {
+		if out := r.NodeDiff(i); !reflect.DeepEqual(out, tt) {
+			t.Errorf("%d. node diff mismatch: got=%v exp=%v", i, out, tt)
+		}
+	}
+}
+
+// TestReleaseCanary tests the Canary, Promote and Abort methods on Release.
+func TestReleaseCanary(t *testing.T) {
+	var dt = []struct {
+		pct     int
+		canary  []bool
+		promote bool
+	}{
+		{pct: 0, canary: []bool{false, false, false}},
+		{pct: 100, canary: []bool{false, false, false}},
+		{pct: 10, canary: []bool{true, false, false}, promote: true},
+		{pct: 50, canary: []bool{true, true, false}},
+		{pct: 99, canary: []bool{true, true, false}, promote: true},
+	}
+	for i, tt := range dt {
+		old := map[string]interface{}{"0_BOOL": false, "0_STR": "rv"}
+		nodes := []*store{{data: map[string]interface{}{"0_BOOL": false, "0_STR": "rv"}}, {}, {}}
+		r := deploy.New(noEnv, nodes[0], nodes[1], nodes[2])
+		r.CanaryPct(tt.pct)
+		if err := r.Checkout([]string{""}, []string{""}); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if err := r.Push(); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		staged := tt.canary[0]
+		if r.Staged() != staged {
+			t.Errorf("%d. staged mismatch: got=%v exp=%v", i, r.Staged(), staged)
+		}
+		for k, c := range tt.canary {
+			if r.IsCanary(k) != c {
+				t.Errorf("%d. canary mismatch on %d: got=%v exp=%v", i, k, r.IsCanary(k), c)
+			}
+			if pushed := r.Results()[k] != nil; pushed != (c || !staged) {
+				t.Errorf("%d. push mismatch on %d: got=%v exp=%v", i, k, pushed, c || !staged)
+			}
+		}
+		if !staged {
+			if err := r.Promote(); err != deploy.ErrStage {
+				t.Errorf("%d. error mismatch: got=%q exp=%q", i, err, deploy.ErrStage)
+			}
+			continue
+		}
+		exp := map[string]interface{}{"0_BOOL": true, "0_FLOAT": 3.14, "0_INT": 12}
+		if tt.promote {
+			if err := r.Promote(); err != nil {
+				t.Fatalf("%d. unexpected error=%q", i, err)
+			}
+		} else {
+			if err := r.Abort(); err != nil {
+				t.Fatalf("%d. unexpected error=%q", i, err)
+			}
+		}
+		for k, c := range tt.canary {
+			data, _ := nodes[k].Lookup("0_BOOL")
+			switch {
+			case tt.promote:
+				if data != true {
+					t.Errorf("%d. promoted data mismatch on %d: got=%v exp=%v", i, k, data, true)
+				}
+			case k == 0:
+				if !reflect.DeepEqual(nodes[0].data, old) {
+					t.Errorf("%d. restored data mismatch: got=%v exp=%v", i, nodes[0].data, old)
+				}
+			case c:
+				if len(nodes[k].data) != 0 {
+					t.Errorf("%d. restored data mismatch on %d: got=%v", i, k, nodes[k].data)
+				}
+			default:
+				if nodes[k].data != nil {
+					t.Errorf("%d. aborted data mismatch on %d: got=%v", i, k, nodes[k].data)
+				}
+			}
+		}
+		if tt.promote && !reflect.DeepEqual(nodes[2].data, exp) {
+			t.Errorf("%d. data mismatch: got=%v exp=%v", i, nodes[2].data, exp)
+		}
+		if r.Staged() {
+			t.Errorf("%d. staged mismatch: got=%v exp=%v", i, true, false)
+		}
+	}
+}
+
+// TestReleaseResume tests the Before and Resume methods on Release.
+func TestReleaseResume(t *testing.T) {
+	for i, promote := range []bool{true, false} {
+		old := map[string]interface{}{"0_BOOL": false, "0_STR": "rv"}
+		nodes := []*store{{data: map[string]interface{}{"0_BOOL": false, "0_STR": "rv"}}, {}, {}}
+		r := deploy.New(noEnv, nodes[0], nodes[1], nodes[2])
+		if r.Before(0) != nil {
+			t.Errorf("%d. before mismatch: got=%v exp=nil", i, r.Before(0))
+		}
+		r.Canary(0, 1)
+		if err := r.Checkout([]string{""}, []string{""}); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if err := r.Push(); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		before := map[int]map[string]interface{}{0: r.Before(0), 1: r.Before(1)}
+		exp := map[string]interface{}{"0_BOOL": false, "0_FLOAT": nil, "0_INT": nil, "0_STR": "rv"}
+		if !reflect.DeepEqual(before[0], exp) {
+			t.Errorf("%d. before mismatch: got=%v exp=%v", i, before[0], exp)
+		}
+		// Another release, like after a restart.
+		r = deploy.New(noEnv, nodes[0], nodes[1], nodes[2])
+		if err := r.Resume(nil); err != deploy.ErrStage {
+			t.Errorf("%d. error mismatch: got=%q exp=%q", i, err, deploy.ErrStage)
+		}
+		if err := r.Checkout([]string{""}, []string{""}); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if err := r.Resume(before); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if !r.Staged() || !r.IsCanary(0) || !r.IsCanary(1) || r.IsCanary(2) {
+			t.Fatalf("%d. staged mismatch: got=%v", i, r.Staged())
+		}
+		if promote {
+			if err := r.Promote(); err != nil {
+				t.Fatalf("%d. unexpected error=%q", i, err)
+			}
+			if exp := nodes[0].data; !reflect.DeepEqual(nodes[2].data, exp) {
+				t.Errorf("%d. promoted data mismatch: got=%v exp=%v", i, nodes[2].data, exp)
+			}
+			continue
+		}
+		if err := r.Abort(); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if !reflect.DeepEqual(nodes[0].data, old) {
+			t.Errorf("%d. restored data mismatch: got=%v exp=%v", i, nodes[0].data, old)
+		}
+		if len(nodes[1].data) != 0 || nodes[2].data != nil {
+			t.Errorf("%d. aborted data mismatch: got=%v, %v", i, nodes[1].data, nodes[2].data)
+		}
+	}
+}
+
 // TestKey tests the Key method.
 func TestKey(t *testing.T) {
 	var dt = []struct {
@@ -304,6 +629,53 @@ func TestKey(t *testing.T) {
 	}
 }
 
+// TestCombine tests the Combine method.
+func TestCombine(t *testing.T) {
+	var dt = []struct {
+		in  [][]string
+		out [][]string
+	}{
+		{out: [][]string{{}}},
+		{in: [][]string{{"dev"}, nil}, out: [][]string{}},
+		{in: [][]string{{"dev"}, {""}}, out: [][]string{{"dev", ""}}},
+		{
+			in:  [][]string{{"dev", "prod"}, {"fr", "en"}, {"eu"}},
+			out: [][]string{{"dev", "fr", "eu"}, {"dev", "en", "eu"}, {"prod", "fr", "eu"}, {"prod", "en", "eu"}},
+		},
+	}
+	for i, tt := range dt {
+		if out := deploy.Combine(tt.in); !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("%d. content mismatch: got=%q exp=%q", i, out, tt.out)
+		}
+	}
+}
+
+// TestReleaseThreeEnvs tests a Release with three environments.
+func TestReleaseThreeEnvs(t *testing.T) {
+	r := deploy.New(threeEnv, rpcClient)
+	if err := r.Checkout([]string{"dev"}, []string{"fr"}, []string{"eu", "us"}); err != nil {
+		t.Fatalf("unexpected error=%q", err)
+	}
+	if ev := r.EnvsValues(); len(ev) != 3 {
+		t.Fatalf("envs mismatch: got=%q", ev)
+	}
+	if rows, exp := r.Rows(), [][]string{{"fr", "eu"}, {"fr", "us"}}; !reflect.DeepEqual(rows, exp) {
+		t.Errorf("rows mismatch: got=%q exp=%q", rows, exp)
+	}
+	exp := map[string]*deploy.Changes{
+		"BOOL": {Var: "BOOL", Log: map[string][2]interface{}{
+			"4_DEV_FR_EU_BOOL": {nil, true},
+			"4_DEV_FR_US_BOOL": {nil, false},
+		}},
+	}
+	if diff := r.Diff(); !reflect.DeepEqual(diff, exp) {
+		t.Errorf("diff mismatch: got=%+v exp=%+v", diff, exp)
+	}
+	if err := r.Checkout([]string{"dev"}, []string{"fr"}, []string{"asia"}); err != deploy.ErrInvalid {
+		t.Errorf("error mismatch: got=%q exp=%q", err, deploy.ErrInvalid)
+	}
+}
+
 // TestKey tests the Task methods.
 func TestTask(t *testing.T) {
 	var dt = []struct {
@@ -338,3 +710,58 @@ func TestTask(t *testing.T) {
 		}
 	}
 }
+
+// dest is a destination without support of the time to live.
+type dest struct{}
+
+func (d dest) Bulk(data map[string]interface{}) error { return nil }
+func (d dest) Lookup(key string) (interface{}, bool)  { return nil, false }
+
+// TestReleaseExpire tests the Push methods with a time to live.
+func TestReleaseExpire(t *testing.T) {
+	var dt = []struct {
+		dst, more deploy.Dest
+		err       error
+	}{
+		{dst: rpcClient},
+		{dst: deploy.ServerLess, more: rpcClient},
+		{dst: rpcClient, more: dest{}, err: deploy.ErrExpiry},
+	}
+	for i, tt := range dt {
+		r := deploy.New(noEnv, tt.dst, tt.more)
+		if err := r.Checkout([]string{""}, []string{""}); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		r.Expire(time.Hour)
+		if ttl := r.TTL(); ttl != time.Hour {
+			t.Errorf("%d. ttl mismatch: got=%v exp=%v", i, ttl, time.Hour)
+		}
+		if err := r.Push(); err != tt.err {
+			t.Errorf("%d. error mismatch: got=%q exp=%q", i, err, tt.err)
+		}
+	}
+}
+
+// invalidSrc is a source whose data can not be deployed.
+type invalidSrc struct {
+	src
+}
+
+// Validate implements the deploy.Validator interface.
+func (s invalidSrc) Validate(envsValues [][]string, names ...string) error {
+	return deploy.ErrInvalid
+}
+
+// TestReleaseValidate tests the Push methods with a source to validate.
+func TestReleaseValidate(t *testing.T) {
+	r := deploy.New(invalidSrc{noEnv}, rpcClient)
+	if err := r.Checkout([]string{""}, []string{""}); err != nil {
+		t.Fatalf("unexpected error=%q", err)
+	}
+	if err := r.Push(); err != deploy.ErrInvalid {
+		t.Errorf("error mismatch: got=%q exp=%q", err, deploy.ErrInvalid)
+	}
+	if l := r.Log(); l != nil {
+		t.Errorf("log mismatch: got=%v", l)
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Format must be implemented by any encoding of the data written in a file.
+type Format interface {
+	Decode(b []byte) (map[string]interface{}, error)
+	Encode(data map[string]interface{}) ([]byte, error)
+}
+
+// List of available formats of file.
+var (
+	// Dotenv writes the data as KEY=value lines, as expected by the dotenv loaders.
+	// The values are single-quoted to be never expanded.
+	Dotenv Format = &envFile{header: "# Managed by EVE, do not edit.", quote: dotenvQuote, unquote: dotenvUnquote}
+	// Systemd writes an EnvironmentFile for the systemd units.
+	// The values are double-quoted with C-like escapes.
+	Systemd Format = &envFile{header: "# EnvironmentFile managed by EVE, do not edit.", quote: strconv.Quote, unquote: strconv.Unquote}
+	// JSON writes the data as a JSON object, the values keep their type.
+	JSON Format = jsonFile{}
+)
+
+// ConfigMap returns the format of a Kubernetes ConfigMap manifest with this name.
+func ConfigMap(name string) Format {
+	return &manifest{kind: "ConfigMap", name: name}
+}
+
+// Secret returns the format of a Kubernetes Secret manifest with this name.
+// Its values are encoded in base64.
+func Secret(name string) Format {
+	return &manifest{kind: "Secret", name: name}
+}
+
+// File is a destination writing the data in a file with this format.
+// The data already in the file and not deployed are kept.
+// It reads back the file to look up the data, the values not kept with
+// their type, like with a dotenv file, are compared on their string version.
+type File struct {
+	path   string
+	format Format
+	mu     sync.Mutex
+}
+
+// NewFile returns a destination writing in the file at this path.
+func NewFile(path string, format Format) *File {
+	return &File{path: path, format: format}
+}
+
+// Path returns the path of the file.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Bulk implements the Dest interface.
+// The file is written in one time, only readable by its owner.
+func (f *File) Bulk(data map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	all, err := f.read()
+	if err != nil {
+		return err
+	}
+	for k, v := range data {
+		if v == nil {
+			delete(all, k)
+		} else {
+			all[k] = v
+		}
+	}
+	b, err := f.format.Encode(all)
+	if err != nil {
+		return err
+	}
+	return f.write(b)
+}
+
+// Lookup implements the Dest interface.
+func (f *File) Lookup(key string) (interface{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	all, err := f.read()
+	if err != nil {
+		return nil, false
+	}
+	v, ok := all[key]
+	return v, ok
+}
+
+func (f *File) read() (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return make(map[string]interface{}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	all, err := f.format.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	if all == nil {
+		all = make(map[string]interface{})
+	}
+	return all, nil
+}
+
+// write replaces the file by a temporary one, to never leave it half written.
+func (f *File) write(b []byte) error {
+	dir := filepath.Dir(f.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(f.path))
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Stringify returns the value as written in a text file.
+// A list is joined with commas, as given to EVE.
+func Stringify(v interface{}) string {
+	switch d := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return d
+	case []string:
+		return strings.Join(d, ",")
+	case []interface{}:
+		s := make([]string, len(d))
+		for i, v := range d {
+			s[i] = Stringify(v)
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// equal returns true if both values are the same.
+// The values read in a file without their type are compared on their string version.
+func equal(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	text := func(v interface{}) bool {
+		switch v.(type) {
+		case string, json.Number, []interface{}:
+			return true
+		}
+		return false
+	}
+	if a == nil || b == nil || !text(a) && !text(b) {
+		return false
+	}
+	return Stringify(a) == Stringify(b)
+}
+
+// sortedKeys returns the keys of the data in the alphabetic order.
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envFile is the format of an environment file, with its header as comment.
+// Quote and unquote deal with the values with spaces or special characters.
+type envFile struct {
+	header  string
+	quote   func(s string) string
+	unquote func(s string) (string, error)
+}
+
+// Decode implements the Format interface.
+func (f *envFile) Decode(b []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		p := strings.Index(line, "=")
+		if p < 1 {
+			return nil, ErrInvalid
+		}
+		v := strings.TrimSpace(line[p+1:])
+		switch {
+		case strings.HasPrefix(v, `"`):
+			var err error
+			if v, err = f.unquote(v); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") && len(v) > 1:
+			v = v[1 : len(v)-1]
+		}
+		data[strings.TrimSpace(line[:p])] = v
+	}
+	return data, s.Err()
+}
+
+// Encode implements the Format interface.
+// The values with spaces or special characters are quoted.
+func (f *envFile) Encode(data map[string]interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(f.header + "\n")
+	for _, k := range sortedKeys(data) {
+		v := Stringify(data[k])
+		if v == "" || strings.ContainsAny(v, " \t\r\n\"'\\#$`=") || !strconv.CanBackquote(v) {
+			v = f.quote(v)
+		}
+		buf.WriteString(k + "=" + v + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// dotenvEscaper escapes the characters interpreted by the dotenv loaders in double quotes.
+var dotenvEscaper = strings.NewReplacer(
+	`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`, "\t", `\t`,
+)
+
+// dotenvQuote single-quotes the value, so nothing is expanded or escaped by the dotenv loaders.
+// As a single-quoted value can not contain a single quote or a line break,
+// these values are double-quoted with all their special characters escaped.
+func dotenvQuote(s string) string {
+	if !strings.ContainsAny(s, "'\r\n") {
+		return "'" + s + "'"
+	}
+	return `"` + dotenvEscaper.Replace(s) + `"`
+}
+
+// dotenvUnquote returns the value of a double-quoted string written by dotenvQuote.
+func dotenvUnquote(s string) (string, error) {
+	if len(s) < 2 || s[len(s)-1] != '"' {
+		return "", ErrInvalid
+	}
+	s = s[1 : len(s)-1]
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			buf = append(buf, s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return "", ErrInvalid
+		}
+		switch s[i] {
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		default:
+			buf = append(buf, s[i])
+		}
+	}
+	return string(buf), nil
+}
+
+// jsonFile is the format of a JSON file.
+type jsonFile struct{}
+
+// Decode implements the Format interface.
+// The numbers are kept as written.
+func (f jsonFile) Decode(b []byte) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Encode implements the Format interface.
+func (f jsonFile) Encode(data map[string]interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// manifest is the format of a Kubernetes manifest of this kind,
+// with the data as strings.
+type manifest struct {
+	kind, name string
+}
+
+// Decode implements the Format interface.
+// It only reads the data of the manifests written by EVE.
+func (f *manifest) Decode(b []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	s := bufio.NewScanner(bytes.NewReader(b))
+	var in bool
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, " ") {
+			in = line == "data:"
+			continue
+		}
+		if !in {
+			continue
+		}
+		p := strings.Index(line, ":")
+		if p < 0 {
+			return nil, ErrInvalid
+		}
+		v := strings.TrimSpace(line[p+1:])
+		if strings.HasPrefix(v, `"`) {
+			var err error
+			if v, err = strconv.Unquote(v); err != nil {
+				return nil, err
+			}
+		}
+		if f.kind == "Secret" {
+			raw, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, err
+			}
+			v = string(raw)
+		}
+		data[strings.TrimSpace(line[:p])] = v
+	}
+	return data, s.Err()
+}
+
+// Encode implements the Format interface.
+func (f *manifest) Encode(data map[string]interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString("# Managed by EVE, do not edit.\n")
+	buf.WriteString("apiVersion: v1\n")
+	buf.WriteString("kind: " + f.kind + "\n")
+	buf.WriteString("metadata:\n")
+	buf.WriteString("  name: " + strconv.Quote(f.name) + "\n")
+	if f.kind == "Secret" {
+		buf.WriteString("type: Opaque\n")
+	}
+	if len(data) == 0 {
+		buf.WriteString("data: {}\n")
+		return buf.Bytes(), nil
+	}
+	buf.WriteString("data:\n")
+	for _, k := range sortedKeys(data) {
+		v := Stringify(data[k])
+		if f.kind == "Secret" {
+			v = base64.StdEncoding.EncodeToString([]byte(v))
+		} else {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString("  " + k + ": " + v + "\n")
+	}
+	return buf.Bytes(), nil
+}
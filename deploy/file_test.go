@@ -0,0 +1,157 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package deploy_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rvflash/eve/deploy"
+)
+
+// TestFile tests the push and the lookup of the data in each format of file.
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eve")
+	if err != nil {
+		t.Fatalf("unexpected error=%q", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var dt = []struct {
+		name   string
+		format deploy.Format
+		in     string
+		out    []string
+	}{
+		{
+			name: "alpha.env", format: deploy.Dotenv,
+			in:  "# mine\nexport OTHER='keep me'\n0_STR=rv\n",
+			out: []string{"0_BOOL=true\n", "0_FLOAT=3.14\n", "0_INT=12\n", "OTHER='keep me'"},
+		},
+		{
+			name: "alpha.conf", format: deploy.Systemd,
+			out: []string{"0_BOOL=true\n", "0_FLOAT=3.14\n", "0_INT=12\n"},
+		},
+		{
+			name: "alpha.json", format: deploy.JSON,
+			in:  `{"0_STR": "rv", "0_INT": 12}`,
+			out: []string{`"0_BOOL": true`, `"0_FLOAT": 3.14`, `"0_INT": 12`},
+		},
+		{
+			name: "alpha-cm.yaml", format: deploy.ConfigMap("alpha"),
+			out: []string{"kind: ConfigMap\n", "  0_BOOL: \"true\"\n", "  0_INT: \"12\"\n"},
+		},
+		{
+			name: "alpha-secret.yaml", format: deploy.Secret("alpha"),
+			out: []string{"kind: Secret\n", "  0_BOOL: dHJ1ZQ==\n", "  0_INT: MTI=\n"},
+		},
+	}
+	for i, tt := range dt {
+		path := filepath.Join(dir, tt.name)
+		if tt.in != "" {
+			if err := ioutil.WriteFile(path, []byte(tt.in), 0600); err != nil {
+				t.Fatalf("%d. unexpected error=%q", i, err)
+			}
+		}
+		f := deploy.NewFile(path, tt.format)
+		r := deploy.New(noEnv, f)
+		r.Verify()
+		if err := r.Checkout([]string{""}, []string{""}); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if err := r.Push(); err != nil {
+			t.Fatalf("%d. push error: got=%q", i, err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		for _, s := range tt.out {
+			if !strings.Contains(string(b), s) {
+				t.Errorf("%d. content mismatch: got=%q exp=%q", i, b, s)
+			}
+		}
+		if strings.Contains(string(b), "0_STR") {
+			t.Errorf("%d. deleted data still in the file: got=%q", i, b)
+		}
+		// Nothing more to update.
+		r = deploy.New(noEnv, f)
+		if err := r.Checkout([]string{""}, []string{""}); err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if task := r.Status(); task.NoOp != 3 || task.Upd != 0 {
+			t.Errorf("%d. status mismatch: got=%v", i, task)
+		}
+	}
+}
+
+// TestFileExpiry tests that a temporary deployment is refused by a file.
+func TestFileExpiry(t *testing.T) {
+	r := deploy.New(noEnv, deploy.NewFile(filepath.Join(os.TempDir(), "eve-ttl.env"), deploy.Dotenv))
+	r.Expire(1)
+	if err := r.Checkout([]string{""}, []string{""}); err != nil {
+		t.Fatalf("unexpected error=%q", err)
+	}
+	if err := r.Push(); err != deploy.ErrExpiry {
+		t.Errorf("error mismatch: got=%q exp=%q", err, deploy.ErrExpiry)
+	}
+}
+
+// TestEnvFileQuote tests the quoting of the values in the environment files.
+func TestEnvFileQuote(t *testing.T) {
+	var dt = []struct {
+		format  deploy.Format
+		in, out string
+	}{
+		{format: deploy.Dotenv, in: "rv", out: "K=rv\n"},
+		{format: deploy.Dotenv, in: "", out: "K=''\n"},
+		{format: deploy.Dotenv, in: "a ${HOME} $b", out: "K='a ${HOME} $b'\n"},
+		{format: deploy.Dotenv, in: `c:\tmp "x"`, out: `K='c:\tmp "x"'` + "\n"},
+		{format: deploy.Dotenv, in: "it's $b\n`c`", out: "K=\"it's \\$b\\n\\`c\\`\"\n"},
+		{format: deploy.Systemd, in: "a ${HOME}", out: `K="a ${HOME}"` + "\n"},
+		{format: deploy.Systemd, in: "it's\n", out: `K="it's\n"` + "\n"},
+	}
+	for i, tt := range dt {
+		b, err := tt.format.Encode(map[string]interface{}{"K": tt.in})
+		if err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if !strings.HasSuffix(string(b), tt.out) {
+			t.Errorf("%d. content mismatch: got=%q exp=%q", i, b, tt.out)
+		}
+		data, err := tt.format.Decode(b)
+		if err != nil {
+			t.Fatalf("%d. unexpected error=%q", i, err)
+		}
+		if data["K"] != tt.in {
+			t.Errorf("%d. value mismatch: got=%q exp=%q", i, data["K"], tt.in)
+		}
+	}
+}
+
+// TestStringify tests the Stringify function.
+func TestStringify(t *testing.T) {
+	var dt = []struct {
+		in  interface{}
+		out string
+	}{
+		{},
+		{in: "rv", out: "rv"},
+		{in: true, out: "true"},
+		{in: 12, out: "12"},
+		{in: 3.14, out: "3.14"},
+		{in: []string{"a", "b"}, out: "a,b"},
+		{in: []interface{}{"a", 1}, out: "a,1"},
+	}
+	for i, tt := range dt {
+		if out := deploy.Stringify(tt.in); !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("%d. content mismatch: got=%q exp=%q", i, out, tt.out)
+		}
+	}
+}
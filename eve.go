@@ -5,7 +5,9 @@
 package eve
 
 import (
+	"encoding/json"
 	"net"
+	"net/url"
 	"reflect"
 	"strconv"
 	"sync"
@@ -15,6 +17,7 @@ import (
 	"github.com/rvflash/eve/caseconv"
 	"github.com/rvflash/eve/client"
 	"github.com/rvflash/eve/deploy"
+	"github.com/rvflash/eve/keyring"
 )
 
 // Error messages.
@@ -107,10 +110,11 @@ func dialTo(withPartial bool, addr ...string) (caches []client.Getter, partial b
 
 // Client represents the EVE client to handle the data sources.
 type Client struct {
-	project,
-	firstEnv, secondEnv string
-	alive *time.Ticker
-	mu    sync.Mutex
+	project string
+	envs    []string
+	alive   *time.Ticker
+	mu      sync.Mutex
+	secret  client.Asserter
 	Handler
 }
 
@@ -180,20 +184,15 @@ func (c *Client) fresh() {
 	}
 }
 
-// Envs allows to define until 2 environments.
-// The adding's order is important, the first must be
-// the first environment defined in the EVE's project.
-// It returns an error if the number of environment is unexpected.
+// Envs defines the values of the environments.
+// The adding's order is important, it must be the one of
+// the environments defined in the EVE's project.
+// It returns an error if there is no environment.
 func (c *Client) Envs(envs ...string) error {
-	switch len(envs) {
-	case 2:
-		c.secondEnv = envs[1]
-		fallthrough
-	case 1:
-		c.firstEnv = envs[0]
-	default:
+	if len(envs) == 0 {
 		return ErrInvalid
 	}
+	c.envs = envs
 	return nil
 }
 
@@ -206,6 +205,16 @@ func (c *Client) UseHandler(h Handler) *Client {
 	return c
 }
 
+// UseKeyring defines the keyring used to decrypt the secret variables.
+// Without it, the secret variables are returned encrypted.
+// It returns the updated client.
+func (c *Client) UseKeyring(k *keyring.Keyring) *Client {
+	c.mu.Lock()
+	c.secret = client.NewDecrypter(k)
+	c.mu.Unlock()
+	return c
+}
+
 // Get retrieves the value of the environment variable named by the key.
 // If it not exists, a nil value is returned.
 func (c *Client) Get(key string) interface{} {
@@ -231,13 +240,15 @@ func (c *Client) assert(key string, typ client.Kind) (v interface{}, ok bool) {
 			if ha, needAssert := c.Handler[i].(client.Asserter); needAssert {
 				v, ok = ha.Assert(v, typ)
 			}
-			if _, k := c.Handler[i].(*client.Cache); k {
+			if _, k := c.Handler[i].(*client.Cache); !k {
 				// If the current handler is the local cache, no need to save the data.
-				return
+				if lc := c.cache(); lc != nil {
+					// Saves the data in the local cache, still encrypted if it is a secret.
+					_ = lc.Set(key, v)
+				}
 			}
-			if lc := c.cache(); lc != nil {
-				// Saves the data in the local cache.
-				_ = lc.Set(key, v)
+			if ok && c.secret != nil {
+				v, ok = c.secret.Assert(v, typ)
 			}
 			return
 		}
@@ -248,7 +259,8 @@ func (c *Client) assert(key string, typ client.Kind) (v interface{}, ok bool) {
 // Returns a deploy key by building it with all its pieces,
 // the project name, environments values and variable name.
 func (c *Client) deployKey(key string) string {
-	return deploy.Key(c.project, c.firstEnv, c.secondEnv, key)
+	parts := append([]string{c.project}, c.envs...)
+	return deploy.Key(append(parts, key)...)
 }
 
 // All information about the specification struct to feed.
@@ -318,6 +330,15 @@ func (c *Client) Process(spec interface{}) error {
 	if err != nil {
 		return err
 	}
+	keys := make([]string, len(infos))
+	for k, info := range infos {
+		keys[k] = info.Key
+	}
+	return c.prefetch(keys).assign(infos)
+}
+
+// assign sets the value of each field with the one of its variable.
+func (c *Client) assign(infos []varInfo) error {
 	// Sets the value of the given field.
 	feed := func(f varInfo) error {
 		typ := f.Value.Type()
@@ -371,6 +392,27 @@ func (c *Client) Process(spec interface{}) error {
 				return err
 			}
 			f.Value.SetFloat(v)
+		case reflect.Slice:
+			if typ.Elem().Kind() != reflect.String {
+				break
+			}
+			v, err := c.List(f.Key)
+			if err != nil && f.Required {
+				return err
+			}
+			f.Value.Set(reflect.ValueOf(v).Convert(typ))
+		case reflect.Struct:
+			if typ.PkgPath() != "net/url" || typ.Name() != "URL" {
+				break
+			}
+			v, err := c.URL(f.Key)
+			if err != nil {
+				if f.Required {
+					return err
+				}
+				break
+			}
+			f.Value.Set(reflect.ValueOf(*v))
 		default:
 			//todo Manages time.time from String var.
 		}
@@ -384,6 +426,78 @@ func (c *Client) Process(spec interface{}) error {
 	return nil
 }
 
+// fetched is the value of a variable retrieved by prefetch, with its data source.
+type fetched struct {
+	value interface{}
+	src   client.Getter
+}
+
+// prefetched is the data source of the variables retrieved by prefetch, by deploy key.
+// A missing key is not found in the data sources.
+type prefetched map[string]fetched
+
+// Lookup implements the client.Getter interface.
+func (p prefetched) Lookup(key string) (interface{}, bool) {
+	f, ok := p[key]
+	return f, ok
+}
+
+// Assert implements the client.Asserter interface.
+// The value is asserted by its data source if it needs it.
+func (p prefetched) Assert(value interface{}, typ client.Kind) (interface{}, bool) {
+	f := value.(fetched)
+	if a, ok := f.src.(client.Asserter); ok {
+		return a.Assert(f.value, typ)
+	}
+	return f.value, true
+}
+
+// prefetch retrieves the variables in one call by data source able to do it,
+// or one by one in the others, skipping the ones found in the previous data sources.
+// The order of the handlers is respected and the values are saved in the local cache.
+// It returns a client using only the retrieved values.
+func (c *Client) prefetch(keys []string) *Client {
+	res := make(prefetched, len(keys))
+	lc := c.cache()
+	missing := make([]string, len(keys))
+	for k, key := range keys {
+		missing[k] = c.deployKey(key)
+	}
+	for i := 0; i < len(c.Handler) && len(missing) > 0; i++ {
+		var data map[string]interface{}
+		if mg, ok := c.Handler[i].(client.MultiGetter); ok {
+			var err error
+			if data, err = mg.MultiGet(missing...); err != nil {
+				continue
+			}
+		}
+		// Keeps only the keys not found in this handler.
+		rest := missing[:0]
+		for _, key := range missing {
+			var (
+				v  interface{}
+				ok bool
+			)
+			if data == nil {
+				v, ok = c.Handler[i].Lookup(key)
+			} else {
+				v, ok = data[key]
+			}
+			if !ok {
+				rest = append(rest, key)
+				continue
+			}
+			res[key] = fetched{value: v, src: c.Handler[i]}
+			if _, k := c.Handler[i].(*client.Cache); !k && lc != nil {
+				// Saves the data in the local cache, still encrypted if it is a secret.
+				_ = lc.Set(key, v)
+			}
+		}
+		missing = rest
+	}
+	return &Client{project: c.project, envs: c.envs, secret: c.secret, Handler: Handler{0: res}}
+}
+
 // MustProcess is like Process but panics if it fails to feed the spec.
 func (c *Client) MustProcess(spec interface{}) {
 	if err := c.Process(spec); err != nil {
@@ -486,6 +600,101 @@ func (c *Client) MustString(key string) string {
 	return d
 }
 
+// Duration uses the key to get the variable's value behind as a time.Duration.
+func (c *Client) Duration(key string) (time.Duration, error) {
+	s, err := c.String(key)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, ErrInvalid
+	}
+	return d, nil
+}
+
+// MustDuration is like Duration but panics if the variable cannot be retrieved.
+func (c *Client) MustDuration(key string) time.Duration {
+	d, err := c.Duration(key)
+	if err != nil {
+		c.fatal("Duration", key, err)
+	}
+	return d
+}
+
+// JSON uses the key to get the variable's value behind
+// and decodes it as JSON in the value pointed to by v.
+func (c *Client) JSON(key string, v interface{}) error {
+	s, err := c.String(key)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(s), v); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// MustJSON is like JSON but panics if the variable cannot be retrieved.
+func (c *Client) MustJSON(key string, v interface{}) {
+	if err := c.JSON(key, v); err != nil {
+		c.fatal("JSON", key, err)
+	}
+}
+
+// List uses the key to get the variable's value behind as a list of strings.
+func (c *Client) List(key string) ([]string, error) {
+	d, ok := c.assert(key, client.ListVal)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	switch l := d.(type) {
+	case []string:
+		return l, nil
+	case []interface{}:
+		// JSON unmarshal stores the arrays as slice of interface.
+		s := make([]string, len(l))
+		for k, v := range l {
+			if s[k], ok = v.(string); !ok {
+				return nil, ErrInvalid
+			}
+		}
+		return s, nil
+	}
+	return nil, ErrInvalid
+}
+
+// MustList is like List but panics if the variable cannot be retrieved.
+func (c *Client) MustList(key string) []string {
+	d, err := c.List(key)
+	if err != nil {
+		c.fatal("List", key, err)
+	}
+	return d
+}
+
+// URL uses the key to get the variable's value behind as an URL.
+func (c *Client) URL(key string) (*url.URL, error) {
+	s, err := c.String(key)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	return u, nil
+}
+
+// MustURL is like URL but panics if the variable cannot be retrieved.
+func (c *Client) MustURL(key string) *url.URL {
+	d, err := c.URL(key)
+	if err != nil {
+		c.fatal("URL", key, err)
+	}
+	return d
+}
+
 // Panic!
 func (c *Client) fatal(method, key string, err error) {
 	quote := func(s string) string {
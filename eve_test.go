@@ -6,6 +6,7 @@ package eve_test
 
 import (
 	"fmt"
+	"net/url"
 	"reflect"
 	"sync"
 	"testing"
@@ -14,6 +15,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rvflash/eve"
 	"github.com/rvflash/eve/client"
+	"github.com/rvflash/eve/keyring"
 )
 
 const (
@@ -344,10 +346,20 @@ func TestWorkflow(t *testing.T) {
 	}
 }
 
-func TestClientTooMuchEnvs(t *testing.T) {
-	if err := eve.New("test").Envs("v1", "v2", "v3"); !reflect.DeepEqual(err, eve.ErrInvalid) {
+func TestClientEnvs(t *testing.T) {
+	if err := eve.New("test").Envs(); !reflect.DeepEqual(err, eve.ErrInvalid) {
 		t.Fatalf("error mismatch: got=%q exp=%q", err, eve.ErrInvalid)
 	}
+	src := vars{"TEST_EU_QA_FR_HOST": hostVal}
+	c := eve.New("test").UseHandler(eve.Handler{0: src})
+	if err := c.Envs("eu", "qa", "fr"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := c.String("host"); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	} else if s != hostVal {
+		t.Errorf("content mismatch: got=%q exp=%q", s, hostVal)
+	}
 }
 
 func TestServers(t *testing.T) {
@@ -388,3 +400,163 @@ func equalErrs(e1, e2 error) bool {
 	}
 	return e1.Error() == e2.Error()
 }
+
+// multiHandler is a test data source able to get many variables in one call.
+type multiHandler struct {
+	*handler
+	calls int
+}
+
+// MultiGet implements the client.MultiGetter interface.
+func (c *multiHandler) MultiGet(keys ...string) (map[string]interface{}, error) {
+	c.calls++
+	m := make(map[string]interface{})
+	for _, k := range keys {
+		if v, ok := c.Lookup(k); ok {
+			m[k] = v
+		}
+	}
+	return m, nil
+}
+
+func TestClientProcessPrefetch(t *testing.T) {
+	src := &multiHandler{handler: server}
+	lc := client.NewCache(time.Minute)
+	c := eve.New("test").UseHandler(eve.Handler{0: lc, 1: src})
+	if err := c.Envs("qa", "fr"); err != nil {
+		t.Fatal(err)
+	}
+	var rv exFields
+	if err := c.Process(&rv); err != nil {
+		t.Fatal(err)
+	}
+	if src.calls != 1 {
+		t.Errorf("calls mismatch: got=%d exp=1", src.calls)
+	}
+	if rv.Addr != hostVal || rv.Port != portVal {
+		t.Errorf("content mismatch: got=%v", rv)
+	}
+	if v, ok := lc.Lookup("TEST_QA_FR_HOST"); !ok || v != hostVal {
+		t.Errorf("local cache mismatch: got=%v exp=%v", v, hostVal)
+	}
+	// Without local cache, the variables are also retrieved in one call,
+	// and one by one without multi getter, whatever the kind of the fields.
+	src = &multiHandler{handler: server}
+	one := &lookupHandler{handler: server}
+	c = eve.New("test").UseHandler(eve.Handler{0: one, 1: src})
+	if err := c.Envs("qa", "fr"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Process(&rv); err != nil {
+		t.Fatal(err)
+	}
+	if src.calls != 1 {
+		t.Errorf("calls mismatch: got=%d exp=1", src.calls)
+	}
+	if one.calls != 4 {
+		t.Errorf("lookups mismatch: got=%d exp=4", one.calls)
+	}
+}
+
+// lookupHandler is a test data source counting the lookups and finding none.
+type lookupHandler struct {
+	*handler
+	calls int
+}
+
+// Lookup implements the client.Getter interface.
+func (c *lookupHandler) Lookup(key string) (interface{}, bool) {
+	c.calls++
+	return nil, false
+}
+
+// vars is a test data source.
+type vars map[string]interface{}
+
+// Lookup implements the client.Getter interface.
+func (v vars) Lookup(key string) (interface{}, bool) {
+	d, ok := v[key]
+	return d, ok
+}
+
+func TestClientUseKeyring(t *testing.T) {
+	k, err := keyring.New("k1", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := k.Encrypt(strVal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := vars{"TEST_PWD": secret}
+	// Without keyring, the secret is still encrypted.
+	c := eve.New("test").UseHandler(eve.Handler{0: client.NewCache(time.Minute), 1: src})
+	if s, err := c.String("pwd"); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	} else if s != secret {
+		t.Errorf("content mismatch: got=%q exp=%q", s, secret)
+	}
+	c.UseKeyring(k)
+	for i := 0; i < 2; i++ {
+		// The second time, the secret is retrieved from the local cache.
+		if s, err := c.String("pwd"); err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		} else if s != strVal {
+			t.Errorf("%d. content mismatch: got=%q exp=%q", i, s, strVal)
+		}
+	}
+}
+
+func TestClientKinds(t *testing.T) {
+	src := vars{
+		"TEST_TO":    "1m30s",
+		"TEST_CNF":   `{"name":"rv"}`,
+		"TEST_HOSTS": []interface{}{"sh01", "sh02"},
+		"TEST_API":   "https://sh01.prod/v1",
+		"TEST_BAD":   "%zz",
+	}
+	c := eve.New("test").UseHandler(eve.Handler{0: client.NewCache(time.Minute), 1: src})
+	if d, err := c.Duration("to"); err != nil {
+		t.Errorf("unexpected error: got=%q", err)
+	} else if d != 90*time.Second {
+		t.Errorf("content mismatch: got=%v exp=%v", d, 90*time.Second)
+	}
+	var cnf struct{ Name string }
+	if err := c.JSON("cnf", &cnf); err != nil {
+		t.Errorf("unexpected error: got=%q", err)
+	} else if cnf.Name != strVal {
+		t.Errorf("content mismatch: got=%v exp=%v", cnf.Name, strVal)
+	}
+	hosts := []string{"sh01", "sh02"}
+	if l, err := c.List("hosts"); err != nil {
+		t.Errorf("unexpected error: got=%q", err)
+	} else if !reflect.DeepEqual(l, hosts) {
+		t.Errorf("content mismatch: got=%v exp=%v", l, hosts)
+	}
+	if u, err := c.URL("api"); err != nil {
+		t.Errorf("unexpected error: got=%q", err)
+	} else if u.Host != "sh01.prod" {
+		t.Errorf("content mismatch: got=%v exp=%v", u.Host, "sh01.prod")
+	}
+	if _, err := c.Duration("cnf"); err != eve.ErrInvalid {
+		t.Errorf("error mismatch: got=%v exp=%v", err, eve.ErrInvalid)
+	}
+	if _, err := c.URL("bad"); err != eve.ErrInvalid {
+		t.Errorf("error mismatch: got=%v exp=%v", err, eve.ErrInvalid)
+	}
+	if _, err := c.List("rv"); err != eve.ErrNotFound {
+		t.Errorf("error mismatch: got=%v exp=%v", err, eve.ErrNotFound)
+	}
+	// Processes the struct with these kinds.
+	var cnf2 struct {
+		TO    time.Duration
+		Hosts []string `required:"true"`
+		API   *url.URL `required:"true"`
+	}
+	if err := c.Process(&cnf2); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if cnf2.TO != 90*time.Second || !reflect.DeepEqual(cnf2.Hosts, hosts) || cnf2.API.Path != "/v1" {
+		t.Errorf("content mismatch: got=%+v", cnf2)
+	}
+}
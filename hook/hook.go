@@ -0,0 +1,205 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+// Package hook builds and posts the payloads of the webhooks,
+// sent after each deployment and each change of a variable.
+package hook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/deploy"
+)
+
+// List of types of event.
+const (
+	DeployEvent = "deploy"
+	ChangeEvent = "change"
+)
+
+// Header is the name of the HTTP header with the signature of the payload.
+const Header = "X-Eve-Signature"
+
+// Masked replaces the values of the hidden variables.
+const Masked = "******"
+
+// MaxAttempts is the number of attempts of a delivery before to abandon it.
+const MaxAttempts = 8
+
+// ErrStatus is returned when the webhook responds with an unexpected status code.
+var ErrStatus = errors.New("unexpected status code")
+
+// Event is the payload posted to the webhooks.
+// Text and Content summarize it for the incoming webhooks of the chats,
+// the first one as expected by Slack or Mattermost, the other by Discord.
+type Event struct {
+	Type    string    `json:"type"`
+	Text    string    `json:"text"`
+	Content string    `json:"content"`
+	Project string    `json:"project"`
+	Author  string    `json:"author,omitempty"`
+	Deploy  *Deploy   `json:"deploy,omitempty"`
+	Var     *Var      `json:"var,omitempty"`
+	Ts      time.Time `json:"ts"`
+}
+
+// Deploy describes a deployment, with its status and the result on each server.
+type Deploy struct {
+	ID      uint64                    `json:"id"`
+	Stage   string                    `json:"stage,omitempty"`
+	Envs    [][]string                `json:"envs"`
+	Status  *deploy.Task              `json:"status,omitempty"`
+	Log     map[string][2]interface{} `json:"log"`
+	Servers []*db.Server              `json:"servers"`
+}
+
+// Var describes a change of the values of a variable.
+type Var struct {
+	ID       uint64    `json:"id"`
+	Name     string    `json:"name"`
+	ChangeID uint64    `json:"change_id"`
+	Cells    []db.Cell `json:"cells"`
+}
+
+// NewDeploy returns the event of the deployment of the project.
+// The status is the one of the release, nil if unknown.
+// The values with a deploy key in hidden are masked.
+func NewDeploy(d *db.Deploy, status *deploy.Task, hidden map[string]bool) *Event {
+	log := make(map[string][2]interface{}, len(d.LogList))
+	for k, v := range d.LogList {
+		if hidden[k] {
+			v = [2]interface{}{mask(v[0]), mask(v[1])}
+		}
+		log[k] = v
+	}
+	var failed []string
+	for _, srv := range d.ServerList {
+		if !srv.Succeeded && !srv.Pending() {
+			failed = append(failed, srv.TCPAddr)
+		}
+	}
+	text := fmt.Sprintf("%s deployed %d change(s) on %s", author(d.Author), len(log), d.ProjectID)
+	switch {
+	case len(failed) > 0:
+		text += ", failed on " + strings.Join(failed, ", ")
+	case d.Stage != "":
+		text += " (" + d.Stage + ")"
+	}
+	return &Event{
+		Type:    DeployEvent,
+		Text:    text,
+		Content: text,
+		Project: d.ProjectID,
+		Author:  d.Author,
+		Deploy: &Deploy{
+			ID:      d.ID,
+			Stage:   d.Stage,
+			Envs:    d.EnvsValues,
+			Status:  status,
+			Log:     log,
+			Servers: d.ServerList,
+		},
+		Ts: d.LastUpdateTs,
+	}
+}
+
+// NewChange returns the event of the change of the variable of the project.
+// The values of a hidden variable are masked.
+func NewChange(project string, v *db.Var, c *db.Change) *Event {
+	cells := make([]db.Cell, len(c.Cells))
+	names := make([]string, len(c.Cells))
+	for i, cell := range c.Cells {
+		if v.Hidden() {
+			cell.Before, cell.After = mask(cell.Before), mask(cell.After)
+		}
+		cells[i], names[i] = cell, cell.String()
+	}
+	sort.Strings(names)
+	text := fmt.Sprintf("%s changed %s on %s: %s", author(c.Author), v.Name, project, strings.Join(names, ", "))
+	return &Event{
+		Type:    ChangeEvent,
+		Text:    text,
+		Content: text,
+		Project: project,
+		Author:  c.Author,
+		Var: &Var{
+			ID:       v.ID,
+			Name:     v.Name,
+			ChangeID: c.ID,
+			Cells:    cells,
+		},
+		Ts: c.Ts,
+	}
+}
+
+// Sign returns the signature of the payload with the secret,
+// the hexadecimal HMAC-SHA256 prefixed by its algorithm.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post sends the JSON payload to the hook, signed with its secret if any.
+// It returns an error if the hook does not respond with a 2xx status code.
+func Post(c *http.Client, h *db.Hook, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "EVE-Hookshot")
+	if h.Secret != "" {
+		req.Header.Set(Header, Sign(h.Secret, payload))
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.WithMessage(ErrStatus, resp.Status)
+	}
+	return nil
+}
+
+// Backoff returns the delay before the next attempt of a delivery after this number of them.
+// It doubles with each attempt, from 30 seconds to one hour.
+func Backoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < time.Hour; i++ {
+		d *= 2
+	}
+	if d > time.Hour {
+		return time.Hour
+	}
+	return d
+}
+
+func author(name string) string {
+	if name == "" {
+		return "Someone"
+	}
+	return name
+}
+
+func mask(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return Masked
+}
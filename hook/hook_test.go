@@ -0,0 +1,148 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package hook_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/deploy"
+	"github.com/rvflash/eve/hook"
+)
+
+// TestSign tests the Sign function.
+func TestSign(t *testing.T) {
+	out := hook.Sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+	exp := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if out != exp {
+		t.Errorf("signature mismatch: got=%q exp=%q", out, exp)
+	}
+}
+
+// TestPost tests the Post function.
+func TestPost(t *testing.T) {
+	payload := []byte(`{"text":"rv"}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		sign := r.Header.Get(hook.Header)
+		switch r.URL.Path {
+		case "/signed":
+			if sign != hook.Sign("s3cr3t", b) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "/unsigned":
+			if sign != "" || !reflect.DeepEqual(b, payload) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	var dt = []struct {
+		hook *db.Hook
+		err  error
+	}{
+		{hook: db.NewHook("", ts.URL+"/signed", "s3cr3t")},
+		{hook: db.NewHook("", ts.URL+"/signed", "oops"), err: hook.ErrStatus},
+		{hook: db.NewHook("", ts.URL+"/unsigned", "")},
+		{hook: db.NewHook("", ts.URL+"/failure", ""), err: hook.ErrStatus},
+	}
+	for i, tt := range dt {
+		if err := hook.Post(ts.Client(), tt.hook, payload); errors.Cause(err) != tt.err {
+			t.Errorf("%d. error mismatch: got=%q exp=%q", i, err, tt.err)
+		}
+	}
+}
+
+// TestBackoff tests the Backoff function.
+func TestBackoff(t *testing.T) {
+	var dt = []struct {
+		in  int
+		out time.Duration
+	}{
+		{in: 0, out: 30 * time.Second},
+		{in: 1, out: 30 * time.Second},
+		{in: 2, out: time.Minute},
+		{in: 4, out: 4 * time.Minute},
+		{in: 10, out: time.Hour},
+	}
+	for i, tt := range dt {
+		if out := hook.Backoff(tt.in); out != tt.out {
+			t.Errorf("%d. backoff mismatch: got=%v exp=%v", i, out, tt.out)
+		}
+	}
+}
+
+// TestNewDeploy tests the NewDeploy function.
+func TestNewDeploy(t *testing.T) {
+	d := db.NewDeploy("alpha", ":9090", ":9091")
+	d.ID, d.Author = 3, "rv"
+	d.ServerList[0].Succeeded = true
+	d.ServerList[1].Err = "request has failed"
+	d.LogList = map[string][2]interface{}{
+		"ALPHA_PWD": {"old", "new"},
+		"ALPHA_ON":  {nil, true},
+	}
+	task := &deploy.Task{Add: 1, Upd: 1}
+	e := hook.NewDeploy(d, task, map[string]bool{"ALPHA_PWD": true})
+	if e.Type != hook.DeployEvent || e.Deploy.ID != 3 || e.Deploy.Status != task {
+		t.Errorf("event mismatch: got=%v", e)
+	}
+	if exp := "rv deployed 2 change(s) on alpha, failed on :9091"; e.Text != exp || e.Content != exp {
+		t.Errorf("text mismatch: got=%q exp=%q", e.Text, exp)
+	}
+	log := map[string][2]interface{}{
+		"ALPHA_PWD": {hook.Masked, hook.Masked},
+		"ALPHA_ON":  {nil, true},
+	}
+	if !reflect.DeepEqual(e.Deploy.Log, log) {
+		t.Errorf("log mismatch: got=%v exp=%v", e.Deploy.Log, log)
+	}
+	if d.LogList["ALPHA_PWD"][1] != "new" {
+		t.Errorf("deploy log changed: got=%v", d.LogList)
+	}
+}
+
+// TestNewChange tests the NewChange function.
+func TestNewChange(t *testing.T) {
+	c := &db.Change{ID: 2, VarID: 1, Cells: []db.Cell{
+		{ID: "_prod.fr", Before: "a", After: "b"},
+		{ID: db.DefaultCell, After: "c"},
+	}}
+	v := db.NewVar("pwd", db.String.Int())
+	v.ID = 1
+	var dt = []struct {
+		sensitive bool
+		cells     []db.Cell
+	}{
+		{cells: c.Cells},
+		{sensitive: true, cells: []db.Cell{
+			{ID: "_prod.fr", Before: hook.Masked, After: hook.Masked},
+			{ID: db.DefaultCell, After: hook.Masked},
+		}},
+	}
+	for i, tt := range dt {
+		v.Sensitive = tt.sensitive
+		e := hook.NewChange("alpha", v, c)
+		if exp := "Someone changed pwd on alpha: default, prod / fr"; e.Text != exp {
+			t.Errorf("%d. text mismatch: got=%q exp=%q", i, e.Text, exp)
+		}
+		if e.Type != hook.ChangeEvent || e.Var.ChangeID != 2 || !reflect.DeepEqual(e.Var.Cells, tt.cells) {
+			t.Errorf("%d. event mismatch: got=%v", i, e.Var)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+// Package keyring encrypts and decrypts the secret values with AES-GCM.
+package keyring
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// Prefix starts any encrypted value, followed by the identifier of the key.
+const Prefix = "eve:v1:"
+
+// Error messages.
+var (
+	ErrInvalid = errors.New("invalid keyring")
+	ErrKey     = errors.New("unknown key")
+	ErrSecret  = errors.New("invalid secret")
+)
+
+// Keyring is a set of named keys.
+// The primary key, the first one, is used to encrypt the values.
+// The other ones are only used to decrypt the values encrypted before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// New returns a Keyring with this key as primary.
+// The key must have 16, 24 or 32 bytes to use AES-128, AES-192 or AES-256.
+func New(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	if err := k.Add(id, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Open reads the keyring file. See Parse for its format.
+func Open(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return Parse(f)
+}
+
+// Parse reads a list of keys, one by line, in the form of "id:base64-key".
+// The first key is the primary one.
+// Empty lines and lines starting with # are ignored.
+func Parse(r io.Reader) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		d := strings.SplitN(line, ":", 2)
+		if len(d) != 2 {
+			return nil, ErrInvalid
+		}
+		key, err := base64.StdEncoding.DecodeString(d[1])
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		if err = k.Add(d[0], key); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if k.primary == "" {
+		return nil, ErrInvalid
+	}
+	return k, nil
+}
+
+// GenerateKey returns a new random key to use with AES-256.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Sealed returns true if the value has been encrypted by a keyring.
+func Sealed(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// Add adds a key to the keyring, as primary key if it is the first one.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || strings.Contains(id, ":") {
+		return ErrInvalid
+	}
+	if _, ok := k.keys[id]; ok {
+		return ErrInvalid
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.keys[id] = aead
+	if k.primary == "" {
+		k.primary = id
+	}
+	return nil
+}
+
+// Encrypt encrypts the value with the primary key.
+// The result is prefixed by the identifier of the key.
+func (k *Keyring) Encrypt(plain string) (string, error) {
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	data := aead.Seal(nonce, nonce, []byte(plain), []byte(k.primary))
+	return Prefix + k.primary + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt decrypts the value with the key used to encrypt it.
+func (k *Keyring) Decrypt(secret string) (string, error) {
+	if !Sealed(secret) {
+		return "", ErrSecret
+	}
+	d := strings.SplitN(strings.TrimPrefix(secret, Prefix), ":", 2)
+	if len(d) != 2 {
+		return "", ErrSecret
+	}
+	aead, ok := k.keys[d[0]]
+	if !ok {
+		return "", ErrKey
+	}
+	data, err := base64.StdEncoding.DecodeString(d[1])
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", ErrSecret
+	}
+	n := aead.NonceSize()
+	plain, err := aead.Open(nil, data[:n], data[n:], []byte(d[0]))
+	if err != nil {
+		return "", ErrSecret
+	}
+	return string(plain), nil
+}
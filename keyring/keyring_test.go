@@ -0,0 +1,82 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package keyring_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/rvflash/eve/keyring"
+)
+
+var (
+	key1 = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	key2 = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func TestParse(t *testing.T) {
+	var dt = []struct {
+		in  string
+		err error
+	}{
+		{in: "", err: keyring.ErrInvalid},
+		{in: "# comment", err: keyring.ErrInvalid},
+		{in: "k1", err: keyring.ErrInvalid},
+		{in: "k1:!!!", err: keyring.ErrInvalid},
+		{in: "k1:" + key1 + "\nk1:" + key2, err: keyring.ErrInvalid},
+		{in: "# comment\n\nk1:" + key1 + "\nk2:" + key2},
+	}
+	for i, tt := range dt {
+		if _, err := keyring.Parse(strings.NewReader(tt.in)); err != tt.err {
+			t.Errorf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		}
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	old, err := keyring.Parse(strings.NewReader("k1:" + key1))
+	if err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	s1, err := old.Encrypt("rv")
+	if err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if !keyring.Sealed(s1) || strings.Contains(s1, "rv") {
+		t.Fatalf("secret mismatch: got=%q", s1)
+	}
+	// Rotates the primary key.
+	k, err := keyring.Parse(strings.NewReader("k2:" + key2 + "\nk1:" + key1))
+	if err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	s2, err := k.Encrypt("hg")
+	if err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	var dt = []struct {
+		k     *keyring.Keyring
+		in    string
+		plain string
+		err   error
+	}{
+		{k: old, in: s1, plain: "rv"},
+		{k: k, in: s1, plain: "rv"},
+		{k: k, in: s2, plain: "hg"},
+		{k: old, in: s2, err: keyring.ErrKey},
+		{k: k, in: "rv", err: keyring.ErrSecret},
+		{k: k, in: s2[:len(s2)-4], err: keyring.ErrSecret},
+	}
+	for i, tt := range dt {
+		plain, err := tt.k.Decrypt(tt.in)
+		if err != tt.err {
+			t.Fatalf("%d. error mismatch: exp=%v got=%v", i, tt.err, err)
+		}
+		if plain != tt.plain {
+			t.Errorf("%d. content mismatch: exp=%q got=%q", i, tt.plain, plain)
+		}
+	}
+}
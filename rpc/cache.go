@@ -9,7 +9,10 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,24 +31,144 @@ var ErrNotFound = errors.New("not found")
 // the expected len or data type.
 var ErrUnexpected = errors.New("unexpected data")
 
+// ErrQuota is triggered when the change exceeds the maximum
+// number of items of the namespace.
+var ErrQuota = errors.New("quota exceeded")
+
+// DefaultNamespace is the namespace used without explicit one.
+// Like the others, it only gives access to its own data, except on reading:
+// a key missing there is read in its own namespace, as deployed by the editor.
+const DefaultNamespace = ""
+
+// NamespaceOf returns the namespace of the key as deployed by the editor,
+// the identifier of its project: the lowercase part before the first underscore.
+// Without underscore, it is the default namespace.
+func NamespaceOf(key string) string {
+	if i := strings.Index(key, "_"); i > 0 {
+		return strings.ToLower(key[:i])
+	}
+	return DefaultNamespace
+}
+
 // Cache represents the service to access data as a remote cache.
+// Data are stored by namespace, each of them with an optional quota of items.
 type Cache struct {
-	data  map[string]interface{}
-	stats *Metrics
-	mu    *sync.RWMutex
-	up    time.Time
+	data    map[string]*space
+	stats   *Metrics
+	quota   uint64
+	quotas  map[string]uint64
+	mu      *sync.RWMutex
+	lat     *latency
+	recycle time.Duration
+	ticker  *time.Ticker
+	done    chan struct{}
+	once    sync.Once
+	up      time.Time
+}
+
+// space is a namespace of the cache with its own data and statistics.
+type space struct {
+	reqs  Requests
+	items uint64
+	data  map[string]*entry
 }
 
 // Item represents a data to store.
+// Namespace and Version, the date of the last change of the data in nanoseconds,
+// are only used by the replication between cache servers, like Prev, the permanent
+// data replaced by an expiring one.
+// Expiration is the optional date after which the data expires.
+// On Put or Bulk, TTL can be used instead to give its time to live.
+// On Get, TTL contains the remaining time to live of the data, if any.
 type Item struct {
-	Key   string
-	Value interface{}
+	Namespace  string
+	Key        string
+	Value      interface{}
+	Version    int64
+	Expiration time.Time
+	TTL        time.Duration
+	Prev       *Item
+}
+
+// expires returns the expiration date in nanoseconds of the item, 0 if never.
+func (i *Item) expires(now time.Time) int64 {
+	switch {
+	case !i.Expiration.IsZero():
+		return i.Expiration.UnixNano()
+	case i.TTL > 0:
+		return now.Add(i.TTL).UnixNano()
+	}
+	return 0
+}
+
+// entry represents a data in the cache with the date of its last change
+// and its optional expiration date, both in nanoseconds.
+// A change date of zero marks the data loaded from an URL: it is local to the server,
+// ignored by the replication and replaced by any replicated data.
+// A nil value marks the data as deleted until the purge of its tombstone.
+// An expiring entry keeps the permanent one it replaces, restored once expired.
+type entry struct {
+	value   interface{}
+	ts, exp int64
+	prev    *entry
+}
+
+// loaded returns true if the entry has been loaded from an URL.
+func (e *entry) loaded() bool {
+	return e.ts == 0
+}
+
+// deleted returns true if the entry is a tombstone.
+func (e *entry) deleted() bool {
+	return e.value == nil
+}
+
+// expired returns true if the entry has expired at this date.
+func (e *entry) expired(now int64) bool {
+	return e.exp > 0 && e.exp <= now
+}
+
+// at returns the entry to read at this date: itself, or once expired,
+// the permanent entry it replaced. It returns nil if there is nothing to read.
+func (e *entry) at(now int64) *entry {
+	if e.expired(now) {
+		e = e.prev
+	}
+	if e == nil || e.deleted() {
+		return nil
+	}
+	return e
+}
+
+// item returns the entry as Item, with its remaining time to live.
+func (e *entry) item(key string) *Item {
+	i := &Item{Key: key, Value: e.value}
+	if e.exp > 0 {
+		i.Expiration = time.Unix(0, e.exp)
+		i.TTL = time.Until(i.Expiration)
+	}
+	return i
 }
 
 // Metrics exposes some data about the cache usage.
+// Revision is incremented on each change of the data.
+// LastBulk is the date of the last bulk received, the last deployment.
+// Latency gives by method of the service the histogram of its durations.
+// Namespaces gives the usage of each namespace.
 type Metrics struct {
-	Items  uint64
-	UpTime time.Duration
+	Items    uint64
+	UpTime   time.Duration
+	Revision uint64
+	LastBulk time.Time
+	Requests
+	Latency    map[string]*Histogram
+	Namespaces map[string]*Usage
+}
+
+// Usage exposes the statistics of one namespace.
+// Quota is the maximum number of items, 0 means unlimited.
+type Usage struct {
+	Items, Quota uint64
 	Requests
 }
 
@@ -54,16 +177,52 @@ type Requests struct {
 	Bulk, Clear, Delete, Get, Put uint64
 }
 
+// DefaultRecycle is the default duration between two purges of the expired data.
+var DefaultRecycle = time.Minute
+
 // New returns a new instance of Cache.
+// Its recycler of expired data starts with the first expiring data:
+// from there, the Close method must be called to stop it and avoid leaks.
 func New() *Cache {
 	return &Cache{
-		data:  make(map[string]interface{}),
-		stats: &Metrics{},
-		mu:    &sync.RWMutex{},
-		up:    time.Now(),
+		data:    make(map[string]*space),
+		stats:   &Metrics{},
+		quotas:  make(map[string]uint64),
+		mu:      &sync.RWMutex{},
+		lat:     newLatency(),
+		recycle: DefaultRecycle,
+		done:    make(chan struct{}),
+		up:      time.Now(),
 	}
 }
 
+// SetRecycle sets the duration between two purges of the expired data.
+// It must be called before storing any expiring data.
+func (c *Cache) SetRecycle(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recycle = d
+}
+
+// recycler starts the purge of the expired data if not already done.
+// The caller must hold the write lock.
+func (c *Cache) recycler() {
+	if c.ticker != nil {
+		return
+	}
+	c.ticker = time.NewTicker(c.recycle)
+	go func(t *time.Ticker) {
+		for {
+			select {
+			case <-t.C:
+				c.expire()
+			case <-c.done:
+				return
+			}
+		}
+	}(c.ticker)
+}
+
 // Getter represents the mean to do a HTTP get.
 type Getter interface {
 	Get(url string) (*http.Response, error)
@@ -73,7 +232,25 @@ type Getter interface {
 // on data fetches in the given URL.
 // If it fails to get it as JSON, it returns on error.
 // If the source is empty, no error is returned.
+// These data are not replicated: the data deployed on the peers take precedence.
 func NewFrom(url string, src ...Getter) (*Cache, error) {
+	res, err := Fetch(url, src...)
+	if err != nil {
+		return nil, err
+	}
+	// Creates the new Cache instance with these data inside.
+	c := New()
+	for k, v := range res {
+		c.set(NamespaceOf(k), k, v, 0, 0)
+		c.stats.Put++
+	}
+	c.stats.Revision++
+
+	return c, nil
+}
+
+// Fetch returns the data fetches as JSON in the given URL.
+func Fetch(url string, src ...Getter) (map[string]interface{}, error) {
 	var client Getter
 	switch len(src) {
 	case 1:
@@ -92,7 +269,7 @@ func NewFrom(url string, src ...Getter) (*Cache, error) {
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	// Parses it and uses it as default data in the cache.
+	// Parses it.
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New(resp.Status)
 	}
@@ -104,121 +281,526 @@ func NewFrom(url string, src ...Getter) (*Cache, error) {
 	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
 		return nil, err
 	}
-	// Creates the new Cache instance with these data inside.
-	c := New()
 	for k, v := range res {
-		c.data[k] = v
-		c.stats.Put++
-		c.stats.Items++
+		if l, ok := v.([]interface{}); ok {
+			res[k] = list(l)
+		}
 	}
-	return c, nil
+	return res, nil
+}
+
+// list returns the list as slice of strings if it only contains strings.
+// Unlike a slice of interface, it can be encoded with gob.
+func list(l []interface{}) interface{} {
+	s := make([]string, len(l))
+	for k, v := range l {
+		var ok bool
+		if s[k], ok = v.(string); !ok {
+			return l
+		}
+	}
+	return s
+}
+
+// Sync applies the loaded data on their namespace by comparing them
+// with the previous loaded ones, and returns the number of changes.
+// Only the keys still holding their previous loaded value, or missing,
+// are updated or deleted: the data deployed directly are kept.
+// Like with NewFrom, the loaded data are not replicated.
+func (c *Cache) Sync(data, prev map[string]interface{}) (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// owned returns true if the current data is the last loaded one.
+	owned := func(key string) bool {
+		e, found := c.lookup(NamespaceOf(key), key)
+		if !found {
+			return true
+		}
+		v, ok := prev[key]
+		return ok && same(e.value, v)
+	}
+	for k, v := range data {
+		if e, found := c.lookup(NamespaceOf(k), k); found && same(e.value, v) {
+			continue
+		}
+		if owned(k) {
+			c.set(NamespaceOf(k), k, v, 0, 0)
+			c.stats.Put++
+			n++
+		}
+	}
+	for k := range prev {
+		if _, ok := data[k]; ok {
+			continue
+		}
+		if _, found := c.lookup(NamespaceOf(k), k); found && owned(k) {
+			c.set(NamespaceOf(k), k, nil, 0, 0)
+			c.stats.Delete++
+			n++
+		}
+	}
+	if n > 0 {
+		c.stats.Revision++
+	}
+	return
+}
+
+// SetQuota sets the maximum number of items of the namespace, 0 means unlimited.
+func (c *Cache) SetQuota(ns string, max uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quotas[ns] = max
+}
+
+// SetDefaultQuota sets the maximum number of items of the namespaces without
+// their own quota, 0 means unlimited.
+func (c *Cache) SetDefaultQuota(max uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quota = max
 }
 
 // Bulk applies the item's modifications on the cache in one batch.
 // Item with nil value will be deleted.
 func (c *Cache) Bulk(batch []*Item, ack *bool) error {
+	return c.bulk(DefaultNamespace, batch, ack)
+}
+
+// Delete deletes the key in the cache.
+// ack is used to return acknowledgements to clients.
+func (c *Cache) Delete(key string, ack *bool) error {
+	return c.delete(DefaultNamespace, key, ack)
+}
+
+// Clear clears all cache items, acknowledges clear
+// ack is used to return acknowledgements to clients.
+func (c *Cache) Clear(skip bool, ack *bool) error {
+	return c.clear(DefaultNamespace, ack)
+}
+
+// Get gets the value of the given key in the default namespace or an error if it not exists.
+// resp contains the data to return to clients.
+func (c *Cache) Get(key string, resp *Item) error {
+	return c.get(DefaultNamespace, key, resp, anywhere)
+}
+
+// MultiGet returns in one call the data of the keys found in the default namespace.
+func (c *Cache) MultiGet(keys []string, reply *[]*Item) error {
+	return c.multiGet(DefaultNamespace, keys, reply, anywhere)
+}
+
+// List returns all the data of the default namespace whose key starts with the prefix, sorted by key.
+func (c *Cache) List(prefix string, reply *[]*Item) error {
+	return c.list(DefaultNamespace, prefix, reply, anywhere)
+}
+
+// Put puts this item in the cache.
+// ack is used to return acknowledgements to clients.
+func (c *Cache) Put(item *Item, ack *bool) error {
+	return c.put(DefaultNamespace, item, ack)
+}
+
+// Stats returns various statistics about this cache's instance.
+// The usage of each namespace is also returned.
+func (c *Cache) Stats(all bool, data *Metrics) error {
+	return c.statsOf(DefaultNamespace, data, anywhere)
+}
+
+// Close stops the recycler of expired data.
+func (c *Cache) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.ticker != nil {
+		c.ticker.Stop()
+	}
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
 
-	// Applies the modifications.
+func (c *Cache) bulk(ns string, batch []*Item, ack *bool) error {
+	defer c.lat.observe("Bulk", time.Now())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Checks the quota before applying the modifications.
+	sp := c.space(ns)
+	items := sp.items
+	seen := make(map[string]bool, len(batch))
 	for _, i := range batch {
-		_, found := c.data[i.Key]
-		if i.Value == nil {
-			if found {
-				delete(c.data, i.Key)
-				c.stats.Items--
-			}
-		} else {
-			if !found {
-				c.stats.Items++
-			}
-			c.data[i.Key] = i.Value
+		alive, ok := seen[i.Key]
+		if !ok {
+			alive = c.stored(ns, i.Key)
 		}
+		switch {
+		case i.Value == nil && alive:
+			items--
+		case i.Value != nil && !alive:
+			items++
+		}
+		seen[i.Key] = i.Value != nil
+	}
+	if c.exceeds(ns, items, sp.items) {
+		return ErrQuota
+	}
+	// Applies the modifications.
+	now := time.Now()
+	for _, i := range batch {
+		c.set(ns, i.Key, i.Value, now.UnixNano(), i.expires(now))
 	}
 	*ack = true
 
 	// Increments the statistics.
 	c.stats.Bulk++
+	sp.reqs.Bulk++
+	c.stats.Revision++
+	c.stats.LastBulk = time.Now()
 
 	return nil
 }
 
-// Delete deletes the key in the cache.
-// ack is used to return acknowledgements to clients.
-func (c *Cache) Delete(key string, ack *bool) error {
+func (c *Cache) delete(ns, key string, ack *bool) error {
+	defer c.lat.observe("Delete", time.Now())
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// Deletes the item.
-	if _, found := c.data[key]; !found {
+	if _, found := c.lookup(ns, key); !found {
 		return ErrNotFound
 	}
-	delete(c.data, key)
+	c.set(ns, key, nil, time.Now().UnixNano(), 0)
 	*ack = true
 
 	// Increments the statistics.
 	c.stats.Delete++
-	c.stats.Items--
+	c.space(ns).reqs.Delete++
+	c.stats.Revision++
 
 	return nil
 }
 
-// Clear clears all cache items, acknowledges clear
-// ack is used to return acknowledgements to clients.
-func (c *Cache) Clear(skip bool, ack *bool) error {
+func (c *Cache) clear(ns string, ack *bool) error {
+	defer c.lat.observe("Clear", time.Now())
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Resets the cache.
-	c.data = make(map[string]interface{})
+	// Resets the namespace by keeping a tombstone of each item
+	// to propagate the deletion to the peers.
+	sp := c.space(ns)
+	now := time.Now().UnixNano()
+	for k := range sp.data {
+		c.set(ns, k, nil, now, 0)
+	}
 	*ack = true
 
 	// Increments the statistics.
 	c.stats.Clear++
-	c.stats.Items = 0
+	sp.reqs.Clear++
+	c.stats.Revision++
 
 	return nil
 }
 
-// Get gets the value of the given key or an error if it not exists.
-// resp contains the data to return to clients.
-func (c *Cache) Get(key string, resp *Item) error {
+func (c *Cache) get(ns, key string, resp *Item, allows func(ns string) bool) error {
+	defer c.lat.observe("Get", time.Now())
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	// Retrieves the item.
-	value, found := c.data[key]
+	ns = c.scope(ns, key, allows)
+	e, found := c.lookup(ns, key)
 	if !found {
 		return ErrNotFound
 	}
-	*resp = Item{key, value}
+	*resp = *e.item(key)
 
 	// Increments the statistics.
-	c.stats.Get++
+	atomic.AddUint64(&c.stats.Get, 1)
+	if sp, ok := c.data[ns]; ok {
+		atomic.AddUint64(&sp.reqs.Get, 1)
+	}
 	return nil
 }
 
-// Put puts this item in the cache.
-// ack is used to return acknowledgements to clients.
-func (c *Cache) Put(item *Item, ack *bool) error {
+func (c *Cache) multiGet(ns string, keys []string, reply *[]*Item, allows func(ns string) bool) error {
+	defer c.lat.observe("MultiGet", time.Now())
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := make([]*Item, 0, len(keys))
+	for _, key := range keys {
+		in := c.scope(ns, key, allows)
+		if e, found := c.lookup(in, key); found {
+			items = append(items, e.item(key))
+		}
+		// Increments the statistics.
+		if sp, ok := c.data[in]; ok {
+			atomic.AddUint64(&sp.reqs.Get, 1)
+		}
+	}
+	*reply = items
+	atomic.AddUint64(&c.stats.Get, uint64(len(keys)))
+
+	return nil
+}
+
+func (c *Cache) list(ns, prefix string, reply *[]*Item, allows func(ns string) bool) error {
+	defer c.lat.observe("List", time.Now())
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// Lists the matching keys, those of the namespace first.
+	var items []*Item
+	seen := make(map[string]bool)
+	for _, in := range c.scopes(ns, prefix, allows) {
+		sp, ok := c.data[in]
+		if !ok {
+			continue
+		}
+		for key := range sp.data {
+			if seen[key] || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if e, found := c.lookup(in, key); found {
+				items = append(items, e.item(key))
+				seen[key] = true
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	*reply = items
+	return nil
+}
+
+func (c *Cache) put(ns string, item *Item, ack *bool) error {
+	defer c.lat.observe("Put", time.Now())
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Checks the quota on adding.
+	sp := c.space(ns)
+	if item.Value != nil && !c.stored(ns, item.Key) {
+		if c.exceeds(ns, sp.items+1, sp.items) {
+			return ErrQuota
+		}
+	}
 	// Puts the item.
-	c.data[item.Key] = item.Value
+	now := time.Now()
+	c.set(ns, item.Key, item.Value, now.UnixNano(), item.expires(now))
 	*ack = true
 
 	// Increments the statistics.
 	c.stats.Put++
-	c.stats.Items++
+	sp.reqs.Put++
+	c.stats.Revision++
 
 	return nil
 }
 
-// Stats returns various statistics about this cache's instance.
-func (c *Cache) Stats(all bool, data *Metrics) error {
+// statsOf returns the statistics of the namespace.
+// The default namespace gives the statistics of all the namespaces allowed,
+// the whole cache if there is no restriction.
+func (c *Cache) statsOf(ns string, data *Metrics, allows func(ns string) bool) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// The counters of Get are incremented under the read lock.
+	data.Items = atomic.LoadUint64(&c.stats.Items)
+	data.Revision = atomic.LoadUint64(&c.stats.Revision)
+	data.LastBulk = c.stats.LastBulk
+	data.Requests = loadRequests(&c.stats.Requests)
 	// Number of seconds since the last restart of the server.
-	c.stats.UpTime = time.Since(c.up)
-	*data = *c.stats
+	data.UpTime = time.Since(c.up)
+	data.Latency = c.lat.snapshot()
+	data.Namespaces = make(map[string]*Usage)
+	partial := ns != DefaultNamespace
+	for k, sp := range c.data {
+		if ns != DefaultNamespace && k != ns {
+			continue
+		}
+		if !allows(k) {
+			partial = true
+			continue
+		}
+		data.Namespaces[k] = &Usage{
+			Items:    atomic.LoadUint64(&sp.items),
+			Quota:    c.quotaOf(k),
+			Requests: loadRequests(&sp.reqs),
+		}
+	}
+	if partial {
+		// Limits the counters to the namespaces returned.
+		data.Items, data.Requests = 0, Requests{}
+		for _, u := range data.Namespaces {
+			data.Items += u.Items
+			data.Requests.add(u.Requests)
+		}
+	}
 	return nil
 }
+
+// add adds the counters to the current ones.
+func (r *Requests) add(o Requests) {
+	r.Bulk += o.Bulk
+	r.Clear += o.Clear
+	r.Delete += o.Delete
+	r.Get += o.Get
+	r.Put += o.Put
+}
+
+// loadRequests returns a copy of the counters, loaded atomically.
+func loadRequests(r *Requests) Requests {
+	return Requests{
+		Bulk:   atomic.LoadUint64(&r.Bulk),
+		Clear:  atomic.LoadUint64(&r.Clear),
+		Delete: atomic.LoadUint64(&r.Delete),
+		Get:    atomic.LoadUint64(&r.Get),
+		Put:    atomic.LoadUint64(&r.Put),
+	}
+}
+
+// expire removes all the expired data, or restores the permanent ones they replaced.
+func (c *Cache) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	for _, sp := range c.data {
+		for k, e := range sp.data {
+			if e.expired(now) {
+				if e.prev != nil {
+					sp.data[k] = e.prev
+					continue
+				}
+				if !e.deleted() {
+					sp.items--
+					c.stats.Items--
+				}
+				delete(sp.data, k)
+			}
+		}
+	}
+}
+
+// exceeds returns true if the number of items of the namespace
+// exceeds its quota. Reducing the number of items is always allowed.
+// The caller must hold the lock.
+func (c *Cache) exceeds(ns string, items, before uint64) bool {
+	max := c.quotaOf(ns)
+	return max > 0 && items > max && items > before
+}
+
+// quotaOf returns the maximum number of items of the namespace.
+// The caller must hold the lock.
+func (c *Cache) quotaOf(ns string) uint64 {
+	if max, ok := c.quotas[ns]; ok {
+		return max
+	}
+	return c.quota
+}
+
+// anywhere allows the reading in all the namespaces.
+func anywhere(ns string) bool {
+	return true
+}
+
+// scope returns the namespace in which to read the key.
+// From the default namespace, a key missing there is read in its own namespace,
+// if allowed, in order to serve the clients unaware of the namespaces.
+// The caller must hold the lock.
+func (c *Cache) scope(ns, key string, allows func(ns string) bool) string {
+	if ns != DefaultNamespace {
+		return ns
+	}
+	if _, found := c.lookup(ns, key); found {
+		return ns
+	}
+	if own := NamespaceOf(key); own != ns && allows(own) {
+		return own
+	}
+	return ns
+}
+
+// scopes returns the namespaces in which to list the keys starting with the prefix,
+// by order of precedence.
+func (c *Cache) scopes(ns, prefix string, allows func(ns string) bool) []string {
+	if ns != DefaultNamespace {
+		return []string{ns}
+	}
+	if own := NamespaceOf(prefix); own != ns && allows(own) {
+		return []string{ns, own}
+	}
+	return []string{ns}
+}
+
+// space returns the namespace, creating it if not exists.
+// The caller must hold the write lock.
+func (c *Cache) space(ns string) *space {
+	sp, ok := c.data[ns]
+	if !ok {
+		sp = &space{data: make(map[string]*entry)}
+		c.data[ns] = sp
+	}
+	return sp
+}
+
+// lookup returns the entry behind the key in the namespace if it exists,
+// is not deleted and not expired.
+// The caller must hold the lock.
+func (c *Cache) lookup(ns, key string) (*entry, bool) {
+	sp, ok := c.data[ns]
+	if !ok {
+		return nil, false
+	}
+	e, ok := sp.data[key]
+	if !ok {
+		return nil, false
+	}
+	if e = e.at(time.Now().UnixNano()); e == nil {
+		return nil, false
+	}
+	return e, true
+}
+
+// stored returns true if the key is counted as an item of the namespace,
+// expired or not.
+// The caller must hold the lock.
+func (c *Cache) stored(ns, key string) bool {
+	if sp, ok := c.data[ns]; ok {
+		e, ok := sp.data[key]
+		return ok && !e.deleted()
+	}
+	return false
+}
+
+// set saves the value behind the key in the namespace with the given version
+// and expiration date, and maintains the counters of items.
+// A nil value replaces the data by a tombstone.
+// An expiring value keeps the permanent one it replaces, to restore it once expired.
+// The caller must hold the write lock.
+func (c *Cache) set(ns, key string, value interface{}, ts, exp int64) {
+	found := c.stored(ns, key)
+	sp := c.space(ns)
+	var prev *entry
+	if exp > 0 && value != nil {
+		if old, ok := sp.data[key]; ok {
+			if prev = old.prev; old.exp == 0 && !old.deleted() {
+				prev = old
+			}
+		}
+	}
+	if exp > 0 {
+		c.recycler()
+	}
+	switch {
+	case value == nil && found:
+		sp.items--
+		c.stats.Items--
+	case value != nil && !found:
+		sp.items++
+		c.stats.Items++
+	}
+	sp.data[key] = &entry{value: value, ts: ts, exp: exp, prev: prev}
+}
@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rvflash/eve/rpc"
 )
@@ -29,6 +30,8 @@ func (c *fakeHTTPClient) Get(url string) (*http.Response, error) {
 		switch r.URL.Path {
 		case "/vars":
 			_, _ = io.WriteString(w, `{"ALPHA_BOOL":true,"ALPHA_STR":"2ojE41"}`)
+		case "/list":
+			_, _ = io.WriteString(w, `{"ALPHA_LIST":["a","b"],"ALPHA_MIX":["a",1]}`)
 		case "/oops":
 			_, _ = io.WriteString(w, `{"ALPHA_BOOL"`)
 		default:
@@ -70,6 +73,20 @@ func TestNewFrom(t *testing.T) {
 	}
 }
 
+func TestFetch(t *testing.T) {
+	data, err := rpc.Fetch("http://localhost:8080/list", &fakeHTTPClient{})
+	if err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	exp := map[string]interface{}{
+		"ALPHA_LIST": []string{"a", "b"},
+		"ALPHA_MIX":  []interface{}{"a", 1.0},
+	}
+	if !reflect.DeepEqual(data, exp) {
+		t.Errorf("content mismatch: exp=%v got=%v", exp, data)
+	}
+}
+
 func TestWorkflow(t *testing.T) {
 	// Creates the workspace.
 	k, v := "RV", true
@@ -154,3 +171,182 @@ func TestWorkflow(t *testing.T) {
 		t.Fatalf("stats mismatch: exp=%v got=%v", exp, req.Requests)
 	}
 }
+
+func TestNamespaceOf(t *testing.T) {
+	var dt = []struct {
+		in, out string
+	}{
+		{in: "HOST"},
+		{in: "_HOST"},
+		{in: "ALPHA_PROD_HOST", out: "alpha"},
+		{in: "MY-APP_HOST", out: "my-app"},
+	}
+	for i, tt := range dt {
+		if out := rpc.NamespaceOf(tt.in); out != tt.out {
+			t.Errorf("%d. namespace mismatch: exp=%q got=%q", i, tt.out, out)
+		}
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := rpc.New()
+	c.SetRecycle(5 * time.Millisecond)
+	defer func() { _ = c.Close() }()
+
+	var ok bool
+	if err := c.Put(&rpc.Item{Key: "e", Value: 5}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	batch := []*rpc.Item{
+		{Key: "a", Value: 1},
+		{Key: "b", Value: 2, TTL: time.Hour},
+		{Key: "c", Value: 3, TTL: 5 * time.Millisecond},
+		{Key: "d", Value: 4, Expiration: time.Now().Add(-time.Second)},
+		{Key: "e", Value: 6, TTL: 5 * time.Millisecond},
+	}
+	if err := c.Bulk(batch, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	var dt = []struct {
+		key   string
+		ttl   bool
+		onErr bool
+	}{
+		{key: "a"},
+		{key: "b", ttl: true},
+		{key: "c", ttl: true},
+		{key: "d", onErr: true},
+		{key: "e", ttl: true},
+	}
+	for i, tt := range dt {
+		item := &rpc.Item{}
+		err := c.Get(tt.key, item)
+		if tt.onErr != (err != nil) {
+			t.Fatalf("%d. error mismatch: error expected=%t got=%q", i, tt.onErr, err)
+		}
+		if tt.ttl != (item.TTL > 0) {
+			t.Errorf("%d. ttl mismatch: exp=%t got=%v", i, tt.ttl, item.TTL)
+		}
+	}
+	// Lazy expiry: the temporary value gives way to the previous one.
+	time.Sleep(5 * time.Millisecond)
+	if err := c.Get("c", &rpc.Item{}); err != rpc.ErrNotFound {
+		t.Errorf("error mismatch: exp=%q got=%q", rpc.ErrNotFound, err)
+	}
+	item := &rpc.Item{}
+	if err := c.Get("e", item); err != nil || item.Value != 5 || item.TTL != 0 {
+		t.Errorf("previous value mismatch: exp=5 got=%v (%v)", item.Value, err)
+	}
+	// Periodic expiry.
+	stats := &rpc.Metrics{}
+	for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(time.Millisecond) {
+		if _ = c.Stats(true, stats); stats.Items == 3 {
+			break
+		}
+	}
+	if stats.Items != 3 {
+		t.Errorf("items mismatch: exp=3 got=%d", stats.Items)
+	}
+	if err := c.Get("e", item); err != nil || item.Value != 5 {
+		t.Errorf("previous value mismatch: exp=5 got=%v (%v)", item.Value, err)
+	}
+}
+
+func TestCacheList(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+
+	var ok bool
+	batch := []*rpc.Item{{Key: "RV_B", Value: 2}, {Key: "RV_A", Value: 1}, {Key: "HG", Value: 3}}
+	if err := c.Bulk(batch, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	var dt = []struct {
+		prefix string
+		keys   []string
+	}{
+		{prefix: "", keys: []string{"HG", "RV_A", "RV_B"}},
+		{prefix: "RV_", keys: []string{"RV_A", "RV_B"}},
+		{prefix: "NOPE", keys: []string{}},
+	}
+	for i, tt := range dt {
+		var items []*rpc.Item
+		if err := c.List(tt.prefix, &items); err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		keys := make([]string, len(items))
+		for k, v := range items {
+			keys[k] = v.Key
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%d. keys mismatch: exp=%v got=%v", i, tt.keys, keys)
+		}
+	}
+}
+
+func TestCacheMultiGet(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+
+	var ok bool
+	batch := []*rpc.Item{{Key: "RV_B", Value: 2}, {Key: "RV_A", Value: 1}}
+	if err := c.Bulk(batch, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	var dt = []struct {
+		in   []string
+		keys []string
+	}{
+		{in: []string{}, keys: []string{}},
+		{in: []string{"NOPE"}, keys: []string{}},
+		{in: []string{"RV_A", "NOPE", "RV_B"}, keys: []string{"RV_A", "RV_B"}},
+	}
+	for i, tt := range dt {
+		var items []*rpc.Item
+		if err := c.MultiGet(tt.in, &items); err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		keys := make([]string, len(items))
+		for k, v := range items {
+			keys[k] = v.Key
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%d. keys mismatch: exp=%v got=%v", i, tt.keys, keys)
+		}
+	}
+}
+
+func TestCacheSync(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+
+	v1 := map[string]interface{}{"A": 1.0, "B": "b", "C": true}
+	if n := c.Sync(v1, nil); n != 3 {
+		t.Fatalf("changes mismatch: exp=3 got=%d", n)
+	}
+	// Deploys directly some data.
+	var ok bool
+	if err := c.Bulk([]*rpc.Item{{Key: "B", Value: "rv"}, {Key: "D", Value: 4}}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	v2 := map[string]interface{}{"A": 2.0, "B": "c"}
+	if n := c.Sync(v2, v1); n != 2 {
+		t.Fatalf("changes mismatch: exp=2 got=%d", n)
+	}
+	var dt = []struct {
+		key   string
+		value interface{}
+	}{
+		{key: "A", value: 2.0},
+		{key: "B", value: "rv"},
+		{key: "C"},
+		{key: "D", value: 4},
+	}
+	for i, tt := range dt {
+		item := &rpc.Item{}
+		_ = c.Get(tt.key, item)
+		if item.Value != tt.value {
+			t.Errorf("%d. content mismatch for %s: exp=%v got=%v", i, tt.key, tt.value, item.Value)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBuckets lists the upper bounds, in seconds,
+// used to count the latency of the requests.
+var DefaultBuckets = []float64{
+	.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1,
+}
+
+// Histogram counts the observed durations by bucket.
+// Counts has one more element than Buckets to store the values
+// greater than the last upper bound.
+type Histogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+// NewHistogram returns a new instance of Histogram.
+// Without upper bound, it uses the DefaultBuckets.
+func NewHistogram(buckets ...float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe adds the duration in the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	v := d.Seconds()
+	i := 0
+	for ; i < len(h.Buckets); i++ {
+		if v <= h.Buckets[i] {
+			break
+		}
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+// Cumulative returns the cumulative counters of each bucket,
+// the last one containing the count of all observations.
+func (h *Histogram) Cumulative() []uint64 {
+	c := make([]uint64, len(h.Counts))
+	var n uint64
+	for i, v := range h.Counts {
+		n += v
+		c[i] = n
+	}
+	return c
+}
+
+// copy returns a copy of the histogram.
+func (h *Histogram) copy() *Histogram {
+	c := &Histogram{
+		Buckets: h.Buckets,
+		Counts:  make([]uint64, len(h.Counts)),
+		Count:   h.Count,
+		Sum:     h.Sum,
+	}
+	_ = copy(c.Counts, h.Counts)
+	return c
+}
+
+// latency maintains one histogram by method of the service.
+type latency struct {
+	data map[string]*Histogram
+	mu   sync.Mutex
+}
+
+func newLatency() *latency {
+	return &latency{data: make(map[string]*Histogram)}
+}
+
+// observe records the time elapsed since the start of the request.
+func (l *latency) observe(method string, start time.Time) {
+	d := time.Since(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h, ok := l.data[method]
+	if !ok {
+		h = NewHistogram()
+		l.data[method] = h
+	}
+	h.Observe(d)
+}
+
+// snapshot returns a copy of all the histograms.
+func (l *latency) snapshot() map[string]*Histogram {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m := make(map[string]*Histogram, len(l.data))
+	for k, h := range l.data {
+		m[k] = h.copy()
+	}
+	return m
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rvflash/eve/rpc"
+)
+
+func TestHistogram(t *testing.T) {
+	h := rpc.NewHistogram(.001, .01)
+	for _, d := range []time.Duration{
+		500 * time.Microsecond,
+		time.Millisecond,
+		5 * time.Millisecond,
+		time.Second,
+	} {
+		h.Observe(d)
+	}
+	if exp := []uint64{2, 1, 1}; !reflect.DeepEqual(h.Counts, exp) {
+		t.Fatalf("counts mismatch: exp=%v got=%v", exp, h.Counts)
+	}
+	if exp := []uint64{2, 3, 4}; !reflect.DeepEqual(h.Cumulative(), exp) {
+		t.Fatalf("cumulative counts mismatch: exp=%v got=%v", exp, h.Cumulative())
+	}
+	if h.Count != 4 {
+		t.Fatalf("count mismatch: exp=4 got=%d", h.Count)
+	}
+	if h.Sum < 1.0065 || h.Sum > 1.0066 {
+		t.Fatalf("sum mismatch: exp=1.0065 got=%v", h.Sum)
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	var ok bool
+	c := rpc.New()
+	if err := c.Bulk([]*rpc.Item{{Key: "rv", Value: 42}}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if err := c.Get("rv", &rpc.Item{}); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	m := &rpc.Metrics{}
+	if err := c.Stats(true, m); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if m.Revision != 1 {
+		t.Errorf("revision mismatch: exp=1 got=%d", m.Revision)
+	}
+	if m.LastBulk.IsZero() {
+		t.Error("expected a last bulk date")
+	}
+	for _, k := range []string{"Bulk", "Get"} {
+		if h, ok := m.Latency[k]; !ok || h.Count != 1 {
+			t.Errorf("latency mismatch for %s: got=%v", k, h)
+		}
+	}
+}
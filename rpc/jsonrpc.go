@@ -0,0 +1,347 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	netrpc "net/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSONVersion is the version of the JSON-RPC protocol.
+const JSONVersion = "2.0"
+
+// Error codes of the JSON-RPC protocol.
+const (
+	jsonParseError  = -32700
+	jsonServerError = -32000
+)
+
+// jsonError is the error object of the JSON-RPC protocol.
+type jsonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// jsonRequest is a request of the JSON-RPC protocol.
+// Without identifier, the request is a notification without response.
+type jsonRequest struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  *json.RawMessage `json:"params,omitempty"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+}
+
+// jsonResponse is a response of the JSON-RPC protocol.
+type jsonResponse struct {
+	Version string           `json:"jsonrpc"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *jsonError       `json:"error,omitempty"`
+	ID      *json.RawMessage `json:"id"`
+}
+
+// jsonServerCodec implements the JSON-RPC 2.0 protocol on the server side.
+type jsonServerCodec struct {
+	dec *json.Decoder
+	enc *json.Encoder
+	c   io.Closer
+	req jsonRequest
+	ids *jsonIDs
+}
+
+// jsonIDs maps the sequence numbers used by net/rpc to the identifiers of the requests,
+// any JSON value, until their response.
+type jsonIDs struct {
+	mu   sync.Mutex
+	last uint64
+	ids  map[uint64]*json.RawMessage
+}
+
+// add returns the sequence number of the request with this identifier.
+func (m *jsonIDs) add(id *json.RawMessage) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.last++
+	m.ids[m.last] = id
+	return m.last
+}
+
+// pop returns the identifier of the request with this sequence number and forgets it.
+func (m *jsonIDs) pop(seq uint64) (id *json.RawMessage, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok = m.ids[seq]; ok {
+		delete(m.ids, seq)
+	}
+	return
+}
+
+// NewJSONServerCodec returns a new rpc.ServerCodec using JSON-RPC 2.0 on conn.
+func NewJSONServerCodec(conn io.ReadWriteCloser) netrpc.ServerCodec {
+	return &jsonServerCodec{
+		dec: json.NewDecoder(conn),
+		enc: json.NewEncoder(conn),
+		c:   conn,
+		ids: &jsonIDs{ids: make(map[uint64]*json.RawMessage)},
+	}
+}
+
+// ReadRequestHeader implements the rpc.ServerCodec interface.
+func (c *jsonServerCodec) ReadRequestHeader(r *netrpc.Request) error {
+	c.req = jsonRequest{}
+	if err := c.dec.Decode(&c.req); err != nil {
+		if err != io.EOF {
+			// Tries to inform the client before closing the connection.
+			_ = c.enc.Encode(&jsonResponse{
+				Version: JSONVersion,
+				Error:   &jsonError{Code: jsonParseError, Message: err.Error()},
+			})
+		}
+		return err
+	}
+	r.ServiceMethod = c.req.Method
+	r.Seq = c.ids.add(c.req.ID)
+	return nil
+}
+
+// ReadRequestBody implements the rpc.ServerCodec interface.
+// The parameter can be given as is or as the only element of an array.
+func (c *jsonServerCodec) ReadRequestBody(x interface{}) error {
+	if x == nil || c.req.Params == nil {
+		return nil
+	}
+	raw := *c.req.Params
+	if err := json.Unmarshal(raw, x); err == nil {
+		return nil
+	}
+	params := [1]interface{}{x}
+	return json.Unmarshal(raw, &params)
+}
+
+// WriteResponse implements the rpc.ServerCodec interface.
+func (c *jsonServerCodec) WriteResponse(r *netrpc.Response, x interface{}) error {
+	id, ok := c.ids.pop(r.Seq)
+	if !ok {
+		return errors.New("no request with this sequence number")
+	}
+	if id == nil {
+		// Notification.
+		return nil
+	}
+	resp := &jsonResponse{Version: JSONVersion, ID: id}
+	if r.Error == "" {
+		resp.Result = x
+	} else {
+		resp.Error = &jsonError{Code: jsonServerError, Message: r.Error}
+	}
+	return c.enc.Encode(resp)
+}
+
+// Close implements the rpc.ServerCodec interface.
+func (c *jsonServerCodec) Close() error {
+	return c.c.Close()
+}
+
+// jsonClientResponse is a response of the JSON-RPC protocol read by the client.
+type jsonClientResponse struct {
+	Result *json.RawMessage `json:"result"`
+	Error  *jsonError       `json:"error"`
+	ID     uint64           `json:"id"`
+}
+
+// jsonClientCodec implements the JSON-RPC 2.0 protocol on the client side.
+type jsonClientCodec struct {
+	dec  *json.Decoder
+	enc  *json.Encoder
+	c    io.Closer
+	resp jsonClientResponse
+	mu   sync.Mutex
+}
+
+// NewJSONClientCodec returns a new rpc.ClientCodec using JSON-RPC 2.0 on conn.
+func NewJSONClientCodec(conn io.ReadWriteCloser) netrpc.ClientCodec {
+	return &jsonClientCodec{
+		dec: json.NewDecoder(conn),
+		enc: json.NewEncoder(conn),
+		c:   conn,
+	}
+}
+
+// WriteRequest implements the rpc.ClientCodec interface.
+func (c *jsonClientCodec) WriteRequest(r *netrpc.Request, param interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(&struct {
+		Version string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params"`
+		ID      uint64      `json:"id"`
+	}{
+		Version: JSONVersion,
+		Method:  r.ServiceMethod,
+		Params:  param,
+		ID:      r.Seq,
+	})
+}
+
+// ReadResponseHeader implements the rpc.ClientCodec interface.
+func (c *jsonClientCodec) ReadResponseHeader(r *netrpc.Response) error {
+	c.resp = jsonClientResponse{}
+	if err := c.dec.Decode(&c.resp); err != nil {
+		return err
+	}
+	r.Seq = c.resp.ID
+	if c.resp.Error != nil {
+		r.Error = c.resp.Error.Message
+		if r.Error == "" {
+			r.Error = "unspecified error"
+		}
+	}
+	return nil
+}
+
+// ReadResponseBody implements the rpc.ClientCodec interface.
+func (c *jsonClientCodec) ReadResponseBody(x interface{}) error {
+	if x == nil || c.resp.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(*c.resp.Result, x)
+}
+
+// Close implements the rpc.ClientCodec interface.
+func (c *jsonClientCodec) Close() error {
+	return c.c.Close()
+}
+
+// List of kinds of value used to keep their type in JSON.
+const (
+	kindBool   = "bool"
+	kindFloat  = "float"
+	kindInt    = "int"
+	kindList   = "list"
+	kindString = "string"
+)
+
+// jsonItem is the JSON representation of an Item.
+// Kind is the type of the value. Without it, a number without
+// fraction or exponent is decoded as an integer.
+type jsonItem struct {
+	Namespace  string          `json:"ns,omitempty"`
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	Kind       string          `json:"kind,omitempty"`
+	Version    int64           `json:"version,omitempty"`
+	Expiration *time.Time      `json:"expiration,omitempty"`
+	TTL        time.Duration   `json:"ttl,omitempty"`
+	Prev       *Item           `json:"prev,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (i *Item) MarshalJSON() ([]byte, error) {
+	v, err := json.Marshal(i.Value)
+	if err != nil {
+		return nil, err
+	}
+	d := &jsonItem{
+		Namespace: i.Namespace,
+		Key:       i.Key,
+		Value:     v,
+		Version:   i.Version,
+		TTL:       i.TTL,
+		Prev:      i.Prev,
+	}
+	switch i.Value.(type) {
+	case bool:
+		d.Kind = kindBool
+	case float32, float64:
+		d.Kind = kindFloat
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		d.Kind = kindInt
+	case []string:
+		d.Kind = kindList
+	case string:
+		d.Kind = kindString
+	}
+	if !i.Expiration.IsZero() {
+		d.Expiration = &i.Expiration
+	}
+	return json.Marshal(d)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (i *Item) UnmarshalJSON(data []byte) error {
+	var d jsonItem
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	*i = Item{Namespace: d.Namespace, Key: d.Key, Version: d.Version, TTL: d.TTL, Prev: d.Prev}
+	if d.Expiration != nil {
+		i.Expiration = *d.Expiration
+	}
+	if len(d.Value) == 0 {
+		return nil
+	}
+	switch d.Kind {
+	case kindBool:
+		var v bool
+		if err := json.Unmarshal(d.Value, &v); err != nil {
+			return err
+		}
+		i.Value = v
+	case kindFloat:
+		var v float64
+		if err := json.Unmarshal(d.Value, &v); err != nil {
+			return err
+		}
+		i.Value = v
+	case kindInt:
+		var v int
+		if err := json.Unmarshal(d.Value, &v); err != nil {
+			return err
+		}
+		i.Value = v
+	case kindList:
+		var v []string
+		if err := json.Unmarshal(d.Value, &v); err != nil {
+			return err
+		}
+		i.Value = v
+	case kindString:
+		var v string
+		if err := json.Unmarshal(d.Value, &v); err != nil {
+			return err
+		}
+		i.Value = v
+	case "":
+		dec := json.NewDecoder(bytes.NewReader(d.Value))
+		dec.UseNumber()
+		if err := dec.Decode(&i.Value); err != nil {
+			return err
+		}
+		if n, ok := i.Value.(json.Number); ok {
+			var err error
+			i.Value, err = number(n)
+			return err
+		}
+	default:
+		return ErrUnexpected
+	}
+	return nil
+}
+
+// number returns the number as integer if it has no fraction or exponent,
+// as float otherwise.
+func number(n json.Number) (interface{}, error) {
+	if strings.ContainsAny(n.String(), ".eE") {
+		return n.Float64()
+	}
+	v, err := n.Int64()
+	return int(v), err
+}
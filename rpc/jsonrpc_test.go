@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"encoding/json"
+	"net"
+	netrpc "net/rpc"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rvflash/eve/rpc"
+)
+
+func TestItemJSON(t *testing.T) {
+	exp := time.Date(2017, 10, 2, 0, 0, 0, 0, time.UTC)
+	var dt = []struct {
+		in  *rpc.Item
+		raw string
+		out *rpc.Item
+	}{
+		{in: &rpc.Item{Key: "i", Value: 1}, out: &rpc.Item{Key: "i", Value: 1}},
+		{in: &rpc.Item{Key: "f", Value: 1.0}, out: &rpc.Item{Key: "f", Value: 1.0}},
+		{in: &rpc.Item{Key: "b", Value: true}, out: &rpc.Item{Key: "b", Value: true}},
+		{in: &rpc.Item{Key: "s", Value: "rv"}, out: &rpc.Item{Key: "s", Value: "rv"}},
+		{in: &rpc.Item{Key: "l", Value: []string{"a", "b"}}, out: &rpc.Item{Key: "l", Value: []string{"a", "b"}}},
+		{in: &rpc.Item{Key: "n"}, out: &rpc.Item{Key: "n"}},
+		{in: &rpc.Item{Key: "e", Value: 1, Expiration: exp}, out: &rpc.Item{Key: "e", Value: 1, Expiration: exp}},
+		{
+			in:  &rpc.Item{Key: "p", Value: 2, Version: 2, Expiration: exp, Prev: &rpc.Item{Key: "p", Value: 1, Version: 1}},
+			out: &rpc.Item{Key: "p", Value: 2, Version: 2, Expiration: exp, Prev: &rpc.Item{Key: "p", Value: 1, Version: 1}},
+		},
+		{raw: `{"key":"i","value":42}`, out: &rpc.Item{Key: "i", Value: 42}},
+		{raw: `{"key":"f","value":4.2}`, out: &rpc.Item{Key: "f", Value: 4.2}},
+		{raw: `{"key":"f","value":42,"kind":"float"}`, out: &rpc.Item{Key: "f", Value: 42.0}},
+		{raw: `{"key":"x","value":42,"kind":"oops"}`},
+	}
+	for i, tt := range dt {
+		raw := []byte(tt.raw)
+		if tt.in != nil {
+			var err error
+			if raw, err = json.Marshal(tt.in); err != nil {
+				t.Fatalf("%d. unexpected error: got=%q", i, err)
+			}
+		}
+		out := &rpc.Item{}
+		if err := json.Unmarshal(raw, out); (err != nil) != (tt.out == nil) {
+			t.Fatalf("%d. error mismatch: got=%q", i, err)
+		} else if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("%d. content mismatch: exp=%#v got=%#v", i, tt.out, out)
+		}
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+
+	srv := netrpc.NewServer()
+	if err := srv.RegisterName("Cache", c); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	sc, cc := net.Pipe()
+	go srv.ServeCodec(rpc.NewJSONServerCodec(sc))
+	cli := netrpc.NewClientWithCodec(rpc.NewJSONClientCodec(cc))
+	defer func() { _ = cli.Close() }()
+
+	var ok bool
+	if err := cli.Call("Cache.Put", &rpc.Item{Key: "rv", Value: 42}, &ok); err != nil || !ok {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	var item rpc.Item
+	if err := cli.Call("Cache.Get", "rv", &item); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if item.Value != 42 {
+		t.Errorf("content mismatch: exp=42 got=%#v", item.Value)
+	}
+	if err := cli.Call("Cache.Get", "oops", &item); err == nil || err.Error() != rpc.ErrNotFound.Error() {
+		t.Errorf("error mismatch: exp=%q got=%q", rpc.ErrNotFound, err)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"time"
+)
+
+// DigestSize is the number of leaves used to split the key space.
+const DigestSize = 256
+
+// TombstoneTTL is the duration to keep a deleted data in order
+// to propagate its deletion to the peers.
+var TombstoneTTL = 24 * time.Hour
+
+// Digest is a Merkle-style summary of the key space.
+// Each leaf is the hash of all the data whose key belongs to it,
+// and the root is the hash of all the leaves.
+type Digest struct {
+	Root   uint64
+	Leaves []uint64
+}
+
+// Caller must be implemented by any client to call a peer's service.
+type Caller interface {
+	Call(service string, args, reply interface{}) error
+}
+
+// Digest returns the digest of the key space.
+func (c *Cache) Digest(skip bool, reply *Digest) error {
+	defer c.lat.observe("Digest", time.Now())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purge()
+	*reply = c.digest()
+	return nil
+}
+
+// Range returns all the data, tombstones included, belonging to the leaf.
+// The loaded data are ignored. An expiring data comes with the permanent one it replaces.
+func (c *Cache) Range(leaf int, reply *[]*Item) error {
+	defer c.lat.observe("Range", time.Now())
+	if leaf < 0 || leaf >= DigestSize {
+		return ErrUnexpected
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := make([]*Item, 0)
+	for ns, sp := range c.data {
+		for k, e := range sp.data {
+			if leafOf(k) != leaf || e.loaded() {
+				continue
+			}
+			i := &Item{Namespace: ns, Key: k, Value: e.value, Version: e.ts}
+			if e.exp > 0 {
+				i.Expiration = time.Unix(0, e.exp)
+			}
+			if e.prev != nil && !e.prev.loaded() {
+				i.Prev = &Item{Namespace: ns, Key: k, Value: e.prev.value, Version: e.prev.ts}
+			}
+			items = append(items, i)
+		}
+	}
+	*reply = items
+	return nil
+}
+
+// Pull compares the local digest with the one of the peer and retrieves
+// the data of each different leaf to apply the missing or newer ones.
+// It returns the number of changes applied.
+func (c *Cache) Pull(peer Caller) (n int, err error) {
+	var remote Digest
+	if err = peer.Call("Cache.Digest", true, &remote); err != nil {
+		return
+	}
+	if len(remote.Leaves) != DigestSize {
+		return 0, ErrUnexpected
+	}
+	local := &Digest{}
+	if err = c.Digest(true, local); err != nil {
+		return
+	}
+	if local.Root == remote.Root {
+		// Already in sync.
+		return
+	}
+	for i, h := range remote.Leaves {
+		if local.Leaves[i] == h {
+			continue
+		}
+		var items []*Item
+		if err = peer.Call("Cache.Range", i, &items); err != nil {
+			return
+		}
+		n += c.merge(items)
+	}
+	return
+}
+
+// merge applies the items missing locally or more recent than the local ones,
+// or replacing loaded ones. An expired item gives way to the permanent one it replaced.
+// It returns the number of changes applied.
+func (c *Cache) merge(items []*Item) (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	expired := now.Add(-TombstoneTTL).UnixNano()
+	for _, i := range items {
+		if i.Value == nil && i.Version < expired {
+			// Tombstone to purge.
+			continue
+		}
+		exp := i.expires(now)
+		if exp > 0 && exp <= now.UnixNano() {
+			// Expired data.
+			if i.Prev == nil {
+				continue
+			}
+			i, exp = i.Prev, 0
+		}
+		sp := c.space(i.Namespace)
+		e, ok := sp.data[i.Key]
+		if ok && !e.loaded() && (e.ts >= i.Version || (e.exp == exp && same(e.value, i.Value))) {
+			continue
+		}
+		c.set(i.Namespace, i.Key, i.Value, i.Version, exp)
+		if exp > 0 && i.Prev != nil {
+			// Restores the same permanent data than the peer once expired.
+			sp.data[i.Key].prev = &entry{value: i.Prev.Value, ts: i.Prev.Version}
+		}
+		n++
+	}
+	if n > 0 {
+		c.stats.Revision++
+	}
+	return
+}
+
+// digest computes the digest of the key space, without the loaded data.
+// The caller must hold the lock.
+func (c *Cache) digest() Digest {
+	d := Digest{Leaves: make([]uint64, DigestSize)}
+	for ns, sp := range c.data {
+		for k, e := range sp.data {
+			if e.loaded() {
+				continue
+			}
+			// XOR is used to not depend on the order of the keys.
+			d.Leaves[leafOf(k)] ^= hashOf(ns, k, e.value, e.exp)
+		}
+	}
+	h := fnv.New64a()
+	b := make([]byte, 8)
+	for _, v := range d.Leaves {
+		binary.BigEndian.PutUint64(b, v)
+		_, _ = h.Write(b)
+	}
+	d.Root = h.Sum64()
+	return d
+}
+
+// purge removes the expired tombstones.
+// The caller must hold the lock.
+func (c *Cache) purge() {
+	expired := time.Now().Add(-TombstoneTTL).UnixNano()
+	for _, sp := range c.data {
+		for k, e := range sp.data {
+			if e.deleted() && e.ts < expired {
+				delete(sp.data, k)
+			}
+		}
+	}
+}
+
+// leafOf returns the leaf of the digest to which belongs the key.
+func leafOf(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % DigestSize)
+}
+
+// hashOf returns the hash of the data.
+// Values are compared by their representation to ignore the differences
+// of type due to the encoding, like an integer decoded as float.
+func hashOf(ns, key string, value interface{}, exp int64) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(ns))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(key))
+	if value != nil {
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(fmt.Sprint(value)))
+	}
+	if exp > 0 {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(exp))
+		_, _ = h.Write(b)
+	}
+	return h.Sum64()
+}
+
+// same returns true if the both values are equivalent.
+func same(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b) || fmt.Sprint(a) == fmt.Sprint(b)
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rvflash/eve/rpc"
+)
+
+// peer fakes a remote cache by calling directly the local instance.
+type peer struct {
+	c *rpc.Cache
+}
+
+// Call implements the rpc.Caller interface.
+func (p *peer) Call(service string, args, reply interface{}) error {
+	switch service {
+	case "Cache.Digest":
+		return p.c.Digest(args.(bool), reply.(*rpc.Digest))
+	case "Cache.Range":
+		return p.c.Range(args.(int), reply.(*[]*rpc.Item))
+	}
+	return errors.New("unknown service")
+}
+
+func TestCachePull(t *testing.T) {
+	var ok bool
+	a, b := rpc.New(), rpc.New()
+	if err := a.Bulk([]*rpc.Item{{Key: "x", Value: 1}, {Key: "y", Value: 2}}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := b.Bulk([]*rpc.Item{{Key: "y", Value: 3}}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	// Checks the value of the key in the cache.
+	value := func(c *rpc.Cache, key string) interface{} {
+		i := &rpc.Item{}
+		if err := c.Get(key, i); err != nil {
+			return nil
+		}
+		return i.Value
+	}
+	// Checks the number of changes applied by the pull.
+	pull := func(to, from *rpc.Cache, exp int) {
+		if n, err := to.Pull(&peer{from}); err != nil {
+			t.Fatalf("unexpected error: got=%q", err)
+		} else if n != exp {
+			t.Fatalf("changes mismatch: exp=%d got=%d", exp, n)
+		}
+	}
+	pull(b, a, 1)
+	if v := value(b, "x"); v != 1 {
+		t.Fatalf("content mismatch for x: exp=1 got=%v", v)
+	}
+	if v := value(b, "y"); v != 3 {
+		t.Fatalf("newer data must be kept: exp=3 got=%v", v)
+	}
+	pull(a, b, 1)
+	if v := value(a, "y"); v != 3 {
+		t.Fatalf("content mismatch for y: exp=3 got=%v", v)
+	}
+	pull(a, b, 0)
+
+	// Propagates a deletion.
+	time.Sleep(time.Millisecond)
+	if err := a.Delete("x", &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	pull(b, a, 1)
+	if v := value(b, "x"); v != nil {
+		t.Fatalf("deleted data mismatch: got=%v", v)
+	}
+	da, db := &rpc.Digest{}, &rpc.Digest{}
+	_ = a.Digest(true, da)
+	_ = b.Digest(true, db)
+	if da.Root != db.Root {
+		t.Fatal("expected caches in sync")
+	}
+	m := &rpc.Metrics{}
+	if _ = b.Stats(true, m); m.Items != 1 {
+		t.Fatalf("items mismatch: exp=1 got=%d", m.Items)
+	}
+}
+
+func TestCachePullLoaded(t *testing.T) {
+	var ok bool
+	a, b := rpc.New(), rpc.New()
+	defer func() { _ = a.Close() }()
+	defer func() { _ = b.Close() }()
+
+	// The data loaded from an URL never replace the deployed ones.
+	a.Sync(map[string]interface{}{"ALPHA_X": "loaded", "ALPHA_Y": "loaded"}, nil)
+	time.Sleep(time.Millisecond)
+	s := rpc.NewSession(b, nil)
+	if err := s.Use("alpha", &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if err := s.Bulk([]*rpc.Item{{Key: "ALPHA_X", Value: "deployed"}}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if n, err := b.Pull(&peer{a}); err != nil || n != 0 {
+		t.Fatalf("changes mismatch: exp=0 got=%d (%v)", n, err)
+	}
+	if n, err := a.Pull(&peer{b}); err != nil || n != 1 {
+		t.Fatalf("changes mismatch: exp=1 got=%d (%v)", n, err)
+	}
+	// The permanent data replaced by an expiring one is replicated with it.
+	if err := s.Bulk([]*rpc.Item{{Key: "ALPHA_Z", Value: "permanent"}}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := s.Put(&rpc.Item{Key: "ALPHA_Z", Value: "temporary", TTL: 20 * time.Millisecond}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if n, err := a.Pull(&peer{b}); err != nil || n != 1 {
+		t.Fatalf("changes mismatch: exp=1 got=%d (%v)", n, err)
+	}
+	var dt = []struct {
+		key         string
+		value, then interface{}
+	}{
+		{key: "ALPHA_X", value: "deployed", then: "deployed"},
+		{key: "ALPHA_Y", value: "loaded", then: "loaded"},
+		{key: "ALPHA_Z", value: "temporary", then: "permanent"},
+	}
+	for i, tt := range dt {
+		item := &rpc.Item{}
+		if _ = a.Get(tt.key, item); item.Value != tt.value {
+			t.Errorf("%d. content mismatch for %s: exp=%v got=%v", i, tt.key, tt.value, item.Value)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	for i, tt := range dt {
+		item := &rpc.Item{}
+		if _ = a.Get(tt.key, item); item.Value != tt.then {
+			t.Errorf("%d. content mismatch once expired for %s: exp=%v got=%v", i, tt.key, tt.then, item.Value)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrUnauthorized is triggered when the session has not
+// the access level required by the method.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// List of access levels.
+const (
+	NoAccess Access = iota
+	ReadAccess
+	WriteAccess
+)
+
+// Access is the level of access granted to a session.
+// The write access includes the read one.
+type Access int
+
+// Grant is the access given by a token, limited to some namespaces if any.
+// Without namespace, the token gives access to all of them, and to the replication.
+type Grant struct {
+	Access     Access
+	Namespaces []string
+}
+
+// allows returns true if the grant gives access to the namespace.
+func (g Grant) allows(ns string) bool {
+	if len(g.Namespaces) == 0 {
+		return true
+	}
+	for _, v := range g.Namespaces {
+		if v == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// Tokens lists the access granted by token.
+type Tokens map[string]Grant
+
+// ParseTokens reads a list of tokens, one by line, in the form of "read:token"
+// or "write:token". The access can be limited to some namespaces,
+// like "read@alpha,beta:token". Empty lines and lines starting with # are ignored.
+func ParseTokens(r io.Reader) (Tokens, error) {
+	t := make(Tokens)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		d := strings.SplitN(line, ":", 2)
+		if len(d) != 2 || d[1] == "" {
+			return nil, ErrUnexpected
+		}
+		var g Grant
+		if p := strings.Index(d[0], "@"); p > 0 {
+			for _, ns := range strings.Split(d[0][p+1:], ",") {
+				if ns = strings.TrimSpace(ns); ns == "" {
+					return nil, ErrUnexpected
+				}
+				g.Namespaces = append(g.Namespaces, ns)
+			}
+			d[0] = d[0][:p]
+		}
+		switch d[0] {
+		case "read":
+			g.Access = ReadAccess
+		case "write":
+			g.Access = WriteAccess
+		default:
+			return nil, ErrUnexpected
+		}
+		t[d[1]] = g
+	}
+	return t, s.Err()
+}
+
+// Session exposes the methods of the cache to one client, with access control.
+// A read access is required to get data, a write one to change them.
+// Without token, the session has the write access.
+// The data are scoped to the namespace of the session, the default one until Use,
+// and only available if the token grants access to it.
+// From the default namespace, the data of the others are only read if the token grants access to them.
+type Session struct {
+	c      *Cache
+	tokens Tokens
+	grant  Grant
+	ns     string
+	mu     sync.RWMutex
+}
+
+// NewSession returns a new Session on the cache.
+func NewSession(c *Cache, tokens Tokens) *Session {
+	s := &Session{c: c, tokens: tokens}
+	if len(tokens) == 0 {
+		s.grant = Grant{Access: WriteAccess}
+	}
+	return s
+}
+
+// Auth authenticates the session with the given token.
+// It acknowledges the boolean if it succeeds.
+func (s *Session) Auth(token string, ack *bool) error {
+	g, ok := s.tokens[token]
+	if !ok {
+		return ErrUnauthorized
+	}
+	s.mu.Lock()
+	s.grant = g
+	s.mu.Unlock()
+	*ack = true
+	return nil
+}
+
+// Use changes the namespace of the session, if its token grants access to it.
+// It acknowledges the boolean if it succeeds.
+func (s *Session) Use(ns string, ack *bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.grant.Access < ReadAccess || !s.grant.allows(ns) {
+		return ErrUnauthorized
+	}
+	s.ns = ns
+	*ack = true
+	return nil
+}
+
+// Bulk requires a write access to call Cache.Bulk in the namespace.
+func (s *Session) Bulk(batch []*Item, ack *bool) error {
+	if err := s.allow(WriteAccess); err != nil {
+		return err
+	}
+	return s.c.bulk(s.namespace(), batch, ack)
+}
+
+// Clear requires a write access to call Cache.Clear in the namespace.
+func (s *Session) Clear(skip bool, ack *bool) error {
+	if err := s.allow(WriteAccess); err != nil {
+		return err
+	}
+	return s.c.clear(s.namespace(), ack)
+}
+
+// Delete requires a write access to call Cache.Delete in the namespace.
+func (s *Session) Delete(key string, ack *bool) error {
+	if err := s.allow(WriteAccess); err != nil {
+		return err
+	}
+	return s.c.delete(s.namespace(), key, ack)
+}
+
+// Digest requires a read access on all the namespaces to call Cache.Digest.
+func (s *Session) Digest(skip bool, reply *Digest) error {
+	if err := s.allowAll(ReadAccess); err != nil {
+		return err
+	}
+	return s.c.Digest(skip, reply)
+}
+
+// Get requires a read access to call Cache.Get in the namespace.
+func (s *Session) Get(key string, resp *Item) error {
+	if err := s.allow(ReadAccess); err != nil {
+		return err
+	}
+	return s.c.get(s.namespace(), key, resp, s.allows)
+}
+
+// List requires a read access to call Cache.List in the namespace.
+func (s *Session) List(prefix string, reply *[]*Item) error {
+	if err := s.allow(ReadAccess); err != nil {
+		return err
+	}
+	return s.c.list(s.namespace(), prefix, reply, s.allows)
+}
+
+// MultiGet requires a read access to call Cache.MultiGet in the namespace.
+func (s *Session) MultiGet(keys []string, reply *[]*Item) error {
+	if err := s.allow(ReadAccess); err != nil {
+		return err
+	}
+	return s.c.multiGet(s.namespace(), keys, reply, s.allows)
+}
+
+// Put requires a write access to call Cache.Put in the namespace.
+func (s *Session) Put(item *Item, ack *bool) error {
+	if err := s.allow(WriteAccess); err != nil {
+		return err
+	}
+	return s.c.put(s.namespace(), item, ack)
+}
+
+// Range requires a read access on all the namespaces to call Cache.Range.
+func (s *Session) Range(leaf int, reply *[]*Item) error {
+	if err := s.allowAll(ReadAccess); err != nil {
+		return err
+	}
+	return s.c.Range(leaf, reply)
+}
+
+// Stats requires a read access to call Cache.Stats in the namespace.
+// From the default namespace, only the usage of the namespaces granted by the token is given.
+func (s *Session) Stats(all bool, data *Metrics) error {
+	if err := s.allow(ReadAccess); err != nil {
+		return err
+	}
+	return s.c.statsOf(s.namespace(), data, s.allows)
+}
+
+// namespace returns the namespace of the session.
+func (s *Session) namespace() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ns
+}
+
+// allow returns an error if the session has not the required access on its namespace.
+func (s *Session) allow(level Access) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.grant.Access < level || !s.grant.allows(s.ns) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// allows returns true if the token of the session grants access to the namespace.
+func (s *Session) allows(ns string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.grant.allows(ns)
+}
+
+// allowAll returns an error if the session has not the required access on all the namespaces.
+func (s *Session) allowAll(level Access) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.grant.Access < level || len(s.grant.Namespaces) > 0 {
+		return ErrUnauthorized
+	}
+	return nil
+}
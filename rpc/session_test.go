@@ -0,0 +1,302 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rvflash/eve/rpc"
+)
+
+func TestParseTokens(t *testing.T) {
+	var dt = []struct {
+		in    string
+		out   rpc.Tokens
+		onErr bool
+	}{
+		{in: "", out: rpc.Tokens{}},
+		{
+			in:  "# comment\n\nread:r1\nwrite:w1\n",
+			out: rpc.Tokens{"r1": {Access: rpc.ReadAccess}, "w1": {Access: rpc.WriteAccess}},
+		},
+		{
+			in: "read@alpha:r1\nwrite@alpha, beta:w1:x\n",
+			out: rpc.Tokens{
+				"r1":   {Access: rpc.ReadAccess, Namespaces: []string{"alpha"}},
+				"w1:x": {Access: rpc.WriteAccess, Namespaces: []string{"alpha", "beta"}},
+			},
+		},
+		{in: "read@:r1", onErr: true},
+		{in: "admin:a1", onErr: true},
+		{in: "read:", onErr: true},
+		{in: "r1", onErr: true},
+	}
+	for i, tt := range dt {
+		out, err := rpc.ParseTokens(strings.NewReader(tt.in))
+		if tt.onErr != (err != nil) {
+			t.Fatalf("%d. error mismatch: error expected=%t got=%q", i, tt.onErr, err)
+		}
+		if !reflect.DeepEqual(out, tt.out) && !tt.onErr {
+			t.Errorf("%d. content mismatch: exp=%v got=%v", i, tt.out, out)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+	tokens := rpc.Tokens{"r1": {Access: rpc.ReadAccess}, "w1": {Access: rpc.WriteAccess}}
+
+	// Checks the access to read and write the data.
+	access := func(s *rpc.Session) (read, write bool) {
+		var ok bool
+		write = s.Put(&rpc.Item{Key: "k", Value: 1}, &ok) == nil
+		read = s.Stats(true, &rpc.Metrics{}) == nil
+		return
+	}
+	var dt = []struct {
+		tokens      rpc.Tokens
+		token       string
+		read, write bool
+		onErr       bool
+	}{
+		{read: true, write: true},
+		{tokens: tokens},
+		{tokens: tokens, token: "oops", onErr: true},
+		{tokens: tokens, token: "r1", read: true},
+		{tokens: tokens, token: "w1", read: true, write: true},
+	}
+	for i, tt := range dt {
+		s := rpc.NewSession(c, tt.tokens)
+		if tt.token != "" {
+			var ok bool
+			if err := s.Auth(tt.token, &ok); tt.onErr != (err != nil) {
+				t.Fatalf("%d. error mismatch: error expected=%t got=%q", i, tt.onErr, err)
+			}
+		}
+		if read, write := access(s); read != tt.read || write != tt.write {
+			t.Errorf("%d. access mismatch: exp=%t/%t got=%t/%t", i, tt.read, tt.write, read, write)
+		}
+	}
+}
+
+func TestSessionGrant(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+	tokens := rpc.Tokens{
+		"a1":  {Access: rpc.WriteAccess, Namespaces: []string{"alpha"}},
+		"all": {Access: rpc.ReadAccess},
+	}
+	var dt = []struct {
+		token, ns  string
+		use, write bool
+		replica    bool
+	}{
+		{token: "a1", ns: "alpha", use: true, write: true},
+		{token: "a1", ns: "beta"},
+		{token: "a1", ns: rpc.DefaultNamespace},
+		{token: "all", ns: "beta", use: true, replica: true},
+	}
+	for i, tt := range dt {
+		s := rpc.NewSession(c, tokens)
+		var ok bool
+		if err := s.Auth(tt.token, &ok); err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		if err := s.Use(tt.ns, &ok); (err == nil) != tt.use {
+			t.Errorf("%d. use mismatch: exp=%t got=%v", i, tt.use, err)
+		}
+		if err := s.Put(&rpc.Item{Key: "k", Value: 1}, &ok); (err == nil) != tt.write {
+			t.Errorf("%d. write mismatch: exp=%t got=%v", i, tt.write, err)
+		}
+		if err := s.Range(0, &[]*rpc.Item{}); (err == nil) != tt.replica {
+			t.Errorf("%d. replica mismatch: exp=%t got=%v", i, tt.replica, err)
+		}
+	}
+}
+
+func TestSessionNamespace(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+	c.SetQuota("beta", 1)
+
+	var ok bool
+	a, b := rpc.NewSession(c, nil), rpc.NewSession(c, nil)
+	if err := a.Use("alpha", &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if err := b.Use("beta", &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if err := a.Bulk([]*rpc.Item{{Key: "x", Value: 1}, {Key: "y", Value: 2}}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if err := b.Bulk([]*rpc.Item{{Key: "x", Value: 3}}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if err := b.Put(&rpc.Item{Key: "y", Value: 4}, &ok); err != rpc.ErrQuota {
+		t.Fatalf("error mismatch: exp=%q got=%q", rpc.ErrQuota, err)
+	}
+	// Checks the value of the key in the namespace of the session.
+	value := func(s interface {
+		Get(key string, resp *rpc.Item) error
+	}, key string) interface{} {
+		i := &rpc.Item{}
+		if err := s.Get(key, i); err != nil {
+			return nil
+		}
+		return i.Value
+	}
+	var dt = []struct {
+		a, b, c interface{}
+	}{
+		{a: 1, b: 3},
+		{a: 2},
+	}
+	for i, tt := range dt {
+		k := []string{"x", "y"}[i]
+		if v := value(a, k); v != tt.a {
+			t.Errorf("%d. alpha content mismatch: exp=%v got=%v", i, tt.a, v)
+		}
+		if v := value(b, k); v != tt.b {
+			t.Errorf("%d. beta content mismatch: exp=%v got=%v", i, tt.b, v)
+		}
+		if v := value(c, k); v != tt.c {
+			t.Errorf("%d. default content mismatch: exp=%v got=%v", i, tt.c, v)
+		}
+	}
+	// Clears only one namespace.
+	if err := b.Clear(true, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	if v := value(a, "x"); v != 1 {
+		t.Errorf("alpha content mismatch: exp=1 got=%v", v)
+	}
+	m := &rpc.Metrics{}
+	if _ = a.Stats(true, m); m.Items != 2 {
+		t.Errorf("alpha items mismatch: exp=2 got=%d", m.Items)
+	}
+	if _ = c.Stats(true, m); m.Items != 2 || len(m.Namespaces) != 2 {
+		t.Errorf("stats mismatch: exp=2 items in 2 namespaces got=%d in %d", m.Items, len(m.Namespaces))
+	}
+	if u := m.Namespaces["beta"]; u == nil || u.Items != 0 || u.Quota != 1 {
+		t.Errorf("beta usage mismatch: got=%v", u)
+	}
+}
+
+func TestSessionFallback(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+
+	var ok bool
+	for _, ns := range []string{"alpha", "beta"} {
+		s := rpc.NewSession(c, nil)
+		if err := s.Use(ns, &ok); err != nil {
+			t.Fatalf("unexpected error: got=%q", err)
+		}
+		key := strings.ToUpper(ns) + "_X"
+		if err := s.Put(&rpc.Item{Key: key, Value: ns}, &ok); err != nil {
+			t.Fatalf("unexpected error: got=%q", err)
+		}
+	}
+	// The default namespace takes precedence.
+	if err := c.Put(&rpc.Item{Key: "BETA_X", Value: "default"}, &ok); err != nil {
+		t.Fatalf("unexpected error: got=%q", err)
+	}
+	tokens := rpc.Tokens{
+		"d1": {Access: rpc.ReadAccess, Namespaces: []string{rpc.DefaultNamespace}},
+		"da": {Access: rpc.ReadAccess, Namespaces: []string{rpc.DefaultNamespace, "alpha"}},
+	}
+	var dt = []struct {
+		tokens      rpc.Tokens
+		token       string
+		alpha, beta interface{}
+		multi, list int
+	}{
+		{alpha: "alpha", beta: "default", multi: 2, list: 1},
+		{tokens: tokens, token: "d1", beta: "default", multi: 1},
+		{tokens: tokens, token: "da", alpha: "alpha", beta: "default", multi: 2, list: 1},
+	}
+	for i, tt := range dt {
+		s := rpc.NewSession(c, tt.tokens)
+		if tt.token != "" {
+			if err := s.Auth(tt.token, &ok); err != nil {
+				t.Fatalf("%d. unexpected error: got=%q", i, err)
+			}
+		}
+		var alpha, beta interface{}
+		it := &rpc.Item{}
+		if err := s.Get("ALPHA_X", it); err == nil {
+			alpha = it.Value
+		}
+		if err := s.Get("BETA_X", it); err == nil {
+			beta = it.Value
+		}
+		if alpha != tt.alpha || beta != tt.beta {
+			t.Errorf("%d. content mismatch: exp=%v/%v got=%v/%v", i, tt.alpha, tt.beta, alpha, beta)
+		}
+		var items []*rpc.Item
+		if err := s.MultiGet([]string{"ALPHA_X", "BETA_X"}, &items); err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		if len(items) != tt.multi {
+			t.Errorf("%d. multi-get mismatch: exp=%d got=%d", i, tt.multi, len(items))
+		}
+		if err := s.List("ALPHA_", &items); err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		if len(items) != tt.list {
+			t.Errorf("%d. list mismatch: exp=%d got=%d", i, tt.list, len(items))
+		}
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	c := rpc.New()
+	defer func() { _ = c.Close() }()
+
+	var ok bool
+	for i, ns := range []string{rpc.DefaultNamespace, "alpha", "beta"} {
+		s := rpc.NewSession(c, nil)
+		if err := s.Use(ns, &ok); err != nil {
+			t.Fatalf("unexpected error: got=%q", err)
+		}
+		if err := s.Bulk([]*rpc.Item{{Key: "x", Value: i}, {Key: "y", Value: i}}, &ok); err != nil {
+			t.Fatalf("unexpected error: got=%q", err)
+		}
+	}
+	tokens := rpc.Tokens{
+		"all": {Access: rpc.ReadAccess},
+		"d1":  {Access: rpc.ReadAccess, Namespaces: []string{rpc.DefaultNamespace}},
+		"da":  {Access: rpc.ReadAccess, Namespaces: []string{rpc.DefaultNamespace, "alpha"}},
+	}
+	var dt = []struct {
+		token string
+		items uint64
+		nss   int
+	}{
+		{token: "all", items: 6, nss: 3},
+		{token: "d1", items: 2, nss: 1},
+		{token: "da", items: 4, nss: 2},
+	}
+	for i, tt := range dt {
+		s := rpc.NewSession(c, tokens)
+		if err := s.Auth(tt.token, &ok); err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		m := &rpc.Metrics{}
+		if err := s.Stats(true, m); err != nil {
+			t.Fatalf("%d. unexpected error: got=%q", i, err)
+		}
+		if m.Items != tt.items || len(m.Namespaces) != tt.nss {
+			t.Errorf("%d. stats mismatch: exp=%d items in %d namespaces got=%d in %d", i, tt.items, tt.nss, m.Items, len(m.Namespaces))
+		}
+		if u := m.Namespaces["beta"]; u != nil && tt.nss < 3 {
+			t.Errorf("%d. beta usage mismatch: got=%v", i, u)
+		}
+	}
+}
@@ -6,18 +6,56 @@ package main
 
 import (
 	"flag"
+	"strings"
+	"time"
 
+	"github.com/rvflash/eve/client"
 	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/keyring"
 )
 
 func main() {
 	host := flag.String("host", "", "host addr to listen on")
 	port := flag.Int("port", 8080, "service port")
 	dsn := flag.String("dsn", "eve.db", "database's file path")
+	cacheCA := flag.String("cache-ca", "", "certificate authority file used to verify the cache servers with TLS")
+	cacheCert := flag.String("cache-cert", "", "client certificate file used to connect to the cache servers")
+	cacheKey := flag.String("cache-key", "", "private key file of the client certificate")
+	cacheToken := flag.String("cache-token", "", "token with write access used to deploy on the cache servers")
+	keys := flag.String("keyring", "", "keyring file used to encrypt the secret variables")
+	retries := flag.Int("retries", 2, "number of new attempts of a deployment on a failing cache server")
+	delay := flag.Duration("retry-delay", 500*time.Millisecond, "delay between two attempts of a deployment")
+	approvalTTL := flag.Duration("approval-ttl", 24*time.Hour, "time to live of a deployment to a protected environment waiting its approval")
+	proxies := flag.String("trusted-proxies", "", "comma-separated list of the addresses of the proxies authenticating the users")
 	flag.Parse()
 
 	// Try to connect to the local database.
 	server := NewServer(*host, *port)
+	if *cacheCA != "" || *cacheCert != "" {
+		cfg, err := client.NewTLSConfig(*cacheCA, *cacheCert, *cacheKey)
+		if err != nil {
+			server.log.Fatalf("fails to load the TLS configuration: %s\n", err)
+		}
+		server.cache = append(server.cache, client.WithTLS(cfg))
+	}
+	if *cacheToken != "" {
+		server.cache = append(server.cache, client.WithToken(*cacheToken))
+	}
+	server.retries, server.delay = *retries, *delay
+	server.approvalTTL = *approvalTTL
+	if *proxies != "" {
+		server.proxies = make(map[string]bool)
+		for _, addr := range strings.Split(*proxies, ",") {
+			server.proxies[strings.TrimSpace(addr)] = true
+		}
+	}
+	if *keys != "" {
+		k, err := keyring.Open(*keys)
+		if err != nil {
+			server.log.Fatalf("fails to load the keyring: %s\n", err)
+		}
+		server.keys = k
+	}
 	if db, err := db.Open(*dsn); err != nil {
 		server.log.Printf("fails to open the database: %s\n", err)
 	} else {
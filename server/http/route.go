@@ -13,7 +13,6 @@ import (
 
 	"github.com/rvflash/elapsed"
 	"github.com/rvflash/eve/db"
-	"github.com/rvflash/eve/deploy"
 )
 
 var (
@@ -21,7 +20,9 @@ var (
 	tmplPath    = "./html/template"
 	tmplFuncMap = template.FuncMap{
 		// deployment
-		"env": deploy.Key,
+		"env":     envKey,
+		"varid":   varID,
+		"resolve": resolve,
 		// date
 		"elapsed": elapsed.Time,
 		// arithmetic
@@ -31,8 +32,18 @@ var (
 		"div": func(i, j int) float64 { return float64(i) / float64(j) },
 		// strings
 		"join": func(s []string) string { return strings.Join(s, ", ") },
+		"has": func(s []string, v string) bool {
+			for _, d := range s {
+				if d == v {
+					return true
+				}
+			}
+			return false
+		},
 		// interface
 		"null": func(d interface{}) bool { return d == nil },
+		// secrets
+		"mask": mask,
 	}
 )
 
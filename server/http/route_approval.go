@@ -0,0 +1,361 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/deploy"
+)
+
+var (
+	errApproval     = errors.New("no deployment waiting this approval")
+	errSelfApproval = errors.New("the deployment must be approved by another user")
+	errAnonymous    = errors.New("the deployment must be approved by an authenticated user")
+	errAnonymousReq = errors.New("the deployment to a protected environment must be requested by an authenticated user")
+)
+
+// approval is a deployment to protected values of environments, waiting the approval of another user.
+// It is saved in the database with the form of the request, replayed once approved.
+// Without decision, it expires.
+type approval struct {
+	*db.Approval
+	timer *time.Timer
+	// out is the release pushed once approved, busy is true while it is computed again.
+	out  *deploy.Release
+	busy bool
+}
+
+// request saves the release to push on these nodes, waiting its approval.
+// With a rollback, from is the identifier of the deployment to roll back.
+// As for the approval, only the identity of the user given by a trusted proxy is accepted.
+func (s *Server) request(p *db.Project, w []db.Keyer, out *deploy.Release, only []string, from uint64, r *http.Request) error {
+	user := s.user(r)
+	if user == "" {
+		return errAnonymousReq
+	}
+	a := &approval{
+		Approval: &db.Approval{
+			ProjectID:  p.ID,
+			From:       from,
+			Author:     user,
+			EnvsValues: out.EnvsValues(),
+			Protected:  p.Protected(out.EnvsValues()),
+			VarList:    only,
+			ServerList: make([]*db.Server, len(w)),
+			Diff:       pick(out.Diff(), only),
+			Form:       r.Form,
+			Ts:         time.Now(),
+			ExpireTs:   time.Now().Add(s.approvalTTL),
+		},
+		out: out,
+	}
+	for k, v := range w {
+		a.ServerList[k] = &db.Server{TCPAddr: v.(*db.Node).Addr, Type: v.(*db.Node).Type}
+	}
+	if err := s.db.AddApproval(a.Approval); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wait(a)
+	return nil
+}
+
+// wait keeps in memory the approval until its expiration.
+// The caller must hold the lock.
+func (s *Server) wait(a *approval) {
+	a.timer = time.AfterFunc(time.Until(a.ExpireTs), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !a.busy {
+			s.forget(a)
+		}
+	})
+	s.approvals[a.ID] = a
+}
+
+// forget removes the approval, once decided or expired.
+// The caller must hold the lock.
+func (s *Server) forget(a *approval) {
+	a.timer.Stop()
+	delete(s.approvals, a.ID)
+	if err := s.db.DeleteApproval(a.Approval); err != nil {
+		s.log.Printf("fails to delete the approval #%d: %s\n", a.ID, err)
+	}
+}
+
+// reload loads the approvals saved before the restart of the server, the expired ones are removed.
+func (s *Server) reload() {
+	l, err := s.db.Approvals()
+	if err != nil {
+		s.log.Printf("fails to list the approvals: %s\n", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range l {
+		if _, err = s.db.GetProject(a.ProjectID); err != nil || a.Expired() {
+			// The project has been deleted since the request or the request expired.
+			if err = s.db.DeleteApproval(a); err != nil {
+				s.log.Printf("fails to delete the approval #%d: %s\n", a.ID, err)
+			}
+			continue
+		}
+		s.wait(&approval{Approval: a})
+	}
+}
+
+// requested returns the approval of the release if it waits one.
+func (s *Server) requested(out *deploy.Release) *approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.approvals {
+		if a.out == out && !a.busy {
+			return a
+		}
+	}
+	return nil
+}
+
+// approved returns the approval of the release if it is pushed once approved.
+func (s *Server) approved(out *deploy.Release) *approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.approvals {
+		if a.out == out && a.busy {
+			return a
+		}
+	}
+	return nil
+}
+
+// pending returns the deployments of the project waiting an approval, by identifier.
+func (s *Server) pending(project string) []*approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []*approval
+	for _, a := range s.approvals {
+		if a.ProjectID == project {
+			res = append(res, a)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// approve computes again the differences of the release waiting this approval, then pushes it.
+// If they changed since the request, the release is not pushed and the names of the changed variables
+// are returned: the approver must check them and approve again.
+func (s *Server) approve(id uint64, r *http.Request) (changed []string, err error) {
+	user := s.user(r)
+	s.mu.Lock()
+	a, ok := s.approvals[id]
+	switch {
+	case !ok || a.busy:
+		err = errApproval
+	case user == "":
+		// Only the identity of the user given by a trusted proxy is checked.
+		err = errAnonymous
+	case a.Author == user:
+		err = errSelfApproval
+	default:
+		a.busy = true
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return
+	}
+	var pushed bool
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if a.busy = false; !pushed && !a.Expired() {
+			// Still waiting.
+			return
+		}
+		s.forget(a)
+	}()
+
+	// Reloads the project, it may have changed since the request.
+	var p db.Keyer
+	if p, err = s.db.GetProject(a.ProjectID); err != nil {
+		return
+	}
+	// A rollback deploys again the items of the previous deployment.
+	var src deploy.Source = p.(*db.Project)
+	var from db.Keyer
+	if a.From > 0 {
+		if from, err = s.db.GetDeploy(a.From); err != nil {
+			return
+		}
+		if src, err = p.(*db.Project).Rollback(from.(*db.Deploy)); err != nil {
+			return
+		}
+	}
+	// The release is built with the form of the request.
+	r.Form = a.Form
+	w := serverNodes(a.ServerList)
+	var out *deploy.Release
+	if out, err = s.release(p.(*db.Project), src, w, r); err != nil {
+		return
+	}
+	if err = out.Checkout(a.EnvsValues...); err != nil {
+		return
+	}
+	diff := pick(out.Diff(), a.VarList)
+	s.mu.Lock()
+	if changed = deploy.Changed(a.Diff, diff); changed == nil {
+		a.out = out
+	} else {
+		a.Diff = diff
+	}
+	s.mu.Unlock()
+	if changed != nil {
+		// The approver checks the new differences.
+		err = s.db.UpdateApproval(a.Approval)
+		return
+	}
+	pushed = true
+	if err = s.push(p.(*db.Project), w, out, a.VarList, r); err != nil || from == nil {
+		return
+	}
+	// The values are restored in the name of the author of the request.
+	return nil, s.restore(p.(*db.Project), from.(*db.Deploy), out, a.Author, r)
+}
+
+// reject removes the deployment waiting this approval.
+// Like the approval, it requires an authenticated user.
+func (s *Server) reject(id uint64, r *http.Request) error {
+	if s.user(r) == "" {
+		return errAnonymous
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.approvals[id]
+	if !ok || a.busy {
+		return errApproval
+	}
+	s.forget(a)
+	return nil
+}
+
+// pick returns the changes of these variables, all of them without names.
+// As for the push, the names are not case sensitive.
+func pick(diff map[string]*deploy.Changes, names []string) map[string]*deploy.Changes {
+	if len(names) == 0 {
+		return diff
+	}
+	res := make(map[string]*deploy.Changes, len(names))
+	for _, n := range names {
+		if c, ok := diff[deploy.Key(n)]; ok {
+			res[deploy.Key(n)] = c
+		}
+	}
+	return res
+}
+
+type approvalTmplVars struct {
+	projectTmplVars
+	Approval *approval
+	// Changed lists the variables whose changes differ since the request.
+	Changed []string
+	// Self is true if the user is the author of the request, Anonymous if the user is unknown,
+	// Done if the deployment does not wait anymore.
+	Self, Anonymous, Done bool
+	Err                   error
+	// Hidden contains the deploy keys of the sensitive variables.
+	Hidden map[string]bool
+}
+
+// ApprovalHandler displays a deployment waiting its approval, approves or rejects it.
+func (s *Server) ApprovalHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	p, err := s.db.GetProject(vars["pid"])
+	if err != nil {
+		s.NotFoundHandler(w, r)
+		return
+	}
+	id, _ := strconv.ParseUint(vars["aid"], 10, 64)
+	s.mu.Lock()
+	a, ok := s.approvals[id]
+	s.mu.Unlock()
+	if !ok || a.ProjectID != vars["pid"] {
+		s.NotFoundHandler(w, r)
+		return
+	}
+
+	// Assigns vars to the templates.
+	tv := approvalTmplVars{Approval: a}
+	tv.Title = p.(*db.Project).Name
+	tv.Href = "/project/" + vars["pid"] + "/"
+	tv.Project = p
+	tv.Anonymous = s.user(r) == ""
+	tv.Self = !tv.Anonymous && a.Author == s.user(r)
+	tv.Hidden = sensitive(p.(*db.Project), a.EnvsValues)
+
+	loc := "/project/" + vars["pid"] + "/deploys"
+	switch vars["do"] {
+	case "":
+	case "approve":
+		if r.Method != http.MethodPost {
+			s.NotFoundHandler(w, r)
+			return
+		}
+		if tv.Changed, tv.Err = s.approve(id, r); tv.Err == nil && tv.Changed == nil {
+			http.Redirect(w, r, loc, http.StatusSeeOther)
+			return
+		}
+	case "reject":
+		if r.Method != http.MethodPost {
+			s.NotFoundHandler(w, r)
+			return
+		}
+		if tv.Err = s.reject(id, r); tv.Err == nil {
+			http.Redirect(w, r, loc, http.StatusSeeOther)
+			return
+		}
+	default:
+		s.NotFoundHandler(w, r)
+		return
+	}
+	s.mu.Lock()
+	_, ok = s.approvals[id]
+	s.mu.Unlock()
+	tv.Done = !ok
+
+	// Builds the page.
+	var t *template.Template
+	t, err = template.New("approval.html").Funcs(tmplFuncMap).ParseFiles(
+		tmplPath+"/approval.html",
+		tmplPath+"/common/form.html",
+		tmplPath+"/common/header.html",
+		tmplPath+"/common/head.html",
+		tmplPath+"/common/foot.html",
+		tmplPath+"/common/footer.html",
+	)
+	if err != nil {
+		s.OopsHandler(w, r, err)
+		return
+	}
+
+	// Displays the page.
+	if err = t.Execute(w, tv); err != nil {
+		s.OopsHandler(w, r, err)
+	}
+}
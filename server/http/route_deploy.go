@@ -72,14 +72,30 @@ type deployTmplVars struct {
 	Step    int
 	Release *deploy.Release
 	Err     error
+	// Tags filters the variables to deploy, Tagged lists their names.
+	Tags, Tagged []string
+	// Hidden contains the names and the deploy keys of the sensitive variables.
+	Hidden map[string]bool
+	// Rollback is the previous deployment to deploy again.
+	Rollback *db.Deploy
+	// Nodes contains the status of the push on each server.
+	Nodes []*db.Server
+	// Canary is the deployment waiting its promotion after a canary push.
+	Canary *db.Deploy
+	// Approval is the deployment to protected values waiting the approval of another user.
+	Approval *approval
 }
 
 // NodeHandler deletes a server node.
 func (s *Server) NodeHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	// Tries to delete this server's address.
-	n := db.NewNode(vars["naddr"])
+	// Tries to delete this server's address, the directories are given in the query string.
+	addr := vars["naddr"]
+	if addr == "" {
+		addr = r.URL.Query().Get("naddr")
+	}
+	n := db.NewNode(addr)
 	if err := s.db.DeleteNode(n); err != nil {
 		s.jsonHandler(w, err.Error(), http.StatusBadRequest)
 		return
@@ -100,6 +116,7 @@ func (s *Server) NodesHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Tries to add this server's address.
 	n := db.NewNode(r.Form.Get("naddr"))
+	n.Type = r.Form.Get("ntype")
 	if err := s.db.AddNode(n); err != nil {
 		s.jsonHandler(w, err.Error(), http.StatusBadRequest)
 		return
@@ -141,6 +158,17 @@ func (s *Server) DeployHandler(w http.ResponseWriter, r *http.Request) {
 	if tv.Servers, tv.Err = s.nodes(); tv.Err == nil {
 		tv.Step, tv.Release, tv.Err = s.deploy(p, tv.Servers, r)
 	}
+	if tv.Step == 2 && tv.Release != nil {
+		tv.Nodes = results(tv.Servers, tv.Release)
+		tv.Canary = s.staged(tv.Release)
+		tv.Approval = s.requested(tv.Release)
+	}
+	if tv.Tags = r.Form["tags"]; len(tv.Tags) > 0 {
+		tv.Tagged = p.(*db.Project).Tagged(tv.Tags...)
+	}
+	if tv.Err == nil && tv.Release != nil {
+		tv.Hidden = hidden(p.(*db.Project), tv.Release)
+	}
 	// Displays the page.
 	if err = t.Execute(w, tv); err != nil {
 		s.OopsHandler(w, r, err)
@@ -161,59 +189,242 @@ func (s *Server) deploy(p db.Keyer, w []db.Keyer, r *http.Request) (
 		return
 	}
 
-	// Checks the project envs to bypass the checkout page.
+	// Checks the values of each environment, named ev1, ev2, etc. in their order.
+	// The default environments bypass the checkout page.
 	project := p.(*db.Project)
-	if project.FirstEnv().Default() {
-		r.Form["ev1"] = []string{""}
+	all := project.EnvsValues()
+	envs := make([][]string, len(all))
+	for i, values := range all {
+		name := "ev" + strconv.Itoa(i+1)
+		if project.Envs()[i].(*db.Env).Default() {
+			r.Form[name] = []string{""}
+		}
+		if len(r.Form[name]) == 0 {
+			// Nothing to checkout
+			return
+		}
+		known := toMap(values)
+		for _, v := range r.Form[name] {
+			if _, ok := known[v]; !ok {
+				return
+			}
+		}
+		envs[i] = r.Form[name]
+	}
+	step = 1
+
+	// Checkout the project and initialize the release.
+	if out, err = s.release(project, project, w, r); err != nil {
+		step = 0
+		return
 	}
-	if project.SecondEnv().Default() {
-		r.Form["ev2"] = []string{""}
+	if err = out.Checkout(envs...); err != nil {
+		return
 	}
-	// Nothing to checkout
-	if len(r.Form["ev1"]) == 0 || len(r.Form["ev2"]) == 0 {
+	if len(r.Form["vars"]) == 0 && r.Form.Get("force") != "1" {
+		// Force push does not required
 		return
 	}
-	// Gets all env values of the project.
-	eev1, eev2 := project.EnvsValues()
-	cev1 := toMap(eev1)
-	for _, v := range r.Form["ev1"] {
-		if _, ok := cev1[v]; !ok {
+	step = 2
+	// With tags, only the variables having one of them can be pushed.
+	only := r.Form["vars"]
+	if tags := r.Form["tags"]; len(tags) > 0 {
+		if only = filter(only, project.Tagged(tags...)); len(only) == 0 {
+			err = deploy.ErrMissing
 			return
 		}
 	}
-	cev2 := toMap(eev2)
-	for _, v := range r.Form["ev2"] {
-		if _, ok := cev2[v]; !ok {
-			return
-		}
+	if len(project.Protected(envs)) > 0 {
+		// The push to a protected environment waits the approval of another user.
+		err = s.request(project, w, out, only, 0, r)
+		return
+	}
+	err = s.push(project, w, out, only, r)
+	return
+}
+
+// rollback prepares the release to deploy again the items of a previous deployment,
+// to the same environments values and servers, then pushes it once the changes chosen.
+// On demand, the values of the variables are also restored in the database,
+// except for a canary push.
+func (s *Server) rollback(p *db.Project, from *db.Deploy, r *http.Request) (
+	step int, out *deploy.Release, err error,
+) {
+	if err = r.ParseForm(); err != nil {
+		return
+	}
+	var src deploy.Source
+	if src, err = p.Rollback(from); err != nil {
+		return
+	}
+	w := serverNodes(from.ServerList)
+	if out, err = s.release(p, src, w, r); err != nil {
+		return
+	}
+	if err = out.Checkout(from.EnvsValues...); err != nil {
+		return
 	}
 	step = 1
+	if len(r.Form["vars"]) == 0 && r.Form.Get("force") != "1" {
+		return
+	}
+	step = 2
+	if len(p.Protected(from.EnvsValues)) > 0 {
+		// Like a deployment, the rollback of a protected environment waits the approval of another user.
+		err = s.request(p, w, out, r.Form["vars"], from.ID, r)
+		return
+	}
+	if err = s.push(p, w, out, r.Form["vars"], r); err != nil {
+		return
+	}
+	err = s.restore(p, from, out, s.author(r), r)
+	return
+}
 
-	// Checkout the project and initialize the release.
-	nodes := make([]deploy.Dest, len(w))
-	for k, v := range w {
-		nodes[k], err = client.OpenRPC(v.(*db.Node).Addr, 500*time.Millisecond)
-		if err != nil {
-			step = 0
-			return
+// restore restores on demand in the database the values of the variables of the deployment rolled back,
+// except for a canary push. The changes are made in the name of this author.
+func (s *Server) restore(p *db.Project, from *db.Deploy, out *deploy.Release, author string, r *http.Request) error {
+	if r.Form.Get("restore") != "1" || out.Staged() {
+		return nil
+	}
+	// The values are restored with the changes made since the deployment.
+	var err error
+	history := make(map[uint64][]*db.Change)
+	for _, d := range p.Vars() {
+		v := d.(*db.Var)
+		if history[v.ID], err = s.db.History(v.ID); err != nil {
+			return err
 		}
 	}
-	force, _ := strconv.Atoi(r.Form.Get("force"))
-	if force == 1 {
+	vars, err := p.Restore(from, history, r.Form["vars"]...)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		v.Author = author
+		if err = s.db.UpdateVarInProject(v, p.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serverNodes returns the nodes of these servers, like the ones of a deployment.
+func serverNodes(servers []*db.Server) []db.Keyer {
+	w := make([]db.Keyer, len(servers))
+	for k, srv := range servers {
+		w[k] = &db.Node{Addr: srv.TCPAddr, Type: srv.Type}
+	}
+	return w
+}
+
+// release returns a new release of the source to deploy on these nodes.
+// Each project is deployed in its own namespace.
+func (s *Server) release(p *db.Project, src deploy.Source, w []db.Keyer, r *http.Request) (*deploy.Release, error) {
+	nodes, err := s.dests(p, w)
+	if err != nil {
+		return nil, err
+	}
+	var offset int
+	if r.Form.Get("force") == "1" {
 		// A force push is required.
 		// Adds a fake destination as main server to do that.
 		nodes = append([]deploy.Dest{deploy.ServerLess}, nodes...)
+		offset = 1
+	}
+	out := s.newRelease(src, nodes)
+
+	// A canary push updates first the chosen nodes or a percentage of them.
+	if cnodes := r.Form["cnodes"]; len(cnodes) > 0 {
+		pos := make([]int, 0, len(cnodes))
+		for _, v := range cnodes {
+			k, err := strconv.Atoi(v)
+			if err != nil || k < 0 || k >= len(w) {
+				return nil, errors.New("invalid canary server: " + v)
+			}
+			pos = append(pos, k+offset)
+		}
+		out.Canary(pos...)
+	} else if pct := r.Form.Get("canary"); pct != "" {
+		k, err := strconv.Atoi(pct)
+		if err != nil {
+			return nil, errors.New("invalid percentage of canary servers: " + pct)
+		}
+		out.CanaryPct(k)
 	}
-	out = deploy.New(project, nodes[0], nodes[1:]...)
-	if err = out.Checkout(r.Form["ev1"], r.Form["ev2"]); err != nil {
+	return out, nil
+}
+
+// dests returns the destinations of the values of the project on these nodes.
+func (s *Server) dests(p *db.Project, w []db.Keyer) ([]deploy.Dest, error) {
+	opts := append([]client.Option{client.WithNamespace(p.ID)}, s.cache...)
+	nodes := make([]deploy.Dest, len(w))
+	for k, v := range w {
+		if f := v.(*db.Node).File(p.ID); f != nil {
+			// This node writes the values in a file.
+			nodes[k] = f
+			continue
+		}
+		var err error
+		if nodes[k], err = client.OpenRPC(v.(*db.Node).Addr, 500*time.Millisecond, opts...); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// newRelease returns a release of the source on these destinations.
+// Each node is checked after the push and tried again on failure.
+func (s *Server) newRelease(src deploy.Source, nodes []deploy.Dest) *deploy.Release {
+	out := deploy.New(src, nodes[0], nodes[1:]...)
+	out.Verify()
+	out.Retry(s.retries, s.delay)
+	return out
+}
+
+// push deploys the release with these variables, records it and saves the pushed values
+// for the loading of the new cache instances.
+func (s *Server) push(project *db.Project, w []db.Keyer, out *deploy.Release, only []string, r *http.Request) (err error) {
+	if ttl := r.Form.Get("ttl"); ttl != "" {
+		// Temporary deployment.
+		var d time.Duration
+		if d, err = time.ParseDuration(ttl); err != nil {
+			return
+		}
+		out.Expire(d)
+	}
+	var bake time.Duration
+	if v := r.Form.Get("bake"); v != "" {
+		// Automatic promotion of the canary push.
+		if bake, err = time.ParseDuration(v); err != nil {
+			return
+		}
+	}
+	start := time.Now()
+	err = out.Push(only...)
+	d, rerr := s.record(project, w, out, time.Since(start), r)
+	if err == nil {
+		err = rerr
+	}
+	if err != nil {
 		return
 	}
-	if len(r.Form["vars"]) == 0 && force == 0 {
-		// Force push does not required
+	if out.Staged() {
+		// The other nodes wait the promotion of the release.
+		s.stage(w, out, d, bake)
 		return
 	}
-	step = 2
-	if err = out.Push(r.Form["vars"]...); err != nil {
+	return s.save(project, out)
+}
+
+// save saves the pushed values for the loading of the new cache instances
+// and updates the last deployment date of the project.
+func (s *Server) save(project *db.Project, out *deploy.Release) (err error) {
+	if out.TTL() > 0 {
+		// Expiring data are not exposed to the cache loaders,
+		// the previous values are restored on their reload.
+		project.LastDeployTs = time.Now()
+		err = s.db.UpsertProject(project)
 		return
 	}
 	// Saves the pushed's vars in a dedicated local JSON file.
@@ -249,6 +460,254 @@ func (s *Server) deploy(p db.Keyer, w []db.Keyer, r *http.Request) (
 	return
 }
 
+// RollbackHandler allows to deploy again a previous deployment of a project.
+func (s *Server) RollbackHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieves the deployment to roll back.
+	vars := mux.Vars(r)
+	p, err := s.db.GetProject(vars["pid"])
+	if err != nil {
+		s.NotFoundHandler(w, r)
+		return
+	}
+	var d db.Keyer
+	id, _ := strconv.ParseUint(vars["did"], 10, 64)
+	if d, err = s.db.GetDeploy(id); err != nil || d.(*db.Deploy).ProjectID != vars["pid"] {
+		s.NotFoundHandler(w, r)
+		return
+	}
+
+	// Builds the page.
+	var t *template.Template
+	t, err = template.New("deploy.html").Funcs(tmplFuncMap).ParseFiles(
+		tmplPath+"/deploy.html",
+		tmplPath+"/common/form.html",
+		tmplPath+"/common/node.html",
+		tmplPath+"/common/header.html",
+		tmplPath+"/common/head.html",
+		tmplPath+"/common/foot.html",
+		tmplPath+"/common/footer.html",
+	)
+	if err != nil {
+		s.OopsHandler(w, r, err)
+		return
+	}
+
+	// Assigns vars to the templates.
+	tv := deployTmplVars{}
+	tv.Title = p.(*db.Project).Name
+	tv.Href = "/project/" + vars["pid"] + "/"
+	tv.Project = p
+	tv.Rollback = d.(*db.Deploy)
+	tv.Step, tv.Release, tv.Err = s.rollback(p.(*db.Project), tv.Rollback, r)
+	if tv.Step == 2 && tv.Release != nil {
+		tv.Nodes = results(serverNodes(tv.Rollback.ServerList), tv.Release)
+		tv.Canary = s.staged(tv.Release)
+		tv.Approval = s.requested(tv.Release)
+	}
+	if tv.Err == nil && tv.Release != nil {
+		tv.Hidden = hidden(p.(*db.Project), tv.Release)
+	}
+	// Displays the page.
+	if err = t.Execute(w, tv); err != nil {
+		s.OopsHandler(w, r, err)
+	}
+}
+
+// record saves the release in the history of the deployments of the project,
+// with the result of the push on each server.
+func (s *Server) record(p *db.Project, w []db.Keyer, out *deploy.Release, took time.Duration, r *http.Request) (*db.Deploy, error) {
+	if out.Results() == nil {
+		// Nothing has been sent.
+		return nil, nil
+	}
+	d := db.NewDeploy(p.ID)
+	d.ServerList = results(w, out)
+	d.EnvsValues = out.EnvsValues()
+	d.ItemList = out.Items()
+	d.LogList = out.Log()
+	d.TTL = out.TTL()
+	d.Duration = took
+	d.Author = s.author(r)
+	if a := s.approved(out); a != nil {
+		// Requested by another user.
+		d.Author, d.Approver = a.Author, d.Author
+	}
+	if out.Staged() {
+		// Keeps the values of the canary nodes before the push to abort it, even after a restart.
+		d.Stage = db.StageCanary
+		off := len(out.Results()) - len(w)
+		for k, srv := range d.ServerList {
+			if out.IsCanary(k + off) {
+				srv.Before = out.Before(k + off)
+			}
+		}
+	}
+	if err := s.db.AddDeploy(d); err != nil {
+		return d, err
+	}
+	s.deployed(p, d, out)
+	return d, nil
+}
+
+// results returns the status of the push on each node.
+func results(w []db.Keyer, out *deploy.Release) []*db.Server {
+	res := out.Results()
+	if res == nil {
+		return nil
+	}
+	// With a force push, the first result is the one of the fake server.
+	res = res[len(res)-len(w):]
+	nodes := make([]*db.Server, len(w))
+	for k, v := range w {
+		nodes[k] = &db.Server{
+			TCPAddr: v.(*db.Node).Addr,
+			Type:    v.(*db.Node).Type,
+		}
+		if res[k] == nil {
+			// Waits the promotion of the canary push.
+			continue
+		}
+		nodes[k].Succeeded = res[k].Err == nil
+		nodes[k].Latency, nodes[k].Attempts = res[k].Latency, res[k].Attempts
+		if res[k].Err != nil {
+			nodes[k].Err = res[k].Err.Error()
+		}
+	}
+	return nodes
+}
+
+type deploysTmplVars struct {
+	projectTmplVars
+	Deploys []db.Keyer
+	Deploy  *db.Deploy
+	// Hidden contains the deploy keys of the sensitive variables.
+	Hidden map[string]bool
+	// Staged is true if the canary deployment can be promoted or aborted.
+	Staged bool
+	// Approvals lists the deployments waiting an approval.
+	Approvals []*approval
+}
+
+// DeploysHandler displays the history of the deployments of a project,
+// or the details of one of them.
+func (s *Server) DeploysHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieves data to display.
+	vars := mux.Vars(r)
+	p, err := s.db.GetProject(vars["pid"])
+	if err != nil {
+		s.NotFoundHandler(w, r)
+		return
+	}
+	tv := deploysTmplVars{}
+	if did := vars["did"]; did != "" {
+		var d db.Keyer
+		id, _ := strconv.ParseUint(did, 10, 64)
+		if d, err = s.db.GetDeploy(id); err != nil || d.(*db.Deploy).ProjectID != vars["pid"] {
+			s.NotFoundHandler(w, r)
+			return
+		}
+		tv.Deploy = d.(*db.Deploy)
+		tv.Hidden = sensitive(p.(*db.Project), tv.Deploy.EnvsValues)
+		tv.Staged = s.waiting(id)
+	} else if tv.Deploys, err = s.db.Deploys(vars["pid"]); err != nil {
+		s.OopsHandler(w, r, err)
+		return
+	} else {
+		tv.Approvals = s.pending(vars["pid"])
+	}
+
+	// Builds the page.
+	var t *template.Template
+	t, err = template.New("deploys.html").Funcs(tmplFuncMap).ParseFiles(
+		tmplPath+"/deploys.html",
+		tmplPath+"/common/form.html",
+		tmplPath+"/common/node.html",
+		tmplPath+"/common/header.html",
+		tmplPath+"/common/head.html",
+		tmplPath+"/common/foot.html",
+		tmplPath+"/common/footer.html",
+	)
+	if err != nil {
+		s.OopsHandler(w, r, err)
+		return
+	}
+
+	// Assigns vars to the templates.
+	tv.Title = p.(*db.Project).Name
+	tv.Href = "/project/" + vars["pid"] + "/"
+	tv.Project = p
+
+	// Displays the page.
+	if err = t.Execute(w, tv); err != nil {
+		s.OopsHandler(w, r, err)
+	}
+}
+
+// envKey returns the deploy key of the variable for the value of the first environment
+// and the values of the next ones.
+func envKey(pid, first string, others []string, name string) string {
+	parts := append([]string{pid, first}, others...)
+	return deploy.Key(append(parts, name)...)
+}
+
+// filter returns the names also in the tagged ones.
+// Without names, all the tagged ones are returned.
+func filter(names, tagged []string) []string {
+	if len(names) == 0 {
+		return tagged
+	}
+	m := make(map[string]bool, len(tagged))
+	for _, v := range tagged {
+		m[v] = true
+	}
+	var r []string
+	for _, v := range names {
+		if m[v] {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// hidden returns the names and the deploy keys of the sensitive variables of the release.
+func hidden(p *db.Project, out *deploy.Release) map[string]bool {
+	sensitive := make(map[string]bool)
+	for _, d := range p.Vars() {
+		if v := d.(*db.Var); v.Sensitive {
+			sensitive[v.Name] = true
+		}
+	}
+	if len(sensitive) == 0 {
+		return nil
+	}
+	m := make(map[string]bool)
+	for name, c := range out.Diff() {
+		if !sensitive[name] {
+			continue
+		}
+		m[name] = true
+		for k := range c.Log {
+			m[k] = true
+		}
+	}
+	return m
+}
+
+// sensitive returns the deploy keys of the sensitive variables of the project for these environments.
+func sensitive(p *db.Project, envs [][]string) map[string]bool {
+	m := make(map[string]bool)
+	for _, d := range p.Vars() {
+		v := d.(*db.Var)
+		if !v.Sensitive {
+			continue
+		}
+		for _, c := range deploy.Combine(envs) {
+			m[deploy.Key(append(append([]string{p.ID}, c...), v.Name)...)] = true
+		}
+	}
+	return m
+}
+
 func (s *Server) nodes() ([]db.Keyer, error) {
 	nodes, err := s.db.Nodes()
 	if err != nil {
@@ -0,0 +1,101 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rvflash/eve"
+	"github.com/rvflash/eve/db"
+	cache "github.com/rvflash/eve/rpc"
+)
+
+// serve serves the cache on a local address and returns its listener.
+func serve(t *testing.T, c *cache.Cache) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			srv := rpc.NewServer()
+			if err := srv.RegisterName("Cache", cache.NewSession(c, nil)); err != nil {
+				return
+			}
+			go srv.ServeConn(conn)
+		}
+	}()
+	return l
+}
+
+func TestServerDests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	d, err := db.Open(filepath.Join(dir, "eve.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	// Creates a project with one variable.
+	p := db.NewProject("Alpha", "")
+	if err = d.AddProject(p); err != nil {
+		t.Fatal(err)
+	}
+	v := db.NewVar("name", db.String.Int())
+	v.Default = "eve"
+	if err = d.AddVarInProject(v, p.ID); err != nil {
+		t.Fatal(err)
+	}
+	k, err := d.GetProject(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = k.(*db.Project)
+
+	// Deploys it on a cache server, as done by the editor.
+	c := cache.New()
+	defer func() { _ = c.Close() }()
+	l := serve(t, c)
+	defer func() { _ = l.Close() }()
+	addr := l.Addr().String()
+
+	s := NewServer("", 0)
+	s.db = d
+	r := &http.Request{Method: "GET", Form: url.Values{}}
+	out, err := s.release(p, p, []db.Keyer{&db.Node{Addr: addr}}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = out.Checkout(p.EnvsValues()...); err != nil {
+		t.Fatal(err)
+	}
+	if err = out.Push(); err != nil {
+		t.Fatal(err)
+	}
+	// Reads it with a client unaware of the namespaces.
+	servers, err := eve.Servers(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := eve.New(p.ID, servers...)
+	if s, err := cli.String("name"); err != nil || s != "eve" {
+		t.Errorf("content mismatch: exp=eve got=%q (%v)", s, err)
+	}
+}
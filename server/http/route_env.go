@@ -34,7 +34,7 @@ func (s *Server) EnvHandler(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
 			// Updates this environment.
 			scp := parseEnv(r)
-			env.Name, env.Values = scp.Name, scp.Values
+			env.Name, env.Values, env.Protected = scp.Name, scp.Values, scp.Protected
 			if err := s.db.UpsertEnv(env); err != nil {
 				s.jsonHandler(w, err.Error(), http.StatusBadRequest)
 			} else {
@@ -100,5 +100,8 @@ func parseEnv(r *http.Request) *db.Env {
 	}
 	v := strings.FieldsFunc(r.Form.Get("vals"), f)
 
-	return db.NewEnv(r.Form.Get("name"), v)
+	env := db.NewEnv(r.Form.Get("name"), v)
+	env.Protected = strings.FieldsFunc(r.Form.Get("protected"), f)
+
+	return env
 }
@@ -0,0 +1,190 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/deploy"
+	"github.com/rvflash/eve/hook"
+)
+
+type hooksTmplVars struct {
+	projectTmplVars
+	Hooks      []db.Keyer
+	Deliveries []*db.Delivery
+}
+
+// HooksHandler displays the webhooks of a project, or the global ones,
+// with the deliveries waiting their success. It also creates them.
+func (s *Server) HooksHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var p db.Keyer
+	if pid := vars["pid"]; pid != "" {
+		var err error
+		if p, err = s.db.GetProject(pid); err != nil {
+			s.NotFoundHandler(w, r)
+			return
+		}
+	}
+	if r.Method == http.MethodPost {
+		s.addHook(w, r, vars["pid"])
+		return
+	}
+
+	// Builds the page.
+	t, err := template.New("hooks.html").Funcs(tmplFuncMap).ParseFiles(
+		tmplPath+"/hooks.html",
+		tmplPath+"/common/form.html",
+		tmplPath+"/common/header.html",
+		tmplPath+"/common/head.html",
+		tmplPath+"/common/foot.html",
+		tmplPath+"/common/footer.html",
+	)
+	if err != nil {
+		s.OopsHandler(w, r, err)
+		return
+	}
+
+	// Assigns vars to the templates.
+	tv := hooksTmplVars{}
+	tv.Title = "Webhooks"
+	if p != nil {
+		tv.Title = p.(*db.Project).Name
+		tv.Href = "/project/" + vars["pid"] + "/"
+		tv.Project = p
+	}
+	if tv.Hooks, err = s.db.Hooks(vars["pid"]); err != nil {
+		s.OopsHandler(w, r, err)
+		return
+	}
+	known := make(map[uint64]bool, len(tv.Hooks))
+	for _, h := range tv.Hooks {
+		known[h.(*db.Hook).ID] = true
+	}
+	all, err := s.db.Deliveries(time.Time{})
+	if err != nil {
+		s.OopsHandler(w, r, err)
+		return
+	}
+	for _, d := range all {
+		if known[d.HookID] {
+			tv.Deliveries = append(tv.Deliveries, d)
+		}
+	}
+
+	// Displays the page.
+	if err = t.Execute(w, tv); err != nil {
+		s.OopsHandler(w, r, err)
+	}
+}
+
+func (s *Server) addHook(w http.ResponseWriter, r *http.Request, pid string) {
+	if err := r.ParseForm(); err != nil {
+		s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h := db.NewHook(pid, r.Form.Get("url"), r.Form.Get("secret"))
+	if err := s.db.AddHook(h); err != nil {
+		s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.jsonHandler(w, "", http.StatusOK)
+}
+
+// HookHandler deletes a webhook.
+func (s *Server) HookHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseUint(mux.Vars(r)["hid"], 10, 64)
+	h, err := s.db.GetHook(id)
+	if err != nil {
+		s.jsonHandler(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err = s.db.DeleteHook(h.(*db.Hook)); err != nil {
+		s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.jsonHandler(w, "", http.StatusOK)
+}
+
+// changed notifies the webhooks of the change of the variable.
+func (s *Server) changed(project string, v *db.Var, c *db.Change) {
+	s.notify(project, hook.NewChange(project, v, c))
+}
+
+// deployed notifies the webhooks of the deployment of the project.
+func (s *Server) deployed(p *db.Project, d *db.Deploy, out *deploy.Release) {
+	s.notify(p.ID, hook.NewDeploy(d, out.Status(), sensitive(p, d.EnvsValues)))
+}
+
+// notify queues the event for each webhook of the project, then wakes up the delivery.
+func (s *Server) notify(project string, e *hook.Event) {
+	hooks, err := s.db.Hooks(project)
+	if err != nil || len(hooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		s.log.Printf("fails to encode the %s event: %s\n", e.Type, err)
+		return
+	}
+	for _, h := range hooks {
+		if err = s.db.AddDelivery(db.NewDelivery(h.(*db.Hook).ID, payload)); err != nil {
+			s.log.Printf("fails to queue the %s event: %s\n", e.Type, err)
+		}
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// A delivery is already pending.
+	}
+}
+
+// deliver posts the queued payloads to their webhook, on demand or every 30 seconds.
+// A failed delivery is tried again later, until too many attempts.
+func (s *Server) deliver() {
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-s.wake:
+		}
+		queue, err := s.db.Deliveries(time.Now())
+		if err != nil {
+			s.log.Printf("fails to list the deliveries: %s\n", err)
+			continue
+		}
+		for _, d := range queue {
+			h, err := s.db.GetHook(d.HookID)
+			if err != nil {
+				// Deleted hook.
+				_ = s.db.DeleteDelivery(d)
+				continue
+			}
+			if err = hook.Post(s.client, h.(*db.Hook), d.Payload); err == nil {
+				err = s.db.DeleteDelivery(d)
+			} else {
+				d.Attempts++
+				d.Err = err.Error()
+				if d.Attempts < hook.MaxAttempts {
+					d.NextTs = time.Now().Add(hook.Backoff(d.Attempts))
+				} else {
+					d.NextTs = time.Time{}
+				}
+				err = s.db.UpdateDelivery(d)
+			}
+			if err != nil {
+				s.log.Printf("fails to update the delivery #%d: %s\n", d.ID, err)
+			}
+		}
+	}
+}
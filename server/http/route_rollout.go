@@ -0,0 +1,195 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/deploy"
+)
+
+// rollout is a canary deployment waiting to be promoted or aborted.
+// Without manual decision, the timer promotes it once the bake time elapsed.
+type rollout struct {
+	w     []db.Keyer
+	out   *deploy.Release
+	d     *db.Deploy
+	timer *time.Timer
+}
+
+// stage keeps in memory the release pushed on its canary nodes.
+func (s *Server) stage(w []db.Keyer, out *deploy.Release, d *db.Deploy, bake time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ro := &rollout{w: w, out: out, d: d}
+	if bake > 0 {
+		d.BakeTs = time.Now().Add(bake)
+		ro.timer = time.AfterFunc(bake, func() {
+			if err := s.promote(d.ID, false); err != nil && err != deploy.ErrStage {
+				s.log.Printf("fails to promote the deployment #%d: %s\n", d.ID, err)
+			}
+		})
+		if err := s.db.UpdateDeploy(d); err != nil {
+			s.log.Printf("fails to save the bake time of the deployment #%d: %s\n", d.ID, err)
+		}
+	}
+	s.rollouts[d.ID] = ro
+}
+
+// resume stages again the canary deployments saved before the restart of the server,
+// with the remaining bake time.
+// A deployment that can not be resumed is marked as aborted, without change on its servers.
+func (s *Server) resume() {
+	l, err := s.db.Projects()
+	if err != nil {
+		s.log.Printf("fails to list the projects to resume their canary deployments: %s\n", err)
+		return
+	}
+	for _, k := range l {
+		p, err := s.db.GetProject(k.(*db.Project).ID)
+		if err != nil {
+			s.log.Printf("fails to load the project to resume its canary deployments: %s\n", err)
+			continue
+		}
+		deploys, err := s.db.Deploys(p.(*db.Project).ID)
+		if err != nil {
+			s.log.Printf("fails to list the canary deployments of %s: %s\n", p.(*db.Project).ID, err)
+			continue
+		}
+		for _, d := range deploys {
+			if !d.(*db.Deploy).Staged() {
+				continue
+			}
+			if err = s.restage(p.(*db.Project), d.(*db.Deploy)); err == nil {
+				continue
+			}
+			s.log.Printf("fails to resume the deployment #%d: %s\n", d.(*db.Deploy).ID, err)
+			d.(*db.Deploy).Stage = db.StageAborted
+			if err = s.db.UpdateDeploy(d.(*db.Deploy)); err != nil {
+				s.log.Printf("fails to abort the deployment #%d: %s\n", d.(*db.Deploy).ID, err)
+			}
+		}
+	}
+}
+
+// restage rebuilds the release of the canary deployment to wait again its promotion.
+func (s *Server) restage(p *db.Project, d *db.Deploy) error {
+	src, before, err := p.Canary(d)
+	if err != nil {
+		return err
+	}
+	w := serverNodes(d.ServerList)
+	nodes, err := s.dests(p, w)
+	if err != nil {
+		return err
+	}
+	out := s.newRelease(src, nodes)
+	out.Expire(d.TTL)
+	if err = out.Checkout(d.EnvsValues...); err != nil {
+		return err
+	}
+	if err = out.Resume(before); err != nil {
+		return err
+	}
+	var bake time.Duration
+	if !d.BakeTs.IsZero() {
+		// Once elapsed, the bake time promotes the deployment as soon as possible.
+		if bake = time.Until(d.BakeTs); bake <= 0 {
+			bake = time.Millisecond
+		}
+	}
+	s.stage(w, out, d, bake)
+	return nil
+}
+
+// staged returns the deployment of the release if it waits its promotion.
+func (s *Server) staged(out *deploy.Release) *db.Deploy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ro := range s.rollouts {
+		if ro.out == out {
+			return ro.d
+		}
+	}
+	return nil
+}
+
+// waiting returns true if this deployment waits its promotion.
+func (s *Server) waiting(id uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.rollouts[id]
+	return ok
+}
+
+// promote pushes the canary deployment on its other nodes, or aborts it.
+// A deployment can be only promoted or aborted once.
+func (s *Server) promote(id uint64, abort bool) error {
+	s.mu.Lock()
+	ro, ok := s.rollouts[id]
+	delete(s.rollouts, id)
+	s.mu.Unlock()
+	if !ok {
+		return deploy.ErrStage
+	}
+	if ro.timer != nil {
+		ro.timer.Stop()
+	}
+	// Reloads the project, it may have changed since the canary push.
+	p, err := s.db.GetProject(ro.d.ProjectID)
+	if err != nil {
+		return err
+	}
+	if abort {
+		// The canary nodes get back their previous values.
+		err = ro.out.Abort()
+		ro.d.Stage = db.StageAborted
+	} else {
+		err = ro.out.Promote()
+		ro.d.Stage = ""
+		for k, srv := range results(ro.w, ro.out) {
+			// The canary nodes keep the result of their push.
+			if !srv.Pending() {
+				ro.d.ServerList[k] = srv
+			}
+		}
+	}
+	if uerr := s.db.UpdateDeploy(ro.d); err == nil {
+		err = uerr
+	}
+	s.deployed(p.(*db.Project), ro.d, ro.out)
+	if err != nil || abort {
+		return err
+	}
+	return s.save(p.(*db.Project), ro.out)
+}
+
+// RolloutHandler promotes or aborts a canary deployment.
+func (s *Server) RolloutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.NotFoundHandler(w, r)
+		return
+	}
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseUint(vars["did"], 10, 64)
+	d, err := s.db.GetDeploy(id)
+	if err != nil || d.(*db.Deploy).ProjectID != vars["pid"] {
+		s.NotFoundHandler(w, r)
+		return
+	}
+	if err = s.promote(id, vars["do"] == "abort"); err != nil {
+		s.OopsHandler(w, r, err)
+		return
+	}
+	loc := "/project/" + vars["pid"] + "/deploys/" + vars["did"]
+	http.Redirect(w, r, loc, http.StatusSeeOther)
+}
@@ -8,15 +8,23 @@ import (
 	"encoding/binary"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"strconv"
 
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/keyring"
 )
 
+type historyTmplVars struct {
+	projectTmplVars
+	History []*db.Change
+}
+
 type varHandler struct {
 	s    *Server
 	p, v db.Keyer
@@ -45,11 +53,25 @@ func (s *Server) VarHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case http.MethodPost:
-		h.putHandler(w, r)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/rules"):
+			h.rulesHandler(w, r)
+		case strings.HasSuffix(r.URL.Path, "/meta"):
+			h.metaHandler(w, r)
+		case strings.HasSuffix(r.URL.Path, "/defaults"):
+			h.defaultsHandler(w, r)
+		case strings.HasSuffix(r.URL.Path, "/history"):
+			h.revertHandler(w, r)
+		default:
+			h.putHandler(w, r)
+		}
 	case http.MethodGet:
-		if strings.HasSuffix(r.URL.Path, "/delete") {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/delete"):
 			h.deleteHandler(w, r)
-		} else {
+		case strings.HasSuffix(r.URL.Path, "/history"):
+			h.historyHandler(w, r)
+		default:
 			h.getHandler(w, r)
 		}
 	}
@@ -86,21 +108,50 @@ func (h *varHandler) getHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Assigns vars to the templates.
-	type varTmplVars struct {
-		projectTmplVars
-		VarIDPrefix,
-		VarIDTie string
+	tv := projectTmplVars{}
+	tv.Title = h.p.(*db.Project).Name
+	tv.Href = "/project/" + h.rv["pid"] + "/"
+	tv.Var = h.v
+	tv.Project = h.p
+	tv.Kinds = db.Kinds
+	tv.Servers, _ = h.s.db.Nodes()
+
+	// Displays the page.
+	if err = t.Execute(w, tv); err != nil {
+		h.s.OopsHandler(w, r, err)
 	}
-	tv := varTmplVars{
-		VarIDPrefix: db.VarIDPrefix,
-		VarIDTie:    db.VarIDTie,
+}
+
+func (h *varHandler) historyHandler(w http.ResponseWriter, r *http.Request) {
+	// Builds the page.
+	t, err := template.New("history.html").Funcs(tmplFuncMap).ParseFiles(
+		tmplPath+"/history.html",
+		tmplPath+"/project/top.html",
+		tmplPath+"/project/bottom.html",
+		tmplPath+"/common/form.html",
+		tmplPath+"/common/node.html",
+		tmplPath+"/common/header.html",
+		tmplPath+"/common/head.html",
+		tmplPath+"/common/foot.html",
+		tmplPath+"/common/footer.html",
+	)
+	if err != nil {
+		h.s.OopsHandler(w, r, err)
+		return
 	}
+
+	// Assigns vars to the templates.
+	tv := historyTmplVars{}
 	tv.Title = h.p.(*db.Project).Name
 	tv.Href = "/project/" + h.rv["pid"] + "/"
 	tv.Var = h.v
 	tv.Project = h.p
 	tv.Kinds = db.Kinds
 	tv.Servers, _ = h.s.db.Nodes()
+	if tv.History, err = h.s.db.History(h.v.(*db.Var).ID); err != nil {
+		h.s.OopsHandler(w, r, err)
+		return
+	}
 
 	// Displays the page.
 	if err = t.Execute(w, tv); err != nil {
@@ -108,6 +159,40 @@ func (h *varHandler) getHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *varHandler) revertHandler(w http.ResponseWriter, r *http.Request) {
+	// Try to restore the values of the variable before the given change.
+	if err := r.ParseForm(); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v := h.v.(*db.Var)
+	cid, err := strconv.ParseUint(r.PostForm.Get("change"), 10, 64)
+	if err != nil {
+		h.s.jsonHandler(w, "invalid change", http.StatusBadRequest)
+		return
+	}
+	c, err := h.s.db.GetChange(v.ID, cid)
+	if err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = v.Revert(c); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = h.p.(*db.Project).Refer(v); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v.Author = h.s.author(r)
+	if err = h.s.db.UpdateVarInProject(v, h.rv["pid"]); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Redirects to the variable's page.
+	h.s.jsonHandler(w, strings.TrimSuffix(r.URL.Path, "/history"), http.StatusOK)
+}
+
 func (h *varHandler) putHandler(w http.ResponseWriter, r *http.Request) {
 	// Try to update the given variable.
 	if err := r.ParseForm(); err != nil {
@@ -118,10 +203,23 @@ func (h *varHandler) putHandler(w http.ResponseWriter, r *http.Request) {
 	v := h.v.(*db.Var)
 	m := make(map[string]string)
 	// Parses all the url values and gets as string each value.
+	// The cells listed as inherit inherit again the defaults of the variable, like the
+	// empty ones not yet overridden. The hidden values are never displayed: an empty one is unchanged.
+	inherit := make(map[string]bool)
+	for _, k := range r.PostForm["inherit"] {
+		inherit[k] = true
+	}
 	var s string
 	for k := range r.PostForm {
-		if s = r.PostForm.Get(k); s == "" {
-			s = v.Kind.ZeroString()
+		if k == "inherit" {
+			continue
+		}
+		s = r.PostForm.Get(k)
+		switch old, ok := v.Values[k]; {
+		case inherit[k], s == "" && !ok:
+			s = db.Inherit
+		case s == "" && v.Hidden():
+			s = v.Kind.Format(old)
 		}
 		m[k] = s
 	}
@@ -129,6 +227,15 @@ func (h *varHandler) putHandler(w http.ResponseWriter, r *http.Request) {
 		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := h.p.(*db.Project).Refer(v); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := v.Seal(h.s.keys); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v.Author = h.s.author(r)
 	if err := h.s.db.UpdateVarInProject(v, h.rv["pid"]); err != nil {
 		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
 		return
@@ -137,6 +244,117 @@ func (h *varHandler) putHandler(w http.ResponseWriter, r *http.Request) {
 	h.s.jsonHandler(w, r.URL.Path, http.StatusOK)
 }
 
+func (h *varHandler) rulesHandler(w http.ResponseWriter, r *http.Request) {
+	// Try to update the rules of the given variable.
+	if err := r.ParseForm(); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Parses the optional bounds.
+	bound := func(name string) (*float64, error) {
+		s := strings.TrimSpace(r.PostForm.Get(name))
+		if s == "" {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, errors.WithMessage(err, name)
+		}
+		return &f, nil
+	}
+	var (
+		rules db.Rules
+		err   error
+	)
+	if rules.Min, err = bound("min"); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rules.Max, err = bound("max"); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rules.Pattern = strings.TrimSpace(r.PostForm.Get("regexp"))
+	for _, v := range strings.Split(r.PostForm.Get("enum"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			rules.Enum = append(rules.Enum, v)
+		}
+	}
+	rules.Required = r.PostForm["required"]
+
+	v := h.v.(*db.Var)
+	v.Rules = rules
+	v.Author = h.s.author(r)
+	if err = h.s.db.UpdateVarInProject(v, h.rv["pid"]); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Redirects to the variable's page.
+	h.s.jsonHandler(w, strings.TrimSuffix(r.URL.Path, "/rules"), http.StatusOK)
+}
+
+func (h *varHandler) defaultsHandler(w http.ResponseWriter, r *http.Request) {
+	// Try to update the defaults of the given variable.
+	if err := r.ParseForm(); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v := h.v.(*db.Var)
+	// The hidden defaults are never displayed, so an empty one is unchanged.
+	value := func(s string, old interface{}) string {
+		if s == "" && old != nil && v.Hidden() {
+			return v.Kind.Format(old)
+		}
+		return s
+	}
+	def := value(r.PostForm.Get("default"), v.Default)
+	m := make(map[string]string)
+	for k := range r.PostForm {
+		if ev := strings.TrimPrefix(k, "ev_"); ev != k {
+			m[ev] = value(r.PostForm.Get(k), v.Defaults[ev])
+		}
+	}
+	if err := v.SetDefaults(def, m); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.p.(*db.Project).Refer(v); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := v.Seal(h.s.keys); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v.Author = h.s.author(r)
+	if err := h.s.db.UpdateVarInProject(v, h.rv["pid"]); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Redirects to the variable's page.
+	h.s.jsonHandler(w, strings.TrimSuffix(r.URL.Path, "/defaults"), http.StatusOK)
+}
+
+func (h *varHandler) metaHandler(w http.ResponseWriter, r *http.Request) {
+	// Try to update the description of the given variable.
+	if err := r.ParseForm(); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v := h.v.(*db.Var)
+	v.Description = r.PostForm.Get("desc")
+	v.Owner = r.PostForm.Get("owner")
+	v.Tags = strings.Split(r.PostForm.Get("tags"), ",")
+	v.Sensitive = r.PostForm.Get("sensitive") != ""
+	v.Author = h.s.author(r)
+	if err := h.s.db.UpdateVarInProject(v, h.rv["pid"]); err != nil {
+		h.s.jsonHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Redirects to the variable's page.
+	h.s.jsonHandler(w, strings.TrimSuffix(r.URL.Path, "/meta"), http.StatusOK)
+}
+
 // VarsHandler manages the creation of a project's variable.
 func (s *Server) VarsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -165,3 +383,70 @@ func (s *Server) VarsHandler(w http.ResponseWriter, r *http.Request) {
 	loc := fmt.Sprintf("/project/%s/var/%d", vars["pid"], vid)
 	s.jsonHandler(w, loc, http.StatusOK)
 }
+
+// author returns the name of the user behind the request, else its remote address.
+func (s *Server) author(r *http.Request) string {
+	if name := s.user(r); name != "" {
+		return name
+	}
+	return remoteHost(r)
+}
+
+// user returns the name of the authenticated user behind the request, as given
+// by a trusted proxy with its header or the basic authentication that it checked.
+// It returns an empty string if the user is unknown.
+func (s *Server) user(r *http.Request) string {
+	if !s.proxies[remoteHost(r)] {
+		// The headers of the request can not be trusted.
+		return ""
+	}
+	if name := r.Header.Get("X-Forwarded-User"); name != "" {
+		return name
+	}
+	if name, _, ok := r.BasicAuth(); ok {
+		return name
+	}
+	return ""
+}
+
+// remoteHost returns the remote address of the request, without its port.
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// varID returns the internal name of the value of the variable for the value
+// of the first environment and the values of the next ones.
+func varID(first string, others []string) string {
+	return db.VarID(append([]string{first}, others...)).String()
+}
+
+// preview is the resolved value of a variable referring to other ones.
+type preview struct {
+	Value string
+	Err   error
+}
+
+// resolve returns the preview of the value of the variable for this combination
+// of environments values, or nil if it does not refer to other variables.
+func resolve(p, v db.Keyer, id string) *preview {
+	vv := v.(*db.Var)
+	if vv.Hidden() || db.References(vv.Kind.Format(vv.Value(id))) == nil {
+		return nil
+	}
+	d, err := p.(*db.Project).Resolve(vv, id)
+	if err != nil {
+		return &preview{Err: err}
+	}
+	return &preview{Value: vv.Kind.Format(d)}
+}
+
+// mask hides the value if it is a secret.
+func mask(d interface{}) interface{} {
+	if s, ok := d.(string); ok && keyring.Sealed(s) {
+		return "••••••"
+	}
+	return d
+}
@@ -9,19 +9,39 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/rvflash/eve/client"
 	"github.com/rvflash/eve/db"
+	"github.com/rvflash/eve/keyring"
 )
 
 // Server represents the default server's configuration.
 type Server struct {
-	Host string
-	Port int
-	db   *db.Data
-	log  *log.Logger
-	r    *mux.Router
+	Host  string
+	Port  int
+	cache []client.Option
+	// retries is the number of new attempts of a push on a failing node, after this delay.
+	retries int
+	delay   time.Duration
+	db      *db.Data
+	keys    *keyring.Keyring
+	log     *log.Logger
+	r       *mux.Router
+	// rollouts contains the canary deployments waiting their promotion, by identifier.
+	rollouts map[uint64]*rollout
+	mu       sync.Mutex
+	// client posts the payloads of the webhooks, once woken up.
+	client *http.Client
+	wake   chan struct{}
+	// approvals contains the deployments to protected values waiting an approval, by identifier.
+	approvals   map[uint64]*approval
+	approvalTTL time.Duration
+	// proxies contains the addresses of the proxies trusted to authenticate the users.
+	proxies map[string]bool
 }
 
 // NewServer returns an instance of Server.
@@ -31,6 +51,13 @@ func NewServer(listenIP string, httpPort int) *Server {
 		Port: httpPort,
 		log:  log.New(os.Stdout, "server> ", log.Ltime|log.Lshortfile),
 		r:    mux.NewRouter(),
+
+		rollouts: make(map[uint64]*rollout),
+		client:   &http.Client{Timeout: 5 * time.Second},
+		wake:     make(chan struct{}, 1),
+
+		approvals:   make(map[uint64]*approval),
+		approvalTTL: 24 * time.Hour,
 	}
 }
 
@@ -43,8 +70,14 @@ func (s *Server) Route() {
 			s.OopsHandler(w, r, errors.WithMessage(db.ErrMissing, "database"))
 		})
 	default:
+		// Each change of the variables is notified to the webhooks.
+		s.db.OnChange(s.changed)
+		// The canary deployments wait again their promotion, the requests their approval.
+		s.resume()
+		s.reload()
 		s.r.HandleFunc("/", s.HomeHandler)
 		s.r.HandleFunc("/node", s.NodesHandler)
+		s.r.HandleFunc("/node/delete", s.NodeHandler)
 		s.r.HandleFunc("/node/{naddr}/delete", s.NodeHandler)
 		s.r.HandleFunc("/env/{eid:[0-9]+}/", s.EnvHandler)
 		s.r.HandleFunc("/project", s.ProjectsHandler)
@@ -54,7 +87,20 @@ func (s *Server) Route() {
 		s.r.HandleFunc("/project/{pid:[a-z-]+}/var", s.VarsHandler)
 		s.r.HandleFunc("/project/{pid:[a-z-]+}/var/{vid:[0-9]+}", s.VarHandler)
 		s.r.HandleFunc("/project/{pid:[a-z-]+}/var/{vid:[0-9]+}/delete", s.VarHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/var/{vid:[0-9]+}/rules", s.VarHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/var/{vid:[0-9]+}/meta", s.VarHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/var/{vid:[0-9]+}/defaults", s.VarHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/var/{vid:[0-9]+}/history", s.VarHandler)
 		s.r.HandleFunc("/project/{pid:[a-z-]+}/deploy", s.DeployHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/deploys", s.DeploysHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/deploys/{did:[0-9]+}", s.DeploysHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/deploys/{did:[0-9]+}/rollback", s.RollbackHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/deploys/{did:[0-9]+}/{do:promote|abort}", s.RolloutHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/approvals/{aid:[0-9]+}", s.ApprovalHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/approvals/{aid:[0-9]+}/{do:approve|reject}", s.ApprovalHandler)
+		s.r.HandleFunc("/project/{pid:[a-z-]+}/hooks", s.HooksHandler)
+		s.r.HandleFunc("/hooks", s.HooksHandler)
+		s.r.HandleFunc("/hooks/{hid:[0-9]+}/delete", s.HookHandler)
 		s.r.HandleFunc("/vars", s.CacheHandler)
 		s.r.HandleFunc("/favicon.ico", s.StaticHandler)
 	}
@@ -70,6 +116,9 @@ func (s *Server) Serve() {
 			}
 		}
 	}()
+	if s.db != nil {
+		go s.deliver()
+	}
 	addr := s.Host + ":" + strconv.Itoa(s.Port)
 	s.log.Println("Serving " + addr)
 	s.log.Fatal(http.ListenAndServe(addr, s.r))
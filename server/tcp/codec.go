@@ -0,0 +1,158 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	cache "github.com/rvflash/eve/rpc"
+)
+
+// errBatch is returned to the clients sending a batch of JSON-RPC requests.
+var errBatch = errors.New("batch requests are not supported")
+
+// sniff returns the codec matching the protocol used by the client.
+// A JSON-RPC request starts with a JSON object, a gob stream never.
+// A batch of requests, starting with a JSON array, is rejected.
+func sniff(conn net.Conn) rpc.ServerCodec {
+	r := bufio.NewReader(conn)
+	c := &bufConn{Reader: r, Conn: conn}
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return newGobCodec(c)
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = r.ReadByte()
+		case '{':
+			return cache.NewJSONServerCodec(c)
+		case '[':
+			return &batchCodec{conn: c}
+		default:
+			return newGobCodec(c)
+		}
+	}
+}
+
+// batchCodec rejects the connection with a JSON-RPC error on its first request.
+type batchCodec struct {
+	conn io.WriteCloser
+}
+
+// ReadRequestHeader implements the rpc.ServerCodec interface.
+func (c *batchCodec) ReadRequestHeader(r *rpc.Request) error {
+	_ = json.NewEncoder(c.conn).Encode(map[string]interface{}{
+		"jsonrpc": cache.JSONVersion,
+		"error":   map[string]interface{}{"code": -32600, "message": errBatch.Error()},
+		"id":      nil,
+	})
+	return errBatch
+}
+
+// ReadRequestBody implements the rpc.ServerCodec interface.
+func (c *batchCodec) ReadRequestBody(body interface{}) error {
+	return errBatch
+}
+
+// WriteResponse implements the rpc.ServerCodec interface.
+func (c *batchCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	return errBatch
+}
+
+// Close implements the rpc.ServerCodec interface.
+func (c *batchCodec) Close() error {
+	return c.conn.Close()
+}
+
+// bufConn is a connection whose reads are buffered.
+type bufConn struct {
+	*bufio.Reader
+	net.Conn
+}
+
+// Read implements the io.Reader interface.
+func (c *bufConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+// gobCodec encodes the calls with gob, as the default codec of net/rpc.
+// It is used instead of rpc.ServeConn to read through the buffer of the sniffer.
+type gobCodec struct {
+	conn io.Closer
+	dec  *gob.Decoder
+	enc  *gob.Encoder
+	buf  *bufio.Writer
+	once sync.Once
+}
+
+func newGobCodec(conn io.ReadWriteCloser) *gobCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobCodec{conn: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), buf: buf}
+}
+
+// ReadRequestHeader implements the rpc.ServerCodec interface.
+func (c *gobCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+// ReadRequestBody implements the rpc.ServerCodec interface.
+func (c *gobCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+// WriteResponse implements the rpc.ServerCodec interface.
+// A response partially encoded breaks the gob stream, so the connection is closed.
+func (c *gobCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	err := c.enc.Encode(r)
+	if err == nil {
+		err = c.enc.Encode(body)
+	}
+	if err != nil {
+		_ = c.buf.Flush()
+		_ = c.Close()
+		return err
+	}
+	return c.buf.Flush()
+}
+
+// Close implements the rpc.ServerCodec interface.
+// The connection is only closed once.
+func (c *gobCodec) Close() (err error) {
+	c.once.Do(func() {
+		err = c.conn.Close()
+	})
+	return
+}
+
+// countCodec counts the calls in progress, from the reading
+// of their request until the writing of their response.
+type countCodec struct {
+	rpc.ServerCodec
+	n *int64
+}
+
+// ReadRequestHeader implements the rpc.ServerCodec interface.
+func (c *countCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err == nil {
+		atomic.AddInt64(c.n, 1)
+	}
+	return err
+}
+
+// WriteResponse implements the rpc.ServerCodec interface.
+func (c *countCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	defer atomic.AddInt64(c.n, -1)
+	return c.ServerCodec.WriteResponse(r, body)
+}
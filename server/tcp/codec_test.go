@@ -0,0 +1,96 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+
+	cache "github.com/rvflash/eve/rpc"
+)
+
+// serve serves the cache on one end of a pipe and returns the other one.
+func serve(t *testing.T, c *cache.Cache, inflight *int64) net.Conn {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Cache", cache.NewSession(c, nil)); err != nil {
+		t.Fatal(err)
+	}
+	sc, cc := net.Pipe()
+	go func() {
+		srv.ServeCodec(&countCodec{ServerCodec: sniff(sc), n: inflight})
+	}()
+	return cc
+}
+
+func TestSniff(t *testing.T) {
+	c := cache.New()
+	defer func() { _ = c.Close() }()
+
+	var dt = []struct {
+		name  string
+		codec func(conn net.Conn) rpc.ClientCodec
+	}{
+		{name: "gob"},
+		{name: "json", codec: func(conn net.Conn) rpc.ClientCodec { return cache.NewJSONClientCodec(conn) }},
+	}
+	for i, tt := range dt {
+		var n int64
+		conn := serve(t, c, &n)
+		var cli *rpc.Client
+		if tt.codec == nil {
+			cli = rpc.NewClient(conn)
+		} else {
+			cli = rpc.NewClientWithCodec(tt.codec(conn))
+		}
+		var ok bool
+		if err := cli.Call("Cache.Put", &cache.Item{Key: tt.name, Value: i}, &ok); err != nil {
+			t.Fatalf("%d. unexpected error: %q", i, err)
+		}
+		var it cache.Item
+		if err := cli.Call("Cache.Get", tt.name, &it); err != nil {
+			t.Fatalf("%d. unexpected error: %q", i, err)
+		}
+		if it.Value != i {
+			t.Errorf("%d. content mismatch: exp=%v got=%v", i, i, it.Value)
+		}
+		if err := cli.Call("Cache.Get", "unknown", &it); err == nil || err.Error() != cache.ErrNotFound.Error() {
+			t.Errorf("%d. error mismatch: exp=%q got=%q", i, cache.ErrNotFound, err)
+		}
+		_ = cli.Close()
+	}
+}
+
+func TestSniffBatch(t *testing.T) {
+	c := cache.New()
+	defer func() { _ = c.Close() }()
+
+	var n int64
+	conn := serve(t, c, &n)
+	defer func() { _ = conn.Close() }()
+	go func() {
+		_, _ = io.WriteString(conn, `[{"jsonrpc":"2.0","method":"Cache.Get","params":"k","id":1}]`)
+	}()
+	var resp struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	r := bufio.NewReader(conn)
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if resp.Error.Message != errBatch.Error() {
+		t.Errorf("error mismatch: exp=%q got=%q", errBatch, resp.Error.Message)
+	}
+	// The connection is closed.
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("error mismatch: exp=%q got=%q", io.EOF, err)
+	}
+}
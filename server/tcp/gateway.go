@@ -0,0 +1,192 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	cache "github.com/rvflash/eve/rpc"
+)
+
+// gatewayPrefix is the path prefix of the keys.
+const gatewayPrefix = "/v1/keys/"
+
+// item is the JSON representation of one data.
+// TTL is the remaining time to live in seconds, if any.
+type item struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	TTL   float64     `json:"ttl,omitempty"`
+}
+
+func newItem(i *cache.Item) *item {
+	return &item{Key: i.Key, Value: i.Value, TTL: i.TTL.Seconds()}
+}
+
+// serveGateway starts the HTTP server used to expose the data as JSON.
+// As the bearer tokens must not travel in plain text, it uses the TLS configuration of the RPC server.
+func (s *Server) serveGateway() {
+	cfg, err := s.tlsConfig()
+	if err != nil {
+		s.log.Println("Gateway error: ", err)
+		return
+	}
+	srv := &http.Server{Addr: s.GatewayAddr, Handler: s.gateway(), TLSConfig: cfg}
+	s.log.Println("Serving HTTP gateway on " + s.GatewayAddr)
+	if cfg == nil {
+		err = srv.ListenAndServe()
+	} else {
+		// The certificates are given by the configuration.
+		err = srv.ListenAndServeTLS("", "")
+	}
+	if err != nil {
+		s.log.Println("Gateway error: ", err)
+	}
+}
+
+// gateway returns the routes of the HTTP gateway.
+func (s *Server) gateway() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(gatewayPrefix, s.KeyHandler)
+	mux.HandleFunc("/v1/keys", s.KeysHandler)
+	mux.HandleFunc("/v1/export", s.ExportHandler)
+	s.probes(mux)
+	return mux
+}
+
+// KeyHandler returns as JSON the data behind the key.
+func (s *Server) KeyHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+	var i cache.Item
+	if err := sess.Get(strings.TrimPrefix(r.URL.Path, gatewayPrefix), &i); err != nil {
+		s.jsonError(w, err)
+		return
+	}
+	s.jsonHandler(w, newItem(&i))
+}
+
+// KeysHandler returns as JSON the list of data whose key starts with the prefix.
+func (s *Server) KeysHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+	var items []*cache.Item
+	if err := sess.List(r.URL.Query().Get("prefix"), &items); err != nil {
+		s.jsonError(w, err)
+		return
+	}
+	res := make([]*item, len(items))
+	for k, v := range items {
+		res[k] = newItem(v)
+	}
+	s.jsonHandler(w, res)
+}
+
+// ExportHandler returns in the dotenv format the list of data
+// whose key starts with the prefix.
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+	var items []*cache.Item
+	if err := sess.List(r.URL.Query().Get("prefix"), &items); err != nil {
+		s.jsonError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	b := bufio.NewWriter(w)
+	for _, i := range items {
+		fmt.Fprintf(b, "%s=%s\n", i.Key, dotenv(i.Value))
+	}
+	if err := b.Flush(); err != nil {
+		s.log.Println("Gateway error: ", err)
+	}
+}
+
+// session returns a read-only session on the cache, authenticated with the bearer token
+// and scoped to the namespace given by the ns parameter.
+// On failure, the error is written and the boolean is false.
+func (s *Server) session(w http.ResponseWriter, r *http.Request) (*cache.Session, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	var ok bool
+	sess := cache.NewSession(s.rpc, s.Tokens)
+	if len(s.Tokens) > 0 {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if err := sess.Auth(token, &ok); err != nil {
+			s.jsonError(w, err)
+			return nil, false
+		}
+	}
+	if ns := r.URL.Query().Get("ns"); ns != "" {
+		if err := sess.Use(ns, &ok); err != nil {
+			s.jsonError(w, err)
+			return nil, false
+		}
+	}
+	return sess, true
+}
+
+// jsonHandler writes the data as JSON.
+func (s *Server) jsonHandler(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		s.log.Println("Gateway error: ", err)
+	}
+}
+
+// jsonError writes the error as JSON with the matching HTTP status code.
+func (s *Server) jsonError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch err {
+	case cache.ErrNotFound:
+		code = http.StatusNotFound
+	case cache.ErrUnauthorized:
+		code = http.StatusUnauthorized
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// dotenvEscaper escapes the characters interpreted by the dotenv loaders in double quotes.
+var dotenvEscaper = strings.NewReplacer(
+	`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`, "\t", `\t`,
+)
+
+// dotenv returns the value formatted for a dotenv file.
+// Strings are single-quoted when required, to be never expanded by the dotenv loaders.
+// With a single quote or a line break, they are double-quoted with escapes.
+func dotenv(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		for _, r := range v {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-./:@", r) {
+				continue
+			}
+			if !strings.ContainsAny(v, "'\r\n") {
+				return "'" + v + "'"
+			}
+			return `"` + dotenvEscaper.Replace(v) + `"`
+		}
+		return v
+	case []string:
+		return dotenv(strings.Join(v, ","))
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	cache "github.com/rvflash/eve/rpc"
+)
+
+func TestGateway(t *testing.T) {
+	s := NewServer("", 0)
+	defer func() { _ = s.rpc.Close() }()
+	s.Tokens = cache.Tokens{"r1": {Access: cache.ReadAccess}, "r2": {Access: cache.ReadAccess, Namespaces: []string{"beta"}}}
+	s.rpc.Sync(map[string]interface{}{"ALPHA_NAME": "eve", "ALPHA_LIST": []string{"a", "b"}, "BETA_NAME": "ok"}, nil)
+	srv := httptest.NewServer(s.gateway())
+	defer srv.Close()
+
+	var dt = []struct {
+		method, path, token string
+		code                int
+		body                string
+	}{
+		{path: "/v1/keys/ALPHA_NAME?ns=alpha", code: http.StatusUnauthorized},
+		{path: "/v1/keys/ALPHA_NAME?ns=alpha", token: "w1", code: http.StatusUnauthorized},
+		{method: http.MethodPost, path: "/v1/keys/ALPHA_NAME?ns=alpha", token: "r1", code: http.StatusMethodNotAllowed},
+		{path: "/v1/keys/ALPHA_NAME", token: "r1", code: http.StatusOK, body: `{"key":"ALPHA_NAME","value":"eve"}`},
+		{path: "/v1/keys/ALPHA_MISSING", token: "r1", code: http.StatusNotFound},
+		{path: "/v1/keys/ALPHA_NAME?ns=alpha", token: "r1", code: http.StatusOK, body: `{"key":"ALPHA_NAME","value":"eve"}`},
+		{path: "/v1/keys/ALPHA_NAME?ns=alpha", token: "r2", code: http.StatusUnauthorized},
+		{path: "/v1/keys/BETA_NAME?ns=beta", token: "r2", code: http.StatusOK, body: `{"key":"BETA_NAME","value":"ok"}`},
+		{path: "/v1/keys?ns=beta&prefix=BETA_", token: "r2", code: http.StatusOK, body: `[{"key":"BETA_NAME","value":"ok"}]`},
+		{path: "/v1/export?ns=alpha&prefix=ALPHA_N", token: "r1", code: http.StatusOK, body: "ALPHA_NAME=eve"},
+		{path: "/healthz", code: http.StatusOK, body: "ok"},
+	}
+	for i, tt := range dt {
+		if tt.method == "" {
+			tt.method = http.MethodGet
+		}
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %q", i, err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %q", i, err)
+		}
+		if resp.StatusCode != tt.code {
+			t.Errorf("%d. status code mismatch: exp=%d got=%d", i, tt.code, resp.StatusCode)
+		}
+		if got := strings.TrimSpace(string(b)); tt.body != "" && got != tt.body {
+			t.Errorf("%d. content mismatch: exp=%q got=%q", i, tt.body, got)
+		}
+	}
+}
+
+func TestGatewayTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	s := NewServer("", 0)
+	defer func() { _ = s.rpc.Close() }()
+	s.TLSCert, s.TLSKey = newCert(t, dir)
+	cfg, err := s.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(s.gateway())
+	srv.TLS = cfg
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	// The gateway only responds over TLS, verified with its certificate.
+	pem, err := ioutil.ReadFile(s.TLSCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := c.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status code mismatch: exp=%d got=%d", http.StatusOK, resp.StatusCode)
+	}
+	resp, err = http.Get(strings.Replace(srv.URL, "https", "http", 1) + "/healthz")
+	if err == nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Error("expected no response in plain text")
+		}
+	}
+}
+
+func TestDotenv(t *testing.T) {
+	var dt = []struct {
+		in  interface{}
+		out string
+	}{
+		{in: nil, out: ""},
+		{in: 42, out: "42"},
+		{in: true, out: "true"},
+		{in: "eve", out: "eve"},
+		{in: "http://localhost:8080/vars", out: "http://localhost:8080/vars"},
+		{in: []string{"a", "b"}, out: "'a,b'"},
+		{in: "a b", out: "'a b'"},
+		{in: "${HOME}", out: "'${HOME}'"},
+		{in: "it's $b\n", out: `"it's \$b\n"`},
+	}
+	for i, tt := range dt {
+		if out := dotenv(tt.in); out != tt.out {
+			t.Errorf("%d. content mismatch: exp=%q got=%q", i, tt.out, out)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// probes adds the liveness and readiness checks to the routes.
+func (s *Server) probes(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", s.LiveHandler)
+	mux.HandleFunc("/readyz", s.ReadyHandler)
+}
+
+// LiveHandler responds while the server is running.
+func (s *Server) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.WriteString(w, "ok")
+}
+
+// ReadyHandler responds successfully once the data loaded and the RPC server started,
+// until its shutdown.
+func (s *Server) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = io.WriteString(w, "ok")
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+
+	cache "github.com/rvflash/eve/rpc"
+)
+
+// load fetches the data behind the source URL and applies their changes
+// since the previous loading.
+func (s *Server) load() error {
+	if s.from == "" {
+		return nil
+	}
+	data, err := cache.Fetch(s.from)
+	if err != nil {
+		return err
+	}
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+
+	n := s.rpc.Sync(data, s.loaded)
+	s.loaded = data
+	s.log.Printf("Loaded from %s: %d change(s) applied\n", s.from, n)
+
+	return nil
+}
+
+// resync periodically reloads the data behind the source URL.
+func (s *Server) resync() {
+	tick := time.NewTicker(s.ResyncInterval)
+	defer tick.Stop()
+	for range tick.C {
+		if err := s.load(); err != nil {
+			s.log.Println("Loader in error: ", err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	cache "github.com/rvflash/eve/rpc"
+)
+
+// metricsPrefix is the namespace of all the exposed metrics.
+const metricsPrefix = "eve_cache_"
+
+// MetricsHandler exposes the cache's metrics in the Prometheus text format.
+func (s *Server) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var m cache.Metrics
+	if err := s.rpc.Stats(true, &m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	b := bufio.NewWriter(w)
+	writeMetrics(b, &m, time.Now())
+	if err := b.Flush(); err != nil {
+		s.log.Println("Metrics error: ", err)
+	}
+}
+
+// writeMetrics writes the metrics in the Prometheus text exposition format.
+func writeMetrics(w *bufio.Writer, m *cache.Metrics, now time.Time) {
+	// Prints the header of each metric.
+	meta := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+	}
+	// Prints one sample.
+	sample := func(name, labels string, value interface{}) {
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(w, "%s%s%s %v\n", metricsPrefix, name, labels, value)
+	}
+	float := func(f float64) string {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	meta("items", "gauge", "Number of items in the cache.")
+	sample("items", "", m.Items)
+	meta("uptime_seconds", "gauge", "Number of seconds since the start of the cache.")
+	sample("uptime_seconds", "", float(m.UpTime.Seconds()))
+	meta("revision", "counter", "Number of changes applied on the data.")
+	sample("revision", "", m.Revision)
+	meta("last_bulk_timestamp_seconds", "gauge", "Unix time of the last bulk received.")
+	meta("seconds_since_last_bulk", "gauge", "Number of seconds since the last bulk, or since the start without bulk.")
+	last := m.LastBulk
+	if last.IsZero() {
+		sample("last_bulk_timestamp_seconds", "", 0)
+		last = now.Add(-m.UpTime)
+	} else {
+		sample("last_bulk_timestamp_seconds", "", last.Unix())
+	}
+	sample("seconds_since_last_bulk", "", float(now.Sub(last).Seconds()))
+
+	meta("requests_total", "counter", "Number of requests by method.")
+	for _, v := range []struct {
+		method string
+		count  uint64
+	}{
+		{"Bulk", m.Bulk},
+		{"Clear", m.Clear},
+		{"Delete", m.Delete},
+		{"Get", m.Get},
+		{"Put", m.Put},
+	} {
+		sample("requests_total", `method="`+v.method+`"`, v.count)
+	}
+
+	names := make([]string, 0, len(m.Namespaces))
+	for k := range m.Namespaces {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	meta("namespace_items", "gauge", "Number of items by namespace.")
+	for _, k := range names {
+		sample("namespace_items", `namespace="`+k+`"`, m.Namespaces[k].Items)
+	}
+	meta("namespace_quota", "gauge", "Maximum number of items by namespace, 0 means unlimited.")
+	for _, k := range names {
+		sample("namespace_quota", `namespace="`+k+`"`, m.Namespaces[k].Quota)
+	}
+
+	methods := make([]string, 0, len(m.Latency))
+	for k := range m.Latency {
+		methods = append(methods, k)
+	}
+	sort.Strings(methods)
+	meta("request_duration_seconds", "histogram", "Latency of the requests by method.")
+	for _, k := range methods {
+		h := m.Latency[k]
+		label := `method="` + k + `"`
+		for i, n := range h.Cumulative() {
+			le := "+Inf"
+			if i < len(h.Buckets) {
+				le = float(h.Buckets[i])
+			}
+			sample("request_duration_seconds_bucket", label+`,le="`+le+`"`, n)
+		}
+		sample("request_duration_seconds_sum", label, float(h.Sum))
+		sample("request_duration_seconds_count", label, h.Count)
+	}
+}
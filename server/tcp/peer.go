@@ -0,0 +1,76 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/rpc"
+	"time"
+
+	"github.com/rvflash/eve/client"
+)
+
+// DefaultSyncInterval is the default duration between two anti-entropy sessions.
+const DefaultSyncInterval = 30 * time.Second
+
+// replicate periodically pulls from each peer the missing or newer data.
+func (s *Server) replicate() {
+	every := s.SyncInterval
+	if every <= 0 {
+		every = DefaultSyncInterval
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for range tick.C {
+		for _, addr := range s.Peers {
+			n, err := s.pull(addr, every)
+			if err != nil {
+				s.log.Printf("Sync with %s failed: %s\n", addr, err)
+				continue
+			}
+			if n > 0 {
+				s.log.Printf("Sync with %s: %d change(s) applied\n", addr, n)
+			}
+		}
+	}
+}
+
+// pull connects to the peer to synchronize the local cache with it.
+// The whole session must be done before the given timeout.
+func (s *Server) pull(addr string, timeout time.Duration) (int, error) {
+	conn, err := s.dial(addr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		_ = conn.Close()
+		return 0, err
+	}
+	c := rpc.NewClient(conn)
+	defer func() { _ = c.Close() }()
+
+	if s.PeerToken != "" {
+		var ok bool
+		if err = c.Call("Cache.Auth", s.PeerToken, &ok); err != nil {
+			return 0, err
+		}
+	}
+	return s.rpc.Pull(c)
+}
+
+// dial connects to the peer.
+// With TLS, the peers are expected to share the same certificate authority,
+// the server's certificate is used as client one.
+func (s *Server) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	if s.TLSCert == "" {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	cfg, err := client.NewTLSConfig(s.ClientCA, s.TLSCert, s.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, cfg)
+}
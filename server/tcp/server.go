@@ -5,52 +5,240 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"net/rpc"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/pkg/errors"
 	cache "github.com/rvflash/eve/rpc"
 )
 
+// DefaultDrainTimeout is the default maximum duration to wait for the calls in progress on shutdown.
+const DefaultDrainTimeout = 10 * time.Second
+
 // Server represents the default server's configuration.
 type Server struct {
 	Host string
 	Port int
-	log  *log.Logger
-	rpc  *cache.Cache
+	// MetricsAddr is the optional HTTP net address used to expose the metrics.
+	MetricsAddr string
+	// GatewayAddr is the optional HTTP net address used to expose the data as JSON.
+	GatewayAddr string
+	// Peers lists the net addresses of the other cache servers to synchronize with,
+	// every SyncInterval.
+	Peers        []string
+	SyncInterval time.Duration
+	// TLSCert and TLSKey are the files of the certificate used to secure the connections.
+	// With ClientCA, the server requires and verifies the certificate of the clients.
+	TLSCert, TLSKey, ClientCA string
+	// Tokens lists the access level by token. Without, no authentication is required.
+	// PeerToken is the token used to authenticate with the peers.
+	Tokens    cache.Tokens
+	PeerToken string
+	// Quota is the maximum number of items by namespace, 0 means unlimited.
+	// Quotas overrides it for some namespaces.
+	Quota  uint64
+	Quotas map[string]uint64
+	// ResyncInterval is the optional duration between two reloads of the data source.
+	// DrainTimeout is the maximum duration to wait for the calls in progress on shutdown.
+	ResyncInterval time.Duration
+	DrainTimeout   time.Duration
+	log            *log.Logger
+	rpc            *cache.Cache
+
+	from     string
+	loaded   map[string]interface{}
+	loadMu   sync.Mutex
+	conns    map[net.Conn]struct{}
+	connMu   sync.Mutex
+	inflight int64
+	ready    int32
+	stopping int32
 }
 
 // NewServer returns an instance of Server.
 func NewServer(listenIP string, port int) *Server {
 	return &Server{
-		Host: listenIP,
-		Port: port,
-		log:  log.New(os.Stdout, "server> ", log.Ltime|log.Lshortfile),
-		rpc:  cache.New(),
+		Host:  listenIP,
+		Port:  port,
+		log:   log.New(os.Stdout, "server> ", log.Ltime|log.Lshortfile),
+		rpc:   cache.New(),
+		conns: make(map[net.Conn]struct{}),
 	}
 }
 
-// Serve starts the server.
-func (s *Server) Serve(fromURL string) {
+// Serve starts the server and blocks until its shutdown.
+// SIGHUP reloads the data from the source URL,
+// SIGTERM or SIGINT stops the server after the end of the calls in progress.
+func (s *Server) Serve(fromURL string) error {
 	// Uses this URL as JSON data source on loading.
-	if fromURL != "" {
-		var err error
-		if s.rpc, err = cache.NewFrom(fromURL); err != nil {
-			log.Fatal("Loader in error: ", err)
-		}
+	s.from = fromURL
+	if err := s.load(); err != nil {
+		return errors.WithMessage(err, "loader")
 	}
-	// Initializes the RPC server.
-	if err := rpc.Register(s.rpc); err != nil {
-		log.Fatal("Register error: ", err)
+	// Limits the number of items by namespace.
+	s.rpc.SetDefaultQuota(s.Quota)
+	for ns, max := range s.Quotas {
+		s.rpc.SetQuota(ns, max)
 	}
-	// Launches it.
+	// Launches the RPC server.
 	addr := s.Host + ":" + strconv.Itoa(s.Port)
 	s.log.Println("Serving " + addr)
-	l, err := net.Listen("tcp", addr)
+	l, err := s.listen(addr)
 	if err != nil {
-		s.log.Fatal("Listen error: ", err)
+		return errors.WithMessage(err, "listen")
+	}
+	go s.handleSignals(l)
+
+	// Exposes the metrics if required.
+	if s.MetricsAddr != "" {
+		go s.serveMetrics()
+	}
+	// Exposes the data with the HTTP gateway if required.
+	if s.GatewayAddr != "" {
+		go s.serveGateway()
+	}
+	// Synchronizes the data with the peers.
+	if len(s.Peers) > 0 {
+		go s.replicate()
+	}
+	// Reloads the data source.
+	if s.from != "" && s.ResyncInterval > 0 {
+		go s.resync()
+	}
+	atomic.StoreInt32(&s.ready, 1)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&s.stopping) == 1 {
+				return s.drain()
+			}
+			return errors.WithMessage(err, "accept")
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// handleSignals reloads the data on SIGHUP and stops listening on SIGTERM or SIGINT.
+func (s *Server) handleSignals(l net.Listener) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	for sig := range c {
+		if sig == syscall.SIGHUP {
+			if err := s.load(); err != nil {
+				s.log.Println("Loader in error: ", err)
+			}
+			continue
+		}
+		s.log.Println("Shutting down...")
+		signal.Stop(c)
+		atomic.StoreInt32(&s.ready, 0)
+		atomic.StoreInt32(&s.stopping, 1)
+		if err := l.Close(); err != nil {
+			s.log.Println("Listen error: ", err)
+		}
+		return
+	}
+}
+
+// drain waits for the end of the calls in progress, until the drain timeout,
+// then closes the connections and the cache.
+func (s *Server) drain() error {
+	timeout := s.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&s.inflight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&s.inflight); n > 0 {
+		s.log.Printf("Drain timeout: %d call(s) in progress\n", n)
+	}
+	s.connMu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.connMu.Unlock()
+
+	return s.rpc.Close()
+}
+
+// listen announces on the local network address, with TLS if a certificate is given.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	cfg, err := s.tlsConfig()
+	switch {
+	case err != nil:
+		return nil, err
+	case cfg == nil:
+		return net.Listen("tcp", addr)
+	}
+	return tls.Listen("tcp", addr, cfg)
+}
+
+// tlsConfig returns the TLS configuration of the server, nil without certificate.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if s.ClientCA != "" {
+		pem, err := ioutil.ReadFile(s.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.WithMessage(errors.New("no valid certificate"), s.ClientCA)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// serveConn serves the cache on this connection with its own session,
+// in order to authenticate each client.
+func (s *Server) serveConn(conn net.Conn) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Cache", cache.NewSession(s.rpc, s.Tokens)); err != nil {
+		s.log.Println("Register error: ", err)
+		_ = conn.Close()
+		return
+	}
+	// Tracks the connection to close it on shutdown.
+	s.connMu.Lock()
+	s.conns[conn] = struct{}{}
+	s.connMu.Unlock()
+	defer func() {
+		s.connMu.Lock()
+		delete(s.conns, conn)
+		s.connMu.Unlock()
+	}()
+	srv.ServeCodec(&countCodec{ServerCodec: sniff(conn), n: &s.inflight})
+}
+
+// serveMetrics starts the HTTP server used to expose the metrics.
+func (s *Server) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.MetricsHandler)
+	s.probes(mux)
+	s.log.Println("Serving metrics on " + s.MetricsAddr)
+	if err := http.ListenAndServe(s.MetricsAddr, mux); err != nil {
+		s.log.Println("Metrics error: ", err)
 	}
-	rpc.Accept(l)
 }
@@ -0,0 +1,96 @@
+// Copyright (c) 2017 Hervé Gouchet. All rights reserved.
+// Use of this source code is governed by the MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCert writes in the directory a self-signed certificate for the local address and its key.
+func newCert(t *testing.T, dir string) (cert, key string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "eve"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	cert, key := newCert(t, dir)
+
+	var dt = []struct {
+		cert, key, ca string
+		auth          tls.ClientAuthType
+		isNil, onErr  bool
+	}{
+		{isNil: true},
+		{cert: cert, key: key, auth: tls.NoClientCert},
+		{cert: cert, key: key, ca: cert, auth: tls.RequireAndVerifyClientCert},
+		{cert: cert, key: cert, onErr: true},
+		{cert: cert, key: key, ca: key, onErr: true},
+		{cert: cert, key: key, ca: filepath.Join(dir, "ca.pem"), onErr: true},
+	}
+	for i, tt := range dt {
+		s := NewServer("", 0)
+		s.TLSCert, s.TLSKey, s.ClientCA = tt.cert, tt.key, tt.ca
+		cfg, err := s.tlsConfig()
+		if tt.onErr != (err != nil) {
+			t.Fatalf("%d. error mismatch: error expected=%t got=%q", i, tt.onErr, err)
+		}
+		if tt.onErr {
+			continue
+		}
+		if tt.isNil != (cfg == nil) {
+			t.Fatalf("%d. config mismatch: nil expected=%t got=%v", i, tt.isNil, cfg)
+		}
+		if cfg != nil && cfg.ClientAuth != tt.auth {
+			t.Errorf("%d. client auth mismatch: exp=%v got=%v", i, tt.auth, cfg.ClientAuth)
+		}
+	}
+}
@@ -6,7 +6,11 @@ package main
 
 import (
 	"flag"
+	"log"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/rvflash/eve/rpc"
 )
@@ -19,8 +23,60 @@ func main() {
 	host := flag.String("host", "", "host addr to listen on")
 	port := flag.Int("port", rpc.DefaultPort, "service port")
 	from := flag.String("from", "", "URL to fetch to get JSON data to use as default values")
+	metrics := flag.String("metrics", "", "HTTP addr to listen on to expose the metrics, like :9091")
+	gateway := flag.String("http", "", "HTTP addr to listen on to expose the data as JSON, like :8090")
+	peers := flag.String("peers", "", "comma-separated list of the net addresses of the other cache servers")
+	syncEvery := flag.Duration("sync", DefaultSyncInterval, "interval between two synchronizations with the peers")
+	tlsCert := flag.String("tls-cert", "", "certificate file used to secure the connections with TLS")
+	tlsKey := flag.String("tls-key", "", "private key file of the TLS certificate")
+	clientCA := flag.String("client-ca", "", "certificate authority file used to verify the certificate of the clients")
+	tokens := flag.String("tokens", "", "file listing the tokens, one by line, as read:token or write:token, or read@alpha,beta:token to limit it to namespaces")
+	peerToken := flag.String("peer-token", "", "token used to authenticate with the peers")
+	quota := flag.Uint64("quota", 0, "maximum number of items by namespace, 0 means unlimited")
+	resync := flag.Duration("resync", 0, "interval between two reloads of the data from the URL, 0 to disable")
+	drain := flag.Duration("drain", DefaultDrainTimeout, "maximum duration to wait for the calls in progress on shutdown")
+	quotas := flag.String("quotas", "", "comma-separated list of quotas by namespace, like alpha=100,beta=50")
 	flag.Parse()
 
 	// Try to connect to the local database.
-	NewServer(*host, *port).Serve(*from)
+	s := NewServer(*host, *port)
+	s.MetricsAddr = *metrics
+	s.GatewayAddr = *gateway
+	s.Peers = strings.FieldsFunc(*peers, func(r rune) bool {
+		return r == ','
+	})
+	s.SyncInterval = *syncEvery
+	s.TLSCert, s.TLSKey, s.ClientCA = *tlsCert, *tlsKey, *clientCA
+	if *tokens != "" {
+		f, err := os.Open(*tokens)
+		if err != nil {
+			log.Fatal("Tokens error: ", err)
+		}
+		s.Tokens, err = rpc.ParseTokens(f)
+		_ = f.Close()
+		if err != nil {
+			log.Fatal("Tokens error: ", err)
+		}
+	}
+	s.PeerToken = *peerToken
+	s.Quota = *quota
+	s.Quotas = make(map[string]uint64)
+	for _, q := range strings.FieldsFunc(*quotas, func(r rune) bool {
+		return r == ','
+	}) {
+		d := strings.SplitN(q, "=", 2)
+		if len(d) != 2 {
+			log.Fatal("Quotas error: ", q)
+		}
+		max, err := strconv.ParseUint(d[1], 10, 64)
+		if err != nil {
+			log.Fatal("Quotas error: ", err)
+		}
+		s.Quotas[d[0]] = max
+	}
+	s.ResyncInterval = *resync
+	s.DrainTimeout = *drain
+	if err := s.Serve(*from); err != nil {
+		log.Fatal("Server error: ", err)
+	}
 }